@@ -7,17 +7,25 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"github.com/fluxor-api-gateway/internal/server"
-	"github.com/fluxor-api-gateway/pkg/config"
+	"github.com/kannan112/gateway-structure/internal/server"
+	"github.com/kannan112/gateway-structure/pkg/apikey"
+	"github.com/kannan112/gateway-structure/pkg/audit"
+	"github.com/kannan112/gateway-structure/pkg/config"
+	"github.com/kannan112/gateway-structure/pkg/idempotency"
+	"github.com/kannan112/gateway-structure/pkg/introspection"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/redact"
+	"github.com/kannan112/gateway-structure/pkg/revocation"
+	"github.com/kannan112/gateway-structure/pkg/service"
+	"github.com/kannan112/gateway-structure/pkg/utils"
+	"github.com/kannan112/gateway-structure/pkg/validation"
+	"github.com/kannan112/gateway-structure/pkg/wasm"
 	"go.uber.org/zap"
 )
 
 func main() {
-	// Initialize logger'
-	logger, _ := zap.NewProduction()
-	defer logger.Sync()
-
 	// Load configuration
 	config, err := config.LoadConfig()
 	if err != nil {
@@ -26,10 +34,161 @@ func main() {
 	}
 
 	// Create server options
-	opts := server.DefaultOptions(&config)
+	opts, err := server.DefaultOptions(&config)
+	if err != nil {
+		log.Fatalf("invalid config %s", err)
+		return
+	}
+
+	// Initialize the logger shared by every component, its level can be changed at runtime
+	logger, logLevel, err := utils.NewLogger(&opts.Log)
+	if err != nil {
+		log.Fatalf("failed to initialize logger %s", err)
+		return
+	}
+	defer logger.Sync()
+	utils.SetLogger(logger)
+	if config.JWTSecret != "" {
+		middleware.SetJWTSecret(config.JWTSecret)
+	}
+
+	// Extra validation rules extend or override the built-in user service rules
+	if config.ValidationRulesFile != "" {
+		rules, err := validation.LoadRules(config.ValidationRulesFile)
+		if err != nil {
+			logger.Fatal("Failed to load validation rules", zap.Error(err))
+		}
+		validation.SetDefault(validation.New(validation.DefaultRules, rules))
+	}
+
+	// Configured middleware chains replace the built-in ones key by key
+	if config.MiddlewareChainsFile != "" {
+		chains, err := middleware.LoadChains(config.MiddlewareChainsFile)
+		if err != nil {
+			logger.Fatal("Failed to load middleware chains", zap.Error(err))
+		}
+		opts.Chains = opts.Chains.Merge(chains)
+	}
+
+	// Personal data is redacted from logs and the audit trail
+	var redactRules redact.Rules
+	if config.RedactRulesFile != "" {
+		redactRules, err = redact.LoadRules(config.RedactRulesFile)
+		if err != nil {
+			logger.Fatal("Failed to load redaction rules", zap.Error(err))
+		}
+	}
+	redact.SetDefault(redact.New([]byte(config.RedactHashKey), redact.DefaultRules, redactRules))
+
+	// Set up API key authentication, keys only survive restarts with a key file
+	var keyStore apikey.Store = apikey.NewMemoryStore()
+	if opts.APIKeyFile != "" {
+		keyStore, err = apikey.NewFileStore(opts.APIKeyFile)
+		if err != nil {
+			logger.Fatal("Failed to load API keys", zap.Error(err))
+		}
+	}
+	opts.APIKeys = apikey.NewManager(keyStore)
+	middleware.SetAPIKeyManager(opts.APIKeys)
+	middleware.SetCertificateScopes(opts.CertScopes)
+
+	// Set up the token denylist, Redis shares revocations between gateway instances
+	if opts.RevocationRedis.Address != "" {
+		store, err := revocation.NewRedisStore(opts.RevocationRedis)
+		if err != nil {
+			logger.Fatal("Failed to connect to revocation store", zap.Error(err))
+		}
+		defer store.Close()
+		opts.Revocations = store
+	} else {
+		store := revocation.NewMemoryStore(time.Minute)
+		defer store.Close()
+		opts.Revocations = store
+	}
+	middleware.SetRevocationStore(opts.Revocations)
+
+	// Responses to retried mutations are replayed from the idempotency store
+	idempotencyStore := idempotency.NewMemoryStore(time.Minute)
+	defer idempotencyStore.Close()
+	opts.Idempotency = idempotencyStore
+
+	// Changes to users are written to a tamper-evident audit trail, continuing the chain of the existing file
+	if opts.AuditFile.Path != "" {
+		sink, last, err := audit.NewFileSink(opts.AuditFile)
+		if err != nil {
+			logger.Fatal("Failed to open audit log", zap.Error(err), zap.String("path", opts.AuditFile.Path))
+		}
+		opts.Audit = audit.New(sink, last)
+		defer opts.Audit.Close()
+	}
+
+	// WebAssembly filters are compiled up front and run where a chain names them as "wasm:<name>"
+	if opts.WASMFiltersFile != "" {
+		filterConfigs, err := wasm.LoadConfig(opts.WASMFiltersFile)
+		if err != nil {
+			logger.Fatal("Failed to load WASM filters", zap.Error(err))
+		}
+		filters, err := wasm.NewFilters(context.Background(), filterConfigs, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize WASM filters", zap.Error(err))
+		}
+		defer filters.Close(context.Background())
+		opts.WASMFilters = filters
+	}
+
+	// Opaque tokens are only accepted when an introspection endpoint is configured
+	if opts.Introspection.Endpoint != "" {
+		introspector, err := introspection.New(opts.Introspection)
+		if err != nil {
+			logger.Fatal("Failed to initialize token introspection", zap.Error(err))
+		}
+		defer introspector.Close()
+		middleware.SetIntrospector(introspector)
+	}
+
+	// The user service is optional, its REST and gRPC routes are only served when configured.
+	// Subsets split its traffic between versions and replace USER_SERVICE_URL.
+	if opts.UserSubsetsFile != "" {
+		canary, err := service.LoadCanaryConfig(opts.UserSubsetsFile)
+		if err != nil {
+			logger.Fatal("Failed to load user service subsets", zap.Error(err))
+		}
+		userService, err := service.NewCanaryUserService(opts.UserService, canary)
+		if err != nil {
+			logger.Fatal("Failed to initialize user service subsets", zap.Error(err))
+		}
+		defer userService.Close()
+		opts.Users = userService
+	} else if opts.UserService.Address != "" {
+		userService, err := service.NewUserService(opts.UserService)
+		if err != nil {
+			logger.Fatal("Failed to initialize user service",
+				zap.Error(err),
+				zap.String("user_service", opts.UserService.Address),
+			)
+		}
+		defer userService.Close()
+		opts.Users = userService
+	}
+
+	// Sampled reads are copied to a shadow user service, it must never hold up the gateway
+	if opts.Users != nil && opts.Mirror.Address != "" {
+		mirrored, err := service.NewMirroredUserService(opts.Users, opts.UserService, opts.Mirror, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize user service mirror",
+				zap.Error(err),
+				zap.String("mirror", opts.Mirror.Address),
+			)
+		}
+		defer mirrored.Close()
+		opts.Users = mirrored
+	}
 
 	// Initialize servers
-	httpServer := server.NewHTTPServer(opts, logger)
+	httpServer, err := server.NewHTTPServer(opts, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize HTTP server", zap.Error(err))
+	}
 	grpcServer, err := server.NewGRPCServer(opts, logger)
 
 	if err != nil {
@@ -54,6 +213,29 @@ func main() {
 		}
 	}()
 
+	// SIGHUP reloads the configuration, as the admin server's reload endpoint does
+	reloader := server.NewConfigReloader(config, logger, logLevel)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := reloader.Reload(); err != nil {
+				logger.Error("Failed to reload config", zap.Error(err))
+			}
+		}
+	}()
+
+	// The admin server only starts when it is protected by a token
+	var adminServer *server.AdminServer
+	if opts.AdminToken != "" {
+		adminServer = server.NewAdminServer(opts, logger, reloader, httpServer, grpcServer)
+		go func() {
+			if err := adminServer.Start(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start admin server", zap.Error(err))
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -68,6 +250,11 @@ func main() {
 		logger.Error("Failed to stop HTTP server", zap.Error(err))
 	}
 	grpcServer.Stop()
+	if adminServer != nil {
+		if err := adminServer.Stop(ctx); err != nil {
+			logger.Error("Failed to stop admin server", zap.Error(err))
+		}
+	}
 
 	logger.Info("Servers stopped successfully")
 }
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gorilla/mux"
+	"github.com/kannan112/gateway-structure/pkg/circuit"
+	"github.com/kannan112/gateway-structure/pkg/concurrency"
+	"github.com/kannan112/gateway-structure/pkg/config"
+	"github.com/kannan112/gateway-structure/pkg/fault"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/service"
+	"go.uber.org/zap"
+)
+
+// Build metadata, set with -ldflags "-X github.com/kannan112/gateway-structure/internal/server.Version=..."
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// AdminServer serves runtime inspection and control endpoints on a separate listener
+type AdminServer struct {
+	server  *http.Server
+	router  *mux.Router
+	logger  *zap.Logger
+	options *Options
+	reload  *ConfigReloader
+	http    *HTTPServer
+	grpc    *GRPCServer
+}
+
+func NewAdminServer(opts *Options, logger *zap.Logger, reload *ConfigReloader, httpServer *HTTPServer, grpcServer *GRPCServer) *AdminServer {
+	router := mux.NewRouter()
+
+	server := &AdminServer{
+		router:  router,
+		logger:  logger,
+		options: opts,
+		reload:  reload,
+		http:    httpServer,
+		grpc:    grpcServer,
+		server: &http.Server{
+			Addr:              opts.AdminPort,
+			Handler:           router,
+			ReadTimeout:       opts.ReadTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+			MaxHeaderBytes:    opts.MaxHeaderBytes,
+		},
+	}
+
+	server.setupRoutes()
+
+	return server
+}
+
+func (s *AdminServer) setupRoutes() {
+	s.router.Use(middleware.Recovery(s.logger))
+	s.router.Use(middleware.RequireToken(s.options.AdminToken))
+
+	// Inspection
+	s.router.HandleFunc("/config", s.getConfig).Methods("GET")
+	s.router.HandleFunc("/routes", s.getRoutes).Methods("GET")
+	s.router.HandleFunc("/upstreams", s.getUpstreams).Methods("GET")
+	s.router.HandleFunc("/ratelimits", s.getRateLimits).Methods("GET")
+	s.router.HandleFunc("/buildinfo", s.getBuildInfo).Methods("GET")
+	s.router.HandleFunc("/middleware", s.getMiddleware).Methods("GET")
+	s.router.HandleFunc("/faults", s.getFaults).Methods("GET")
+
+	// Control, zap's AtomicLevel handles GET and PUT {"level":"debug"} itself
+	s.router.Handle("/loglevel", s.reload.Level()).Methods("GET", "PUT")
+	s.router.HandleFunc("/upstreams/{name}/drain", s.drainUpstream).Methods("POST", "DELETE")
+	s.router.HandleFunc("/upstreams/{name}/subsets", s.setSubsetWeights).Methods("PUT")
+	s.router.HandleFunc("/faults", s.enableFaults).Methods("PUT")
+	s.router.HandleFunc("/faults", s.disableFaults).Methods("DELETE")
+	s.router.HandleFunc("/config/reload", s.reloadConfig).Methods("POST")
+}
+
+func (s *AdminServer) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, config.Redacted(s.reload.Config()))
+}
+
+func (s *AdminServer) getRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"http": s.http.Routes(),
+		"grpc": s.grpc.Services(),
+	})
+}
+
+type upstreamInfo struct {
+	Name     string                `json:"name"`
+	Address  string                `json:"address"`
+	State    string                `json:"state"`
+	Draining bool                  `json:"draining"`
+	Subsets  []service.SubsetStats `json:"subsets,omitempty"`
+	Mirror   *service.MirrorStats  `json:"mirror,omitempty"`
+	Circuit  *circuit.Stats        `json:"circuit,omitempty"`
+	// Concurrency is the adaptive concurrency limiter of the upstream
+	Concurrency *concurrency.Stats `json:"concurrency,omitempty"`
+}
+
+func (s *AdminServer) getUpstreams(w http.ResponseWriter, r *http.Request) {
+	var upstreams []upstreamInfo
+	for _, u := range s.grpc.Upstreams() {
+		info := upstreamInfo{
+			Name:     u.Name(),
+			Address:  u.Address(),
+			State:    u.State().String(),
+			Draining: u.Draining(),
+		}
+		if router, ok := service.AsSubsetRouter(u); ok {
+			info.Subsets = router.SubsetStats()
+		}
+		if breaker, ok := u.(service.CircuitBreaker); ok {
+			stats := breaker.CircuitStats()
+			info.Circuit = &stats
+		}
+		if limited, ok := u.(service.Limited); ok {
+			info.Concurrency = limited.ConcurrencyStats()
+		}
+		if mirrored, ok := u.(service.Mirrored); ok {
+			stats := mirrored.MirrorStats()
+			info.Mirror = &stats
+		}
+		upstreams = append(upstreams, info)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"upstreams": upstreams})
+}
+
+// getMiddleware shows the effective middleware chain of every route and method, and the available plugins
+func (s *AdminServer) getMiddleware(w http.ResponseWriter, r *http.Request) {
+	httpPlugins, grpcPlugins := s.http.registry.Names()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"http": s.http.Chains(),
+		"grpc": s.grpc.Chains(),
+		"plugins": map[string][]string{
+			"http": httpPlugins,
+			"grpc": grpcPlugins,
+			"wasm": s.options.WASMFilters.Names(),
+		},
+	})
+}
+
+func (s *AdminServer) getRateLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"limiters": middleware.RateLimitStats()})
+}
+
+func (s *AdminServer) getBuildInfo(w http.ResponseWriter, r *http.Request) {
+	info := map[string]interface{}{
+		"version":    Version,
+		"commit":     Commit,
+		"go_version": runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["module"] = bi.Main.Path
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				info[setting.Key] = setting.Value
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+// drainUpstream starts draining on POST and stops it on DELETE
+func (s *AdminServer) drainUpstream(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	draining := r.Method == http.MethodPost
+
+	for _, u := range s.grpc.Upstreams() {
+		if u.Name() == name {
+			u.SetDraining(draining)
+			s.logger.Info("Upstream draining changed", zap.String("upstream", name), zap.Bool("draining", draining))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown upstream"})
+}
+
+// setSubsetWeights changes the traffic split of an upstream with subsets, e.g. {"weights": {"v1": 50, "v2": 50}}
+func (s *AdminServer) setSubsetWeights(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req struct {
+		Weights map[string]int `json:"weights"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Weights) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected {\"weights\": {\"<subset>\": <weight>}}"})
+		return
+	}
+
+	for _, u := range s.grpc.Upstreams() {
+		if u.Name() != name {
+			continue
+		}
+		router, ok := service.AsSubsetRouter(u)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "upstream has no subsets"})
+			return
+		}
+		if err := router.SetWeights(req.Weights); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s.logger.Info("Upstream subset weights changed", zap.String("upstream", name), zap.Any("weights", req.Weights))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"subsets": router.SubsetStats()})
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown upstream"})
+}
+
+// getFaults shows whether fault injection is enabled and its rules with their counts
+func (s *AdminServer) getFaults(w http.ResponseWriter, r *http.Request) {
+	enabled, rules := s.options.Faults.Rules()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": enabled, "rules": rules})
+}
+
+// enableFaults replaces the fault rules and enables injection, e.g.
+// {"rules": [{"route": "/api/v1/users", "percent": 10, "abort_http": 503}]}
+func (s *AdminServer) enableFaults(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rules []fault.Rule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected {\"rules\": [...]}"})
+		return
+	}
+
+	if err := s.options.Faults.Enable(req.Rules); err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, fault.ErrProduction) {
+			code = http.StatusForbidden
+		}
+		writeJSON(w, code, map[string]string{"error": err.Error()})
+		return
+	}
+	s.logger.Warn("Fault injection enabled", zap.Int("rules", len(req.Rules)))
+
+	enabled, rules := s.options.Faults.Rules()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": enabled, "rules": rules})
+}
+
+// disableFaults stops fault injection
+func (s *AdminServer) disableFaults(w http.ResponseWriter, r *http.Request) {
+	s.options.Faults.Disable()
+	s.logger.Info("Fault injection disabled")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadConfig re-reads the configuration and applies the settings that can change at runtime
+func (s *AdminServer) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := s.reload.Reload()
+	if err != nil {
+		s.logger.Error("Failed to reload config", zap.Error(err))
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to reload config"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *AdminServer) Start() error {
+	s.logger.Info("Starting admin server", zap.String("port", s.options.AdminPort))
+	return s.server.ListenAndServe()
+}
+
+func (s *AdminServer) Stop(ctx context.Context) error {
+	s.logger.Info("Stopping admin server")
+	return s.server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+
+	"github.com/kannan112/gateway-structure/pkg/graphql"
+	"github.com/kannan112/gateway-structure/pkg/handlers"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// newGraphQLSchema exposes the UserService RPCs through GraphQL, resolved by the same handler as REST.
+// AuthService defines no RPCs yet, it joins the schema through graphql.Service once it does.
+func newGraphQLSchema(users *handlers.UserHandler) (*graphql.Schema, error) {
+	return graphql.NewSchema(graphql.Service{
+		Descriptor: userpb.File_pkg_proto_user_user_proto.Services().ByName("UserService"),
+		Impl:       users,
+		Batchers: map[protoreflect.Name]graphql.Batcher{
+			"GetUser": getUserBatcher(users),
+		},
+	})
+}
+
+// getUserBatcher resolves the GetUser calls of one GraphQL request with a single BatchGetUsers
+func getUserBatcher(users *handlers.UserHandler) graphql.Batcher {
+	return func(ctx context.Context, reqs []proto.Message) ([]proto.Message, []error) {
+		ids := make([]string, len(reqs))
+		for i, req := range reqs {
+			ids[i] = req.(*userpb.GetUserRequest).UserId
+		}
+
+		resps := make([]proto.Message, len(reqs))
+		errs := make([]error, len(reqs))
+		batch, err := users.BatchGetUsers(ctx, &userpb.BatchGetUsersRequest{UserIds: ids})
+		if err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+			return resps, errs
+		}
+
+		for i, result := range batch.Results {
+			if i >= len(reqs) {
+				break
+			}
+			if e := result.GetError(); e != nil {
+				errs[i] = status.Error(codes.Code(e.Code), e.Message)
+				continue
+			}
+			resps[i] = &userpb.GetUserResponse{User: result.GetUser()}
+		}
+		return resps, errs
+	}
+}
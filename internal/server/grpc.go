@@ -3,56 +3,164 @@ package server
 import (
 	"fmt"
 	"net"
+	"sort"
 
+	"github.com/kannan112/gateway-structure/pkg/handlers"
 	"github.com/kannan112/gateway-structure/pkg/middleware"
 	"github.com/kannan112/gateway-structure/pkg/proto/auth"
+	"github.com/kannan112/gateway-structure/pkg/proto/user"
 	"github.com/kannan112/gateway-structure/pkg/service"
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
+	"github.com/kannan112/gateway-structure/pkg/validation"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 )
 
 type GRPCServer struct {
-	server  *grpc.Server
-	logger  *zap.Logger
-	options *Options
+	server    *grpc.Server
+	logger    *zap.Logger
+	options   *Options
+	tls       *tlsutil.Reloader
+	upstreams []service.Upstream
 }
 
 func NewGRPCServer(opts *Options, logger *zap.Logger) (*GRPCServer, error) {
-	// Create gRPC server with interceptors
-	server := grpc.NewServer(
-		grpc.UnaryInterceptor(middleware.GRPCLogger(logger)),
-		grpc.ChainUnaryInterceptor(
-			middleware.GRPCRecovery(),
-			middleware.GRPCAuth(),
+	registry := newRegistry(opts, logger)
+	chains, err := middleware.GRPCChains(registry, opts.Chains)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create gRPC server with interceptors, access logging always runs outermost
+	serverOpts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(middleware.GRPCLogger(logger, opts.GRPC.AccessLog)),
+		grpc.ChainUnaryInterceptor(chains),
+		// Streams have a fixed chain, keep streamChain in sync
+		grpc.ChainStreamInterceptor(
+			middleware.GRPCStreamLogger(logger, opts.GRPC.AccessLog),
+			middleware.GRPCStreamRecovery(logger),
+			middleware.GRPCStreamStripIdentity(),
+			middleware.GRPCStreamAuth(),
+			middleware.GRPCStreamRequireRole(user.UserService_WatchUsers_FullMethodName, "admin", middleware.RoleService),
+			middleware.GRPCStreamRequireScope(user.UserService_WatchUsers_FullMethodName, usersScope),
+			middleware.GRPCStreamValidator(validation.Default()),
 		),
-	)
+		grpc.MaxRecvMsgSize(opts.GRPC.MaxRecvMsgSize),
+		grpc.ConnectionTimeout(opts.GRPC.ConnectionTimeout),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle: opts.GRPC.MaxConnectionIdle,
+			Time:              opts.GRPC.KeepaliveTime,
+			Timeout:           opts.GRPC.KeepaliveTimeout,
+		}),
+		// Disconnect clients that ping too aggressively
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             opts.GRPC.KeepaliveMinTime,
+			PermitWithoutStream: false,
+		}),
+	}
+
+	var reloader *tlsutil.Reloader
+	if opts.TLS.Enabled() {
+		config, r, err := newServerTLS(opts, logger, "h2")
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure TLS: %v", err)
+		}
+		reloader = r
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	server := grpc.NewServer(serverOpts...)
 
 	// Initialize services with error handling
 	authService, err := service.NewAuthService(opts.AuthService)
 	if err != nil {
+		if reloader != nil {
+			reloader.Stop()
+		}
 		return nil, fmt.Errorf("failed to initialize auth service: %v", err)
 	}
 
-	// userService, err := service.NewUserService(opts.UserService)
-	// if err != nil {
-	// 	return nil, fmt.Errorf("failed to initialize user service: %v", err)
-	// }
-
 	// Register services
 	auth.RegisterAuthServiceServer(server, authService)
-	//user.RegisterUserServiceServer(server, userService)
+	upstreams := []service.Upstream{authService}
+	if opts.Users != nil {
+		// Served by the same handler as REST, so suspending a user revokes their tokens on either path.
+		// The audit and validator interceptors cover gRPC calls, so the handler gets neither here.
+		users := handlers.NewUserHandler(opts.Users, logger)
+		if opts.Revocations != nil {
+			users.SetRevocationStore(opts.Revocations)
+		}
+		user.RegisterUserServiceServer(server, users)
+		upstreams = append(upstreams, opts.Users)
+		upstreams = append(upstreams, service.Related(opts.Users)...)
+	}
 
 	// Enable reflection for grpcurl
 	reflection.Register(server)
 
 	return &GRPCServer{
-		server:  server,
-		logger:  logger,
-		options: opts,
+		server:    server,
+		logger:    logger,
+		options:   opts,
+		tls:       reloader,
+		upstreams: upstreams,
 	}, nil
 }
 
+// Services returns the registered gRPC services and their methods
+func (s *GRPCServer) Services() map[string][]string {
+	services := make(map[string][]string)
+	for name, info := range s.server.GetServiceInfo() {
+		for _, m := range info.Methods {
+			services[name] = append(services[name], m.Name)
+		}
+	}
+	return services
+}
+
+// streamChain names the stream interceptors, streams don't take configured chains
+func streamChain(method string) []string {
+	chain := []string{"access_log", "recovery", "strip_identity", "auth"}
+	if method == user.UserService_WatchUsers_FullMethodName {
+		chain = append(chain, "require_role:admin,"+middleware.RoleService, "require_scope:"+usersScope)
+	}
+	return append(chain, "validator")
+}
+
+// MethodChain is the interceptors a gRPC method runs through, in order
+type MethodChain struct {
+	Method    string   `json:"method"`
+	Streaming bool     `json:"streaming,omitempty"`
+	Chain     []string `json:"chain"`
+}
+
+// Chains returns the effective interceptor chain of every registered method
+func (s *GRPCServer) Chains() []MethodChain {
+	var chains []MethodChain
+	for name, info := range s.server.GetServiceInfo() {
+		for _, m := range info.Methods {
+			method := "/" + name + "/" + m.Name
+			c := MethodChain{Method: method, Streaming: m.IsClientStream || m.IsServerStream}
+			if c.Streaming {
+				c.Chain = streamChain(method)
+			} else {
+				c.Chain = append([]string{"access_log"}, s.options.Chains.GRPCEntries(method)...)
+			}
+			chains = append(chains, c)
+		}
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].Method < chains[j].Method })
+	return chains
+}
+
+// Upstreams returns the upstream clients used by the registered services
+func (s *GRPCServer) Upstreams() []service.Upstream {
+	return s.upstreams
+}
+
 func (s *GRPCServer) Start() error {
 	listener, err := net.Listen("tcp", s.options.GRPCPort)
 	if err != nil {
@@ -65,5 +173,8 @@ func (s *GRPCServer) Start() error {
 
 func (s *GRPCServer) Stop() {
 	s.logger.Info("Stopping gRPC server")
+	if s.tls != nil {
+		s.tls.Stop()
+	}
 	s.server.GracefulStop()
 }
@@ -2,49 +2,94 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/kannan112/gateway-structure/pkg/graphql"
+	"github.com/kannan112/gateway-structure/pkg/handlers"
 	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/openapi"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
+	"github.com/kannan112/gateway-structure/pkg/validation"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type HTTPServer struct {
-	server  *http.Server
-	router  *mux.Router
-	logger  *zap.Logger
-	options *Options
+	server     *http.Server
+	router     *mux.Router
+	logger     *zap.Logger
+	options    *Options
+	tls        *tlsutil.Reloader
+	operations []openapi.Operation
+	registry   *middleware.Registry
+	groups     []string
 }
 
-func NewHTTPServer(opts *Options, logger *zap.Logger) *HTTPServer {
+func NewHTTPServer(opts *Options, logger *zap.Logger) (*HTTPServer, error) {
 	router := mux.NewRouter()
 
 	server := &HTTPServer{
-		router:  router,
-		logger:  logger,
-		options: opts,
+		router:   router,
+		logger:   logger,
+		options:  opts,
+		registry: newRegistry(opts, logger),
 		server: &http.Server{
-			Addr:         opts.HTTPPort,
-			Handler:      router,
-			ReadTimeout:  opts.ReadTimeout,
-			WriteTimeout: opts.WriteTimeout,
+			Addr:              opts.HTTPPort,
+			Handler:           router,
+			ReadTimeout:       opts.ReadTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+			MaxHeaderBytes:    opts.MaxHeaderBytes,
 		},
 	}
 
-	server.setupRoutes()
-	server.setupMiddleware()
+	// Every configured chain is checked, including those of route groups that are disabled
+	if err := checkHTTPChains(server.registry, opts.Chains); err != nil {
+		return nil, err
+	}
+	if err := server.setupRoutes(); err != nil {
+		return nil, err
+	}
+	if err := server.setupMiddleware(); err != nil {
+		return nil, err
+	}
 
-	return server
+	return server, nil
 }
 
-func (s *HTTPServer) setupMiddleware() {
+func (s *HTTPServer) setupMiddleware() error {
 	// Add global middleware
-	s.router.Use(middleware.Logger(s.logger))
-	s.router.Use(middleware.Recovery())
-	s.router.Use(middleware.RateLimit())
+	chain, err := s.registry.HTTPChain(s.options.Chains.HTTP[middleware.GlobalChain])
+	if err != nil {
+		return fmt.Errorf("HTTP chain %s: %v", middleware.GlobalChain, err)
+	}
+	for _, mw := range chain {
+		s.router.Use(mw)
+	}
+	return nil
 }
 
-func (s *HTTPServer) setupRoutes() {
+// group creates the subrouter of a route group, wrapped in the chain configured for its prefix
+func (s *HTTPServer) group(prefix string) (*mux.Router, error) {
+	chain, err := s.registry.HTTPChain(s.options.Chains.HTTP[prefix])
+	if err != nil {
+		return nil, fmt.Errorf("HTTP chain %s: %v", prefix, err)
+	}
+
+	router := s.router.PathPrefix(prefix).Subrouter()
+	for _, mw := range chain {
+		router.Use(mw)
+	}
+	s.groups = append(s.groups, prefix)
+	return router, nil
+}
+
+func (s *HTTPServer) setupRoutes() error {
 	//Health check
 	//s.router.HandleFunc("/health", handelers.HealthCheck).Methods("GET")
 
@@ -57,20 +102,197 @@ func (s *HTTPServer) setupRoutes() {
 	// auth.HandleFunc("/register", handlers.Register).Methods("POST")
 
 	// User routes
-	// 	users := api.PathPrefix("/users").Subrouter()
-	// 	users.Use(middleware.Authenticate) // Protect all user routes
-	// 	users.HandleFunc("", handlers.GetUsers).Methods("GET")
-	// 	users.HandleFunc("/{id}", handlers.GetUser).Methods("GET")
-	// 	users.HandleFunc("", handlers.CreateUser).Methods("POST")
-	// 	users.HandleFunc("/{id}", handlers.UpdateUser).Methods("PUT")
+	if s.options.Users != nil {
+		userHandler := handlers.NewUserHandler(s.options.Users, s.logger)
+		if s.options.Revocations != nil {
+			userHandler.SetRevocationStore(s.options.Revocations)
+		}
+		userHandler.SetAuditLog(s.options.Audit)
+		userHandler.SetValidator(validation.Default())
+		rest := handlers.NewUserRESTHandler(userHandler)
+
+		users, err := s.group("/api/v1/users")
+		if err != nil {
+			return err
+		}
+		s.handleRPC(users, "GET", "", "ListUsers", nil, "", rest.ListUsers)
+		s.handleRPC(users, "GET", "/batchGet", "BatchGetUsers", nil, "", rest.BatchGetUsers)
+		s.handleRPC(users, "POST", "/batchUpdateStatus", "BatchUpdateUserStatus", nil, "", middleware.RequireRole("admin", middleware.RoleService)(http.HandlerFunc(rest.BatchUpdateUserStatus)).ServeHTTP)
+		s.handleRPC(users, "GET", "/watch", "WatchUsers", nil, "", middleware.RequireRole("admin", middleware.RoleService)(http.HandlerFunc(rest.WatchUsers)).ServeHTTP)
+		s.handleRPC(users, "GET", "/{id}", "GetUser", map[string]string{"id": "user_id"}, "", rest.GetUser)
+		s.handleRPC(users, "POST", "", "CreateUser", nil, "", rest.CreateUser)
+		s.handleRPC(users, "PUT", "/{id}", "UpdateUser", map[string]string{"id": "user.id"}, "", rest.UpdateUser)
+		s.handleRPC(users, "PATCH", "/{id}", "UpdateUser", map[string]string{"id": "user.id"}, "user", rest.PatchUser)
+		s.handleRPC(users, "DELETE", "/{id}", "DeleteUser", map[string]string{"id": "user_id"}, "", rest.DeleteUser)
+
+		// GraphQL over the same handler, operations are charged to the rate limit by complexity
+		schema, err := newGraphQLSchema(userHandler)
+		if err != nil {
+			s.logger.Error("GraphQL endpoint disabled", zap.Error(err))
+		} else {
+			gql, err := s.group("/graphql")
+			if err != nil {
+				return err
+			}
+			gql.Handle("", graphql.Handler(schema, s.options.GraphQL, middleware.ChargeRateLimit)).Methods("POST")
+		}
+	}
+
+	// API docs
+	s.router.Handle("/openapi.json", openapi.Handler(s.openAPIDocument)).Methods("GET")
+	s.router.Handle("/docs", openapi.UIHandler("/openapi.json", "/docs/assets")).Methods("GET")
+	s.router.PathPrefix("/docs/assets/").Handler(http.StripPrefix("/docs/assets", openapi.AssetsHandler())).Methods("GET")
+
+	// Token revocation routes
+	if s.options.Revocations != nil {
+		revocations := handlers.NewRevocationHandler(s.options.Revocations, s.logger)
+
+		logout, err := s.group("/api/v1/auth/logout")
+		if err != nil {
+			return err
+		}
+		logout.HandleFunc("", revocations.Logout).Methods("POST")
+
+		revoke, err := s.group("/api/v1/admin/revocations")
+		if err != nil {
+			return err
+		}
+		revoke.HandleFunc("/tokens", revocations.RevokeToken).Methods("POST")
+		revoke.HandleFunc("/users/{id}", revocations.RevokeUser).Methods("POST")
+	}
+
+	// API key admin routes
+	if s.options.APIKeys != nil {
+		keys := handlers.NewAPIKeyHandler(s.options.APIKeys, s.logger)
+		apiKeys, err := s.group("/api/v1/admin/apikeys")
+		if err != nil {
+			return err
+		}
+		apiKeys.HandleFunc("", keys.CreateKey).Methods("POST")
+		apiKeys.HandleFunc("", keys.ListKeys).Methods("GET")
+		apiKeys.HandleFunc("/{id}", keys.RevokeKey).Methods("DELETE")
+	}
+	return nil
+}
+
+// handleRPC registers a REST route bound to a UserService RPC and records it for the OpenAPI document.
+// body optionally names the request field sent as the request body instead of the whole request.
+func (s *HTTPServer) handleRPC(router *mux.Router, method, path, rpc string, pathFields map[string]string, body string, handler http.HandlerFunc) {
+	route := router.HandleFunc(path, handler).Methods(method)
+	template, _ := route.GetPathTemplate()
+
+	md := userpb.File_pkg_proto_user_user_proto.Services().ByName("UserService").Methods().ByName(protoreflect.Name(rpc))
+	s.operations = append(s.operations, openapi.Operation{
+		Method:     method,
+		Path:       template,
+		Summary:    "UserService." + rpc,
+		Tag:        "UserService",
+		Request:    md.Input(),
+		Response:   md.Output(),
+		PathFields: pathFields,
+		Body:       body,
+		Streaming:  md.IsStreamingServer(),
+		Secured:    true,
+	})
+}
+
+// openAPIDocument combines the RPC bound routes with the rest of the route table
+func (s *HTTPServer) openAPIDocument() openapi.Document {
+	ops := append([]openapi.Operation(nil), s.operations...)
+	documented := make(map[string]bool)
+	for _, op := range ops {
+		documented[op.Method+" "+op.Path] = true
+	}
+
+	for _, route := range s.Routes() {
+		for _, method := range route.Methods {
+			if documented[method+" "+route.Path] {
+				continue
+			}
+			ops = append(ops, openapi.Operation{
+				Method:  method,
+				Path:    route.Path,
+				Tag:     "Gateway",
+				Secured: strings.HasPrefix(route.Path, "/api/"),
+			})
+		}
+	}
+
+	openapi.SortOperations(ops)
+	return openapi.Generate("API Gateway", Version, ops)
+}
+
+// RouteInfo describes a registered HTTP route
+type RouteInfo struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// Routes returns the registered route table
+func (s *HTTPServer) Routes() []RouteInfo {
+	var routes []RouteInfo
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		routes = append(routes, RouteInfo{Path: path, Methods: methods})
+		return nil
+	})
+	return routes
+}
+
+// RouteChain is the middleware a route runs through, in order
+type RouteChain struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods,omitempty"`
+	Chain   []string `json:"chain"`
+}
+
+// Chains returns the effective middleware chain of every registered route
+func (s *HTTPServer) Chains() []RouteChain {
+	var chains []RouteChain
+	for _, route := range s.Routes() {
+		chain := append([]string{}, s.options.Chains.HTTP[middleware.GlobalChain]...)
+		group := ""
+		for _, prefix := range s.groups {
+			if strings.HasPrefix(route.Path, prefix) && len(prefix) > len(group) {
+				group = prefix
+			}
+		}
+		if group != "" {
+			chain = append(chain, s.options.Chains.HTTP[group]...)
+		}
+		chains = append(chains, RouteChain{Path: route.Path, Methods: route.Methods, Chain: chain})
+	}
+	return chains
 }
 
 func (s *HTTPServer) Start() error {
+	if s.options.TLS.Enabled() {
+		config, reloader, err := newServerTLS(s.options, s.logger, "h2", "http/1.1")
+		if err != nil {
+			return fmt.Errorf("failed to configure TLS: %v", err)
+		}
+		s.server.TLSConfig = config
+		s.tls = reloader
+
+		s.logger.Info("Starting HTTPS server",
+			zap.String("port", s.options.HTTPPort),
+			zap.String("client_auth", s.options.TLS.ClientAuth),
+		)
+		return s.server.ListenAndServeTLS("", "")
+	}
+
 	s.logger.Info("Starting HTTP server", zap.String("port", s.options.HTTPPort))
 	return s.server.ListenAndServe()
 }
 
 func (s *HTTPServer) Stop(ctx context.Context) error {
 	s.logger.Info("Stopping HTTP server")
+	if s.tls != nil {
+		s.tls.Stop()
+	}
 	return s.server.Shutdown(ctx)
 }
@@ -1,32 +1,342 @@
 package server
 
 import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/kannan112/gateway-structure/pkg/apikey"
+	"github.com/kannan112/gateway-structure/pkg/audit"
+	"github.com/kannan112/gateway-structure/pkg/circuit"
+	"github.com/kannan112/gateway-structure/pkg/concurrency"
 	"github.com/kannan112/gateway-structure/pkg/config"
+	"github.com/kannan112/gateway-structure/pkg/fault"
+	"github.com/kannan112/gateway-structure/pkg/graphql"
+	"github.com/kannan112/gateway-structure/pkg/idempotency"
+	"github.com/kannan112/gateway-structure/pkg/identity"
+	"github.com/kannan112/gateway-structure/pkg/introspection"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/revocation"
 	"github.com/kannan112/gateway-structure/pkg/service"
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
+	"github.com/kannan112/gateway-structure/pkg/utils"
+	"github.com/kannan112/gateway-structure/pkg/wasm"
 )
 
 type Options struct {
-	HTTPPort        string
-	GRPCPort        string
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	ShutdownTimeout time.Duration
-	AuthService     service.AuthServiceConfig
-	UserService     service.UserServiceConfig
+	HTTPPort          string
+	GRPCPort          string
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64
+	RouteBodyLimits   map[string]int64
+	ShutdownTimeout   time.Duration
+	GRPC              GRPCOptions
+	AuthService       service.AuthServiceConfig
+	UserService       service.UserServiceConfig
+	Users             service.UserService
+	UserSubsetsFile   string
+	Mirror            service.MirrorConfig
+	APIKeyFile        string
+	APIKeys           *apikey.Manager
+	Introspection     introspection.Config
+	TLS               tlsutil.Config
+	CertScopes        map[string][]string
+	TLSReload         time.Duration
+	RevocationRedis   revocation.RedisConfig
+	Revocations       revocation.Store
+	AdminPort         string
+	AdminToken        string
+	Config            config.Config
+	Idempotency       idempotency.Store
+	IdempotencyTTL    time.Duration
+	GraphQL           graphql.Limits
+	AuditFile         audit.FileConfig
+	Audit             *audit.Log
+	Log               utils.LogConfig
+	Chains            middleware.Chains
+	WASMFiltersFile   string
+	WASMFilters       wasm.Filters
+	Faults            *fault.Injector
 }
 
-func DefaultOptions(conf *config.Config) *Options {
+// GRPCOptions holds message size and connection policies for the gRPC listener
+type GRPCOptions struct {
+	MaxRecvMsgSize    int
+	ConnectionTimeout time.Duration // handshake deadline for new connections
+	KeepaliveTime     time.Duration // ping idle clients after this long
+	KeepaliveTimeout  time.Duration // close the connection if a ping isn't acked in time
+	KeepaliveMinTime  time.Duration // clients pinging more often than this are disconnected
+	MaxConnectionIdle time.Duration
+	AccessLog         middleware.AccessLogConfig
+}
+
+// DefaultOptions maps conf onto Options, filling in defaults. Malformed list settings are errors.
+func DefaultOptions(conf *config.Config) (*Options, error) {
+	routeBodyLimits, err := parseLimits(conf.HTTPRouteBodyLimits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_ROUTE_BODY_LIMITS: %v", err)
+	}
+	certScopes, err := parseScopes(conf.TLSClientScopes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS_CLIENT_SCOPES: %v", err)
+	}
+	sampleRate, err := parseSampleRate(conf.GRPCLogSampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GRPC_LOG_SAMPLE_RATE: %v", err)
+	}
+	mirrorMethods, err := parseRates(stringOr(conf.UserServiceMirrorMethods, "GetUser=100,ListUsers=100"), "GetUser", "ListUsers")
+	if err != nil {
+		return nil, fmt.Errorf("invalid USER_SERVICE_MIRROR_METHODS: %v", err)
+	}
+
+	// Upstreams get a gateway-signed identity token when a secret is configured
+	var signer *identity.Signer
+	if conf.InternalTokenSecret != "" {
+		signer = identity.NewSigner([]byte(conf.InternalTokenSecret), conf.InternalTokenTTL)
+	}
+
+	// Every upstream connection gets its own limiter with these settings
+	limits := concurrency.Config{
+		Algorithm:    stringOr(conf.UpstreamConcurrencyAlgorithm, "gradient"),
+		InitialLimit: intOr(conf.UpstreamConcurrencyInitialLimit, 20),
+		MinLimit:     intOr(conf.UpstreamConcurrencyMinLimit, 5),
+		MaxLimit:     intOr(conf.UpstreamConcurrencyMaxLimit, 500),
+		Tolerance:    floatOr(conf.UpstreamConcurrencyTolerance, 2),
+		QueueSize:    intOr(conf.UpstreamConcurrencyQueueSize, 100),
+		QueueTimeout: durationOr(conf.UpstreamConcurrencyQueueTimeout, 500*time.Millisecond),
+	}
+	breakers := circuit.Config{
+		FailureThreshold: intOr(conf.UpstreamCircuitFailureThreshold, 5),
+		OpenTimeout:      durationOr(conf.UpstreamCircuitOpenTimeout, 30*time.Second),
+		HalfOpenCalls:    intOr(conf.UpstreamCircuitHalfOpenCalls, 1),
+	}
+
 	return &Options{
-		HTTPPort:        ":8080",
-		GRPCPort:        ":9090",
-		ReadTimeout:     15 * time.Second,
-		WriteTimeout:    15 * time.Second,
-		ShutdownTimeout: 30 * time.Second,
+		HTTPPort:          ":8080",
+		GRPCPort:          ":9090",
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		ReadHeaderTimeout: durationOr(conf.HTTPReadHeaderTimeout, 5*time.Second),
+		IdleTimeout:       durationOr(conf.HTTPIdleTimeout, 60*time.Second),
+		MaxHeaderBytes:    intOr(conf.HTTPMaxHeaderBytes, 64<<10),
+		MaxBodyBytes:      int64(intOr(int(conf.HTTPMaxBodyBytes), 1<<20)),
+		RouteBodyLimits:   routeBodyLimits,
+		ShutdownTimeout:   30 * time.Second,
+		GRPC: GRPCOptions{
+			MaxRecvMsgSize:    intOr(conf.GRPCMaxRecvMsgSize, 4<<20),
+			ConnectionTimeout: durationOr(conf.GRPCConnectionTimeout, 10*time.Second),
+			KeepaliveTime:     durationOr(conf.GRPCKeepaliveTime, 2*time.Hour),
+			KeepaliveTimeout:  durationOr(conf.GRPCKeepaliveTimeout, 20*time.Second),
+			KeepaliveMinTime:  durationOr(conf.GRPCKeepaliveMinTime, 5*time.Minute),
+			MaxConnectionIdle: durationOr(conf.GRPCMaxConnectionIdle, 15*time.Minute),
+			AccessLog: middleware.AccessLogConfig{
+				SampleRate:    sampleRate,
+				SlowThreshold: conf.GRPCLogSlowThreshold,
+				MetadataKeys:  splitList(conf.GRPCLogMetadata),
+			},
+		},
 		AuthService: service.AuthServiceConfig{
 			Address: "localhost:50052",
 			Timeout: 10 * time.Second,
+			TLS: tlsutil.Config{
+				SystemRoots: conf.AuthServiceTLS,
+				CAFile:      conf.AuthServiceTLSCAFile,
+				CertFile:    conf.AuthServiceTLSCertFile,
+				KeyFile:     conf.AuthServiceTLSKeyFile,
+				ServerName:  conf.AuthServiceTLSServerName,
+			},
+			Identity:    signer,
+			Concurrency: limits,
+			Circuit:     breakers,
+		},
+		UserService: service.UserServiceConfig{
+			Address:          conf.UserServiceURL,
+			BatchConcurrency: intOr(conf.UserBatchConcurrency, 8),
+			TLS: tlsutil.Config{
+				SystemRoots: conf.UserServiceTLS,
+				CAFile:      conf.UserServiceTLSCAFile,
+				CertFile:    conf.UserServiceTLSCertFile,
+				KeyFile:     conf.UserServiceTLSKeyFile,
+				ServerName:  conf.UserServiceTLSServerName,
+			},
+			Identity:    signer,
+			Concurrency: limits,
+			Circuit:     breakers,
+		},
+		UserSubsetsFile: conf.UserServiceSubsetsFile,
+		Mirror: service.MirrorConfig{
+			Address:       conf.UserServiceMirrorURL,
+			Methods:       mirrorMethods,
+			Timeout:       durationOr(conf.UserServiceMirrorTimeout, 2*time.Second),
+			MaxConcurrent: intOr(conf.UserServiceMirrorMaxConcurrency, 16),
+		},
+		APIKeyFile: conf.APIKeyFile,
+		Faults:     fault.NewInjector(conf.Env, conf.FaultInjectionAllowProduction),
+		Introspection: introspection.Config{
+			Endpoint:     conf.IntrospectionURL,
+			ClientID:     conf.IntrospectionClientID,
+			ClientSecret: conf.IntrospectionClientSecret,
+			CacheTTL:     conf.IntrospectionCacheTTL,
+			TLS: tlsutil.Config{
+				CAFile:     conf.IntrospectionTLSCAFile,
+				CertFile:   conf.IntrospectionTLSCertFile,
+				KeyFile:    conf.IntrospectionTLSKeyFile,
+				ServerName: conf.IntrospectionTLSServerName,
+			},
+		},
+		TLS: tlsutil.Config{
+			CertFile:     conf.TLSCertFile,
+			KeyFile:      conf.TLSKeyFile,
+			CAFile:       conf.TLSClientCAFile,
+			ClientAuth:   conf.TLSClientAuth,
+			MinVersion:   conf.TLSMinVersion,
+			CipherSuites: splitList(conf.TLSCipherSuites),
+		},
+		CertScopes: certScopes,
+		TLSReload:  conf.TLSReloadInterval,
+		RevocationRedis: revocation.RedisConfig{
+			Address:  conf.RevocationRedisAddr,
+			Password: conf.RevocationRedisPassword,
+			DB:       conf.RevocationRedisDB,
 		},
+		AdminPort:      ":9091",
+		AdminToken:     conf.AdminToken,
+		Config:         *conf,
+		IdempotencyTTL: durationOr(conf.IdempotencyTTL, 24*time.Hour),
+		GraphQL: graphql.Limits{
+			MaxDepth:      intOr(conf.GraphQLMaxDepth, 10),
+			MaxComplexity: intOr(conf.GraphQLMaxComplexity, 100),
+		},
+		AuditFile: audit.FileConfig{
+			Path:       conf.AuditLogFile,
+			MaxSize:    intOr(conf.AuditLogMaxSize, 100),
+			MaxBackups: conf.AuditLogMaxBackups,
+			MaxAge:     conf.AuditLogMaxAge,
+		},
+		Chains:          DefaultChains,
+		WASMFiltersFile: conf.WASMFiltersFile,
+		Log: utils.LogConfig{
+			Level:              conf.LogLevel,
+			Encoding:           conf.LogEncoding,
+			OutputPath:         conf.LogFile,
+			MaxSize:            intOr(conf.LogMaxSize, 100),
+			MaxBackups:         conf.LogMaxBackups,
+			MaxAge:             conf.LogMaxAge,
+			Compress:           conf.LogCompress,
+			SamplingInitial:    conf.LogSamplingInitial,
+			SamplingThereafter: conf.LogSamplingThereafter,
+		},
+	}, nil
+}
+
+func durationOr(v, def time.Duration) time.Duration {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func intOr(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func stringOr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func floatOr(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// parseSampleRate returns the share of successful gRPC calls to log, every call when unset.
+// 0 is kept, it logs only failed and slow calls.
+func parseSampleRate(rate *float64) (float64, error) {
+	if rate == nil {
+		return 1, nil
+	}
+	if *rate < 0 || *rate > 1 {
+		return 0, fmt.Errorf("%v is outside 0 to 1", *rate)
+	}
+	return *rate, nil
+}
+
+// parseLimits parses "prefix=bytes" pairs such as "/api/v1/users=1048576,/api/v1/admin=65536",
+// 0 lifts the limit for a prefix
+func parseLimits(s string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, pair := range splitList(s) {
+		prefix, value, ok := strings.Cut(pair, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("%q is not a /prefix=bytes pair", pair)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q: limit must be a number of bytes", pair)
+		}
+		limits[prefix] = n
+	}
+	return limits, nil
+}
+
+// parseRates parses "name=percent" pairs such as "GetUser=100,ListUsers=5", names must be one of allowed
+func parseRates(s string, allowed ...string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, pair := range splitList(s) {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a name=percent pair", pair)
+		}
+		if !slices.Contains(allowed, name) {
+			return nil, fmt.Errorf("%q: %s is not one of %s", pair, name, strings.Join(allowed, ", "))
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate < 0 || rate > 100 {
+			return nil, fmt.Errorf("%q: rate must be a percentage between 0 and 100", pair)
+		}
+		rates[name] = rate
+	}
+	return rates, nil
+}
+
+// parseScopes parses "identity=scopes" pairs such as "spiffe://example.org/billing=users admin",
+// the scopes of an identity are separated by spaces
+func parseScopes(s string) (map[string][]string, error) {
+	scopes := make(map[string][]string)
+	for _, pair := range splitList(s) {
+		identity, value, ok := strings.Cut(pair, "=")
+		identity = strings.TrimSpace(identity)
+		if !ok || identity == "" {
+			return nil, fmt.Errorf("%q is not an identity=scopes pair", pair)
+		}
+		scopes[identity] = strings.Fields(value)
+	}
+	return scopes, nil
+}
+
+// splitList splits a comma separated config value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
+	return out
 }
@@ -0,0 +1,74 @@
+package server
+
+import "testing"
+
+func TestParseLimits(t *testing.T) {
+	limits, err := parseLimits(" /api/v1/users=1048576, /api/v1/admin = 0 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 2 || limits["/api/v1/users"] != 1048576 || limits["/api/v1/admin"] != 0 {
+		t.Fatalf("unexpected limits %v", limits)
+	}
+
+	for _, s := range []string{"/api/v1/users", "/api/v1/users=1MB", "/api/v1/users=-1", "api/v1/users=10"} {
+		if _, err := parseLimits(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	rates, err := parseRates("GetUser=100,ListUsers=2.5", "GetUser", "ListUsers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["GetUser"] != 100 || rates["ListUsers"] != 2.5 {
+		t.Fatalf("unexpected rates %v", rates)
+	}
+
+	for _, s := range []string{"GetUser", "GetUser=all", "GetUser=101", "DeleteUser=10"} {
+		if _, err := parseRates(s, "GetUser", "ListUsers"); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes("spiffe://example.org/billing=users admin, *=users, audit.internal=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 3 || len(scopes["spiffe://example.org/billing"]) != 2 || scopes["*"][0] != "users" || len(scopes["audit.internal"]) != 0 {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+
+	for _, s := range []string{"billing.internal", "=users"} {
+		if _, err := parseScopes(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestParseSampleRate(t *testing.T) {
+	rate := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		rate    *float64
+		want    float64
+		wantErr bool
+	}{
+		{rate: nil, want: 1},
+		{rate: rate(0), want: 0},
+		{rate: rate(0.25), want: 0.25},
+		{rate: rate(1), want: 1},
+		{rate: rate(-0.1), wantErr: true},
+		{rate: rate(1.5), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSampleRate(tt.rate)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%v: got %v, %v, want %v", tt.rate, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kannan112/gateway-structure/pkg/fieldmask"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/proto/user"
+	"github.com/kannan112/gateway-structure/pkg/validation"
+	"github.com/kannan112/gateway-structure/pkg/wasm"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// DefaultChains is the built-in middleware order, configured chains replace it key by key.
+// HTTP keys are the prefixes of the route groups, gRPC keys prefix full method names.
+var DefaultChains = middleware.Chains{
+	HTTP: map[string][]string{
+		middleware.GlobalChain:      {"request_id", "logger", "recovery", "strip_identity", "body_limit", "rate_limit", "routing_headers"},
+		"/api/v1/users":             {"authenticate", "require_scope:" + usersScope, "fault_injection", "idempotency"},
+		"/graphql":                  {"authenticate", "require_scope:" + usersScope, "fault_injection"},
+		"/api/v1/auth/logout":       {"authenticate"},
+		"/api/v1/admin/revocations": {"authenticate", "require_role:admin", "require_scope:" + adminScope},
+		"/api/v1/admin/apikeys":     {"authenticate", "require_role:admin", "require_scope:" + adminScope},
+	},
+	GRPC: map[string][]string{
+		// Audited after authentication, so rejected changes are recorded with their actor,
+		// and after idempotency, so replayed responses aren't recorded as changes again
+		middleware.GlobalChain:                                {"recovery", "request_id", "strip_identity", "auth", "fault_injection", "idempotency", "audit", "validator", "field_mask"},
+		"/" + user.UserService_ServiceDesc.ServiceName + "/":  {"require_scope:" + usersScope},
+		user.UserService_BatchUpdateUserStatus_FullMethodName: {"require_role:admin," + middleware.RoleService},
+	},
+}
+
+// Scopes API keys need for the user and admin surfaces
+const (
+	usersScope = "users"
+	adminScope = "admin"
+)
+
+// mutatingUserMethods are audited and honour idempotency keys unless a chain entry names other methods
+var mutatingUserMethods = []string{
+	user.UserService_CreateUser_FullMethodName,
+	user.UserService_UpdateUser_FullMethodName,
+	user.UserService_DeleteUser_FullMethodName,
+	user.UserService_BatchUpdateUserStatus_FullMethodName,
+}
+
+// newRegistry returns the registered plugins together with the built-in ones bound to opts,
+// built-in names can't be replaced
+func newRegistry(opts *Options, logger *zap.Logger) *middleware.Registry {
+	r := middleware.Plugins().Clone()
+
+	// HTTP middleware
+	r.RegisterHTTP("request_id", noArgs(middleware.RequestID()))
+	r.RegisterHTTP("logger", noArgs(middleware.Logger(logger)))
+	r.RegisterHTTP("recovery", noArgs(middleware.Recovery(logger)))
+	r.RegisterHTTP("strip_identity", noArgs(middleware.StripIdentityHeaders()))
+	r.RegisterHTTP("body_limit", noArgs(middleware.BodyLimit(opts.MaxBodyBytes, opts.RouteBodyLimits)))
+	r.RegisterHTTP("rate_limit", noArgs(middleware.RateLimit()))
+	r.RegisterHTTP("routing_headers", noArgs(middleware.RoutingHeaders()))
+	r.RegisterHTTP("authenticate", noArgs(middleware.Authenticate))
+	r.RegisterHTTP("require_role", func(args []string) (func(http.Handler) http.Handler, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("needs at least one role")
+		}
+		return middleware.RequireRole(args...), nil
+	})
+	r.RegisterHTTP("require_scope", func(args []string) (func(http.Handler) http.Handler, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("needs at least one scope")
+		}
+		return middleware.RequireScope(args...), nil
+	})
+	r.RegisterHTTP("fault_injection", noArgs(middleware.FaultInjection(opts.Faults)))
+	r.RegisterHTTP("wasm", func(args []string) (func(http.Handler) http.Handler, error) {
+		f, err := wasmFilter(opts, args)
+		if err != nil {
+			return nil, err
+		}
+		return middleware.WASMFilter(f, logger), nil
+	})
+	r.RegisterHTTP("idempotency", func(args []string) (func(http.Handler) http.Handler, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("takes no arguments")
+		}
+		if opts.Idempotency == nil {
+			return nil, nil
+		}
+		return middleware.Idempotency(opts.Idempotency, opts.IdempotencyTTL), nil
+	})
+
+	// gRPC interceptors
+	r.RegisterGRPC("recovery", noArgsGRPC(middleware.GRPCRecovery(logger)))
+	r.RegisterGRPC("request_id", noArgsGRPC(middleware.GRPCRequestID()))
+	r.RegisterGRPC("strip_identity", noArgsGRPC(middleware.GRPCStripIdentity()))
+	r.RegisterGRPC("auth", noArgsGRPC(middleware.GRPCAuth()))
+	r.RegisterGRPC("validator", noArgsGRPC(middleware.GRPCValidator(validation.Default())))
+	r.RegisterGRPC("field_mask", noArgsGRPC(middleware.GRPCFieldMask(fieldmask.DefaultPolicy)))
+	r.RegisterGRPC("require_role", func(args []string) (grpc.UnaryServerInterceptor, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("needs at least one role")
+		}
+		return middleware.GRPCRequireRole("", args...), nil
+	})
+	r.RegisterGRPC("require_scope", func(args []string) (grpc.UnaryServerInterceptor, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("needs at least one scope")
+		}
+		return middleware.GRPCRequireScope("", args...), nil
+	})
+	r.RegisterGRPC("fault_injection", noArgsGRPC(middleware.GRPCFaultInjection(opts.Faults)))
+	r.RegisterGRPC("wasm", func(args []string) (grpc.UnaryServerInterceptor, error) {
+		f, err := wasmFilter(opts, args)
+		if err != nil {
+			return nil, err
+		}
+		return middleware.GRPCWASMFilter(f, logger), nil
+	})
+	r.RegisterGRPC("audit", func(args []string) (grpc.UnaryServerInterceptor, error) {
+		return middleware.GRPCAudit(opts.Audit, methodsOr(args)...), nil
+	})
+	r.RegisterGRPC("idempotency", func(args []string) (grpc.UnaryServerInterceptor, error) {
+		if opts.Idempotency == nil {
+			return nil, nil
+		}
+		return middleware.GRPCIdempotency(opts.Idempotency, opts.IdempotencyTTL, methodsOr(args)...), nil
+	})
+
+	return r
+}
+
+// checkHTTPChains rejects chains of unknown route groups and chains naming unknown plugins
+func checkHTTPChains(registry *middleware.Registry, chains middleware.Chains) error {
+	for key, entries := range chains.HTTP {
+		if _, ok := DefaultChains.HTTP[key]; !ok {
+			return fmt.Errorf("HTTP chain %s matches no route group", key)
+		}
+		if _, err := registry.HTTPChain(entries); err != nil {
+			return fmt.Errorf("HTTP chain %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// wasmFilter returns the loaded filter named by the single argument of a "wasm:<name>" entry
+func wasmFilter(opts *Options, args []string) (*wasm.Filter, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("takes the name of one filter")
+	}
+	f, ok := opts.WASMFilters[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("no wasm filter %s in the filters file", args[0])
+	}
+	return f, nil
+}
+
+// noArgs registers middleware that takes no arguments
+func noArgs(mw func(http.Handler) http.Handler) middleware.HTTPFactory {
+	return func(args []string) (func(http.Handler) http.Handler, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("takes no arguments")
+		}
+		return mw, nil
+	}
+}
+
+// noArgsGRPC registers an interceptor that takes no arguments
+func noArgsGRPC(interceptor grpc.UnaryServerInterceptor) middleware.GRPCFactory {
+	return func(args []string) (grpc.UnaryServerInterceptor, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("takes no arguments")
+		}
+		return interceptor, nil
+	}
+}
+
+func methodsOr(args []string) []string {
+	if len(args) == 0 {
+		return mutatingUserMethods
+	}
+	return args
+}
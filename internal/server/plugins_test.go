@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kannan112/gateway-structure/pkg/audit"
+	"github.com/kannan112/gateway-structure/pkg/idempotency"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// countingSink counts the audit entries written
+type countingSink struct{ entries int }
+
+func (s *countingSink) Write([]byte) error { s.entries++; return nil }
+func (s *countingSink) Close() error       { return nil }
+
+func TestIdempotentReplaysAreNotAudited(t *testing.T) {
+	store := idempotency.NewMemoryStore(time.Minute)
+	defer store.Close()
+	sink := &countingSink{}
+	opts := &Options{Idempotency: store, IdempotencyTTL: time.Minute, Audit: audit.New(sink, "")}
+
+	// The default global chain, without the entries that need a token or upstream config
+	var entries []string
+	for _, entry := range DefaultChains.GRPC[middleware.GlobalChain] {
+		if entry == "idempotency" || entry == "audit" {
+			entries = append(entries, entry)
+		}
+	}
+	interceptor, err := middleware.GRPCChains(newRegistry(opts, zap.NewNop()), middleware.Chains{
+		GRPC: map[string][]string{middleware.GlobalChain: entries},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		return &userpb.DeleteUserResponse{Success: true}, nil
+	}
+	ctx := middleware.ContextWithClaims(context.Background(), &middleware.Claims{UserID: "admin-1", Role: "admin"})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("idempotency-key", "k1"))
+	info := &grpc.UnaryServerInfo{FullMethod: userpb.UserService_DeleteUser_FullMethodName}
+
+	for i := 0; i < 2; i++ {
+		if _, err := interceptor(ctx, &userpb.DeleteUserRequest{UserId: "u1"}, info, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if handled != 1 || sink.entries != 1 {
+		t.Fatalf("got %d calls handled and %d audited, want the replay neither handled nor audited", handled, sink.entries)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kannan112/gateway-structure/pkg/config"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ConfigReloader re-reads the configuration and applies the settings that can change at runtime,
+// it is shared by the admin server and SIGHUP
+type ConfigReloader struct {
+	logger *zap.Logger
+	level  zap.AtomicLevel
+
+	config config.Config
+	mu     sync.RWMutex
+}
+
+func NewConfigReloader(conf config.Config, logger *zap.Logger, level zap.AtomicLevel) *ConfigReloader {
+	return &ConfigReloader{
+		logger: logger,
+		level:  level,
+		config: conf,
+	}
+}
+
+// Config returns the configuration the gateway runs with, settings that changed on reload
+// but need a restart keep the value they started with
+func (r *ConfigReloader) Config() config.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+// Level returns the level of the gateway logger
+func (r *ConfigReloader) Level() zap.AtomicLevel {
+	return r.level
+}
+
+// ReloadResult lists the settings that changed in the configuration by env name
+type ReloadResult struct {
+	// Applied took effect with the reload
+	Applied []string `json:"applied"`
+	// RestartRequired only take effect once the gateway restarts
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Reload loads the configuration and applies the JWT secret and log level, other changes are
+// reported as needing a restart. Nothing is applied when the configuration is invalid.
+func (r *ConfigReloader) Reload() (ReloadResult, error) {
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	conf, err := config.LoadConfig()
+	if err != nil {
+		return result, err
+	}
+
+	level := r.level.Level()
+	if conf.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+			return result, fmt.Errorf("invalid log level %q: %v", conf.LogLevel, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	running := r.config
+	for _, key := range config.Changed(running, conf) {
+		switch key {
+		case "JWT_SRC":
+			// An unset secret keeps the current one
+			if conf.JWTSecret == "" {
+				continue
+			}
+			middleware.SetJWTSecret(conf.JWTSecret)
+			running.JWTSecret = conf.JWTSecret
+		case "LOG_LEVEL":
+			if conf.LogLevel == "" {
+				continue
+			}
+			running.LogLevel = conf.LogLevel
+		default:
+			result.RestartRequired = append(result.RestartRequired, key)
+			continue
+		}
+		result.Applied = append(result.Applied, key)
+	}
+	r.setLevel(level)
+	r.config = running
+
+	r.logger.Info("Config reloaded",
+		zap.Stringer("log_level", level),
+		zap.Strings("applied", result.Applied),
+		zap.Strings("restart_required", result.RestartRequired),
+	)
+	return result, nil
+}
+
+func (r *ConfigReloader) setLevel(level zapcore.Level) {
+	if r.level.Level() != level {
+		r.level.SetLevel(level)
+	}
+}
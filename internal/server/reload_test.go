@@ -0,0 +1,52 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kannan112/gateway-structure/pkg/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestReloadReportsRestartRequired(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("JWT_SRC", "rotated")
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("USER_SERVICE_URL", "users-v2:9090")
+
+	started := config.Config{JWTSecret: "initial", LogLevel: "info", UserServiceURL: "users:9090"}
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	reloader := NewConfigReloader(started, zap.NewNop(), level)
+
+	result, err := reloader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReloadResult{Applied: []string{"JWT_SRC", "LOG_LEVEL"}, RestartRequired: []string{"USER_SERVICE_URL"}}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("got %+v, want %+v", result, want)
+	}
+	if level.Level() != zapcore.DebugLevel {
+		t.Fatalf("log level is %s, want debug", level.Level())
+	}
+
+	// The running config only carries what was applied
+	running := reloader.Config()
+	if running.JWTSecret != "rotated" || running.LogLevel != "debug" || running.UserServiceURL != "users:9090" {
+		t.Fatalf("unexpected running config %+v", running)
+	}
+
+	// Reloading the same configuration again changes nothing new
+	if result, err := reloader.Reload(); err != nil || len(result.Applied) != 0 || !reflect.DeepEqual(result.RestartRequired, []string{"USER_SERVICE_URL"}) {
+		t.Fatalf("second reload got %+v, %v", result, err)
+	}
+
+	t.Setenv("LOG_LEVEL", "loud")
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("expected an invalid log level to fail the reload")
+	}
+	if level.Level() != zapcore.DebugLevel {
+		t.Fatalf("failed reload changed the log level to %s", level.Level())
+	}
+}
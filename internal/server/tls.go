@@ -0,0 +1,31 @@
+package server
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
+	"go.uber.org/zap"
+)
+
+// newServerTLS loads the listener certificates and starts watching them for changes.
+// nextProtos are the ALPN protocols the listener speaks.
+func newServerTLS(opts *Options, logger *zap.Logger, nextProtos ...string) (*tls.Config, *tlsutil.Reloader, error) {
+	reloader, err := tlsutil.NewReloader(opts.TLS, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := reloader.ServerConfig(nextProtos...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	interval := opts.TLSReload
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	reloader.Watch(interval)
+
+	return config, reloader, nil
+}
@@ -0,0 +1,142 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Prefix is prepended to every generated key so leaked keys are easy to spot
+const Prefix = "gwk_"
+
+var (
+	ErrNotFound = errors.New("api key not found")
+	ErrRevoked  = errors.New("api key revoked")
+	ErrExpired  = errors.New("api key expired")
+)
+
+// Key describes an API key issued to a machine client.
+// Only the SHA-256 hash of the raw key is ever stored.
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	Tier      string    `json:"tier"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero means no expiry
+	Revoked   bool      `json:"revoked"`
+}
+
+// Expired reports whether the key is past its expiry time
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// HasScope reports whether the key was granted the given scope
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists API keys
+type Store interface {
+	Save(key *Key) error
+	Get(id string) (*Key, error)
+	GetByHash(hash string) (*Key, error)
+	List() ([]*Key, error)
+}
+
+// Manager issues, verifies and revokes API keys
+type Manager struct {
+	store Store
+}
+
+// NewManager creates a new Manager backed by the given store
+func NewManager(store Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Create generates a new key and returns the raw value together with its record.
+// The raw value is not stored and cannot be recovered later.
+func (m *Manager) Create(name string, scopes []string, tier string, ttl time.Duration) (string, *Key, error) {
+	raw, err := randomString(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate api key: %v", err)
+	}
+	id, err := randomString(9)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate api key id: %v", err)
+	}
+
+	raw = Prefix + raw
+	now := time.Now().UTC()
+	key := &Key{
+		ID:        id,
+		Name:      name,
+		Hash:      Hash(raw),
+		Scopes:    scopes,
+		Tier:      tier,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+
+	if err := m.store.Save(key); err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// List returns all known keys, including revoked ones
+func (m *Manager) List() ([]*Key, error) {
+	return m.store.List()
+}
+
+// Revoke marks the key with the given ID as revoked
+func (m *Manager) Revoke(id string) error {
+	key, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	key.Revoked = true
+	return m.store.Save(key)
+}
+
+// Verify looks up the raw key and checks that it is still usable
+func (m *Manager) Verify(raw string) (*Key, error) {
+	key, err := m.store.GetByHash(Hash(raw))
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked {
+		return nil, ErrRevoked
+	}
+	if key.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return key, nil
+}
+
+// Hash returns the hex encoded SHA-256 hash of a raw key
+func Hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,86 @@
+package apikey
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerCreateAndVerify(t *testing.T) {
+	m := NewManager(NewMemoryStore())
+
+	raw, key, err := m.Create("billing", []string{"users"}, "gold", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, Prefix) {
+		t.Fatalf("raw key %q lacks the %s prefix", raw, Prefix)
+	}
+	if key.Hash != Hash(raw) || strings.Contains(key.Hash, raw) {
+		t.Fatal("the key record must only hold the hash of the raw key")
+	}
+
+	got, err := m.Verify(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != key.ID || got.Tier != "gold" || !got.HasScope("users") || got.HasScope("admin") {
+		t.Fatalf("unexpected key %+v", got)
+	}
+	if _, err := m.Verify(raw + "x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown key, got %v", err)
+	}
+}
+
+func TestManagerRevokeAndExpiry(t *testing.T) {
+	m := NewManager(NewMemoryStore())
+
+	raw, key, err := m.Create("ci", nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Verify(raw); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected ErrRevoked, got %v", err)
+	}
+	if err := m.Revoke("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	raw, _, err = m.Create("short-lived", nil, "", time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := m.Verify(raw); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestFileStorePersistsKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "apikeys.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, key, err := NewManager(store).Create("billing", []string{"*"}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewManager(reopened).Verify(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != key.ID || !got.HasScope("admin") {
+		t.Fatalf("unexpected key after reload %+v", got)
+	}
+}
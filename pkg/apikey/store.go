@@ -0,0 +1,128 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps API keys in memory
+type MemoryStore struct {
+	keys   map[string]*Key
+	hashes map[string]string
+	mu     sync.RWMutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys:   make(map[string]*Key),
+		hashes: make(map[string]string),
+	}
+}
+
+func (s *MemoryStore) Save(key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := *key
+	s.keys[k.ID] = &k
+	s.hashes[k.Hash] = k.ID
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	k := *key
+	return &k, nil
+}
+
+func (s *MemoryStore) GetByHash(hash string) (*Key, error) {
+	s.mu.RLock()
+	id, ok := s.hashes[hash]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.Get(id)
+}
+
+func (s *MemoryStore) List() ([]*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		k := *key
+		keys = append(keys, &k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// FileStore keeps API keys in memory and persists them to a JSON file on every change
+type FileStore struct {
+	*MemoryStore
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore loads keys from path, creating the file on first save if it doesn't exist
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api key file %s: %v", path, err)
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse api key file %s: %v", path, err)
+	}
+	for _, key := range keys {
+		s.MemoryStore.Save(key)
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Save(key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.MemoryStore.Save(key)
+	keys, _ := s.MemoryStore.List()
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated key file
+	tmp := s.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write api key file: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Outcomes of an audited operation
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one entry of the audit trail. PrevHash and Hash chain the entries,
+// so removing or editing one breaks the chain from there on.
+type Event struct {
+	Time          time.Time `json:"time"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Actor         string    `json:"actor"`
+	ActorRole     string    `json:"actor_role,omitempty"`
+	SourceIP      string    `json:"source_ip,omitempty"`
+	Operation     string    `json:"operation"`
+	Target        string    `json:"target,omitempty"`
+	ChangedFields []string  `json:"changed_fields,omitempty"`
+	Outcome       string    `json:"outcome"`
+	Code          string    `json:"code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	PrevHash      string    `json:"prev_hash"`
+	Hash          string    `json:"hash"`
+}
+
+// Sink stores encoded entries, one call per entry. Sinks must only ever append.
+type Sink interface {
+	Write(entry []byte) error
+	Close() error
+}
+
+// Log appends hash chained events to a sink
+type Log struct {
+	mu   sync.Mutex
+	sink Sink
+	last string
+}
+
+// New creates a Log continuing the chain after lastHash, empty starts a new chain
+func New(sink Sink, lastHash string) *Log {
+	return &Log{sink: sink, last: lastHash}
+}
+
+// Record chains e onto the previous entry and writes it to the sink
+func (l *Log) Record(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.PrevHash = l.last
+	hash, err := e.hash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %v", err)
+	}
+	if err := l.sink.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %v", err)
+	}
+	l.last = hash
+	return nil
+}
+
+// Close closes the sink
+func (l *Log) Close() error {
+	return l.sink.Close()
+}
+
+// hash is the SHA-256 of the event encoded without its own hash
+func (e Event) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify checks the chain of newline separated entries read from r, starting after prevHash.
+// It returns the hash of the last entry, to verify rotated files in order.
+func Verify(r io.Reader, prevHash string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return prevHash, fmt.Errorf("line %d: invalid entry: %v", line, err)
+		}
+		if e.PrevHash != prevHash {
+			return prevHash, fmt.Errorf("line %d: chain broken, previous hash %q, expected %q", line, e.PrevHash, prevHash)
+		}
+		hash, err := e.hash()
+		if err != nil {
+			return prevHash, fmt.Errorf("line %d: %v", line, err)
+		}
+		if hash != e.Hash {
+			return prevHash, fmt.Errorf("line %d: entry was modified", line)
+		}
+		prevHash = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return prevHash, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return prevHash, nil
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// bufferSink collects entries in memory
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) Write(entry []byte) error {
+	_, err := s.Buffer.Write(entry)
+	return err
+}
+
+func (s *bufferSink) Close() error { return nil }
+
+func record(t *testing.T, log *Log, operations ...string) {
+	t.Helper()
+	for _, op := range operations {
+		if err := log.Record(Event{Actor: "admin-1", Operation: op, Target: "user-1", Outcome: OutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyIntactChain(t *testing.T) {
+	sink := &bufferSink{}
+	record(t, New(sink, ""), "CreateUser", "UpdateUser", "DeleteUser")
+
+	last, err := Verify(strings.NewReader(sink.String()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == "" {
+		t.Fatal("expected the hash of the last entry")
+	}
+
+	// A rotated file continues the chain of the previous one
+	next := &bufferSink{}
+	record(t, New(next, last), "UpdateUser")
+	if _, err := Verify(strings.NewReader(next.String()), last); err != nil {
+		t.Fatalf("continued chain: %v", err)
+	}
+	if _, err := Verify(strings.NewReader(next.String()), ""); err == nil {
+		t.Fatal("expected the continued chain to fail without its predecessor")
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	sink := &bufferSink{}
+	record(t, New(sink, ""), "CreateUser", "UpdateUser", "DeleteUser")
+	lines := strings.SplitAfter(strings.TrimSuffix(sink.String(), "\n"), "\n")
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{name: "edited entry", lines: []string{lines[0], strings.Replace(lines[1], "admin-1", "admin-2", 1), lines[2]}, want: "line 2: entry was modified"},
+		{name: "removed entry", lines: []string{lines[0], lines[2]}, want: "line 2: chain broken"},
+		{name: "reordered entries", lines: []string{lines[1], lines[0], lines[2]}, want: "line 1: chain broken"},
+		{name: "garbage", lines: []string{lines[0], "not json\n"}, want: "line 2: invalid entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(strings.NewReader(strings.Join(tt.lines, "")), "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// maxEntrySize bounds how far back from the end of a file the last entry is searched
+const maxEntrySize = 1 << 20
+
+// FileConfig configures a rotating audit file, zero MaxBackups and MaxAge keep every rotated file
+type FileConfig struct {
+	Path       string
+	MaxSize    int // megabytes
+	MaxBackups int
+	MaxAge     int // days
+}
+
+// FileSink appends entries to a file that is rotated by size
+type FileSink struct {
+	writer *lumberjack.Logger
+}
+
+// NewFileSink opens the audit file at config.Path. It returns the hash of the last
+// entry already in the file, so the chain continues across restarts.
+func NewFileSink(config FileConfig) (*FileSink, string, error) {
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0750); err != nil {
+		return nil, "", fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+
+	last, err := lastHash(config.Path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &FileSink{
+		writer: &lumberjack.Logger{
+			Filename:   config.Path,
+			MaxSize:    config.MaxSize,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,
+		},
+	}, last, nil
+}
+
+// Write appends entry to the file
+func (s *FileSink) Write(entry []byte) error {
+	_, err := s.writer.Write(entry)
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.writer.Close()
+}
+
+// lastHash reads the hash of the last entry in the file at path, empty when there is none
+func lastHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to open audit log: %v", err)
+	}
+	offset := info.Size() - maxEntrySize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) == 0 {
+		return "", nil
+	}
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+
+	var e Event
+	if err := json.Unmarshal(tail, &e); err != nil || e.Hash == "" {
+		return "", fmt.Errorf("audit log %s does not end with a complete entry", path)
+	}
+	return e.Hash, nil
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// Change is the effect of a request on one user
+type Change struct {
+	Target string
+	Fields []string
+	Err    error
+}
+
+// UserChanges describes what a mutating UserService request did to which users,
+// per user results of batch responses become one change each. Unknown requests return nil.
+func UserChanges(req, resp proto.Message) []Change {
+	switch req := req.(type) {
+	case *userpb.CreateUserRequest:
+		fields := populatedFields(req.GetUser())
+		if req.GetPassword() != "" {
+			fields = append(fields, "password")
+		}
+		target := req.GetUser().GetUsername()
+		if resp, ok := resp.(*userpb.CreateUserResponse); ok && resp.GetUser().GetId() != "" {
+			target = resp.GetUser().GetId()
+		}
+		return []Change{{Target: target, Fields: fields}}
+	case *userpb.UpdateUserRequest:
+		fields := req.GetUpdateMask().GetPaths()
+		if len(fields) == 0 {
+			fields = populatedFields(req.GetUser())
+		}
+		if req.NewPassword != nil {
+			fields = append(fields, "password")
+		}
+		return []Change{{Target: req.GetUser().GetId(), Fields: fields}}
+	case *userpb.DeleteUserRequest:
+		return []Change{{Target: req.GetUserId()}}
+	case *userpb.BatchUpdateUserStatusRequest:
+		resp, ok := resp.(*userpb.BatchUpdateUserStatusResponse)
+		if !ok {
+			changes := make([]Change, len(req.GetUserIds()))
+			for i, id := range req.GetUserIds() {
+				changes[i] = Change{Target: id, Fields: []string{"status"}}
+			}
+			return changes
+		}
+		changes := make([]Change, len(resp.GetResults()))
+		for i, result := range resp.GetResults() {
+			changes[i] = Change{Target: result.GetUserId(), Fields: []string{"status"}}
+			if e := result.GetError(); e != nil {
+				changes[i].Err = status.Error(codes.Code(e.GetCode()), e.GetMessage())
+			}
+		}
+		return changes
+	}
+	return nil
+}
+
+// populatedFields lists the set fields of user apart from its ID
+func populatedFields(user *userpb.User) []string {
+	var fields []string
+	msg := user.ProtoReflect()
+	fds := msg.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		if fd := fds.Get(i); fd.Name() != "id" && msg.Has(fd) {
+			fields = append(fields, string(fd.Name()))
+		}
+	}
+	return fields
+}
+
+// Events expands base into one event per change. err is the error of the whole operation,
+// it fails every change, otherwise each change succeeds or fails on its own.
+func Events(base Event, changes []Change, err error) []Event {
+	events := make([]Event, len(changes))
+	for i, c := range changes {
+		e := base
+		e.Target = c.Target
+		e.ChangedFields = c.Fields
+		e.Outcome = OutcomeSuccess
+		if failed := firstErr(err, c.Err); failed != nil {
+			st := status.Convert(failed)
+			e.Outcome = OutcomeFailure
+			e.Code = st.Code().String()
+			e.Error = st.Message()
+		}
+		events[i] = e
+	}
+	return events
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Package circuit stops calls to an upstream that keeps failing, and lets a few probe calls
+// through after a cool-down to find out whether it recovered
+package circuit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned while the circuit is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State of a breaker
+type State int
+
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// Open rejects every call until the open timeout passes
+	Open
+	// HalfOpen lets a limited number of probe calls through
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Result of a call as seen by a breaker
+type Result int
+
+const (
+	Success Result = iota
+	Failure
+	// Ignored calls, e.g. canceled or shed before reaching the upstream, say nothing about it
+	Ignored
+)
+
+// Config of a Breaker
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before probing
+	OpenTimeout time.Duration
+	// HalfOpenCalls is the number of probe calls let through while half-open, all must
+	// succeed to close the circuit
+	HalfOpenCalls int
+}
+
+// Stats describes a breaker for admin inspection
+type Stats struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	Trips               uint64     `json:"trips"`
+	Rejected            uint64     `json:"rejected"`
+}
+
+// Breaker tracks consecutive failures of an upstream
+type Breaker struct {
+	config Config
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+	trips     uint64
+	rejected  uint64
+
+	now func() time.Time
+}
+
+// New creates a closed Breaker
+func New(config Config) *Breaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenCalls <= 0 {
+		config.HalfOpenCalls = 1
+	}
+	return &Breaker{config: config, now: time.Now}
+}
+
+// Allow reports whether a call may be made, every allowed call must be followed by Record
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.state = HalfOpen
+		b.probes, b.successes = 0, 0
+	}
+
+	switch b.state {
+	case Open:
+		b.rejected++
+		return ErrOpen
+	case HalfOpen:
+		if b.probes >= b.config.HalfOpenCalls {
+			b.rejected++
+			return ErrOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Record reports the result of an allowed call
+func (b *Breaker) Record(result Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case result == Ignored:
+		// Give the probe slot back so another call can find out
+		if b.state == HalfOpen && b.probes > 0 {
+			b.probes--
+		}
+	case b.state == HalfOpen && result == Failure:
+		b.open()
+	case b.state == HalfOpen:
+		b.successes++
+		if b.successes >= b.config.HalfOpenCalls {
+			b.state = Closed
+			b.failures = 0
+		}
+	case result == Success:
+		b.failures = 0
+	default:
+		b.failures++
+		if b.state == Closed && b.failures >= b.config.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = Open
+	b.openedAt = b.now()
+	b.trips++
+}
+
+// Stats returns the state and counters of the breaker
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == Open && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		state = HalfOpen
+	}
+	stats := Stats{
+		State:               state.String(),
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		Rejected:            b.rejected,
+	}
+	if state != Closed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
@@ -0,0 +1,85 @@
+package circuit
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBreaker(now *time.Time) *Breaker {
+	b := New(Config{FailureThreshold: 3, OpenTimeout: 10 * time.Second, HalfOpenCalls: 1})
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+func call(b *Breaker, result Result) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	b.Record(result)
+	return nil
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTestBreaker(&now)
+
+	call(b, Failure)
+	call(b, Failure)
+	call(b, Success)
+	call(b, Failure)
+	call(b, Failure)
+	if got := b.Stats().State; got != "closed" {
+		t.Fatalf("state after interrupted failures = %s, want closed", got)
+	}
+
+	call(b, Failure)
+	if got := b.Stats().State; got != "open" {
+		t.Fatalf("state after 3 consecutive failures = %s, want open", got)
+	}
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("Allow while open = %v, want ErrOpen", err)
+	}
+	if st := b.Stats(); st.Trips != 1 || st.Rejected != 1 || st.OpenedAt == nil {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTestBreaker(&now)
+	for i := 0; i < 3; i++ {
+		call(b, Failure)
+	}
+
+	now = now.Add(10 * time.Second)
+	if got := b.Stats().State; got != "half-open" {
+		t.Fatalf("state after the open timeout = %s, want half-open", got)
+	}
+
+	// A single probe is let through, a failed probe opens the circuit again
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("second concurrent probe = %v, want ErrOpen", err)
+	}
+	b.Record(Failure)
+	if got := b.Stats().State; got != "open" {
+		t.Fatalf("state after a failed probe = %s, want open", got)
+	}
+
+	// Ignored probes give their slot back, a successful probe closes the circuit
+	now = now.Add(10 * time.Second)
+	if err := call(b, Ignored); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if got := b.Stats().State; got != "half-open" {
+		t.Fatalf("state after an ignored probe = %s, want half-open", got)
+	}
+	if err := call(b, Success); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if got := b.Stats().State; got != "closed" {
+		t.Fatalf("state after a successful probe = %s, want closed", got)
+	}
+}
@@ -0,0 +1,23 @@
+package circuit
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor rejects calls with Unavailable while the circuit of b is open,
+// classify decides how each call's error counts
+func UnaryClientInterceptor(b *Breaker, name string, classify func(error) Result) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := b.Allow(); err != nil {
+			return status.Errorf(codes.Unavailable, "%s circuit is open", name)
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.Record(classify(err))
+		return err
+	}
+}
@@ -0,0 +1,66 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor holds outgoing calls to the limit of l, shed calls fail with Unavailable and
+// match ErrShed for interceptors further out. classify sets the priority of calls without one in
+// their context. A nil limiter passes calls through.
+func UnaryClientInterceptor(l *Limiter, name string, classify func(context.Context) Priority) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if l == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		p, ok := PriorityFromContext(ctx)
+		if !ok {
+			p = classify(ctx)
+		}
+		permit, err := l.Acquire(ctx, p)
+		if errors.Is(err, ErrShed) {
+			return shedError{name: name}
+		}
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		permit.Release(outcome(err))
+		return err
+	}
+}
+
+// outcome classifies a call by its status, errors other than timeouts and overload are still
+// answers and count as successes
+func outcome(err error) Outcome {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unavailable:
+		return Dropped
+	case codes.Canceled:
+		return Ignored
+	}
+	return Success
+}
+
+// shedError is a gRPC Unavailable status that still matches ErrShed
+type shedError struct {
+	name string
+}
+
+func (e shedError) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e shedError) GRPCStatus() *status.Status {
+	return status.Newf(codes.Unavailable, "%s is overloaded", e.name)
+}
+
+func (e shedError) Is(target error) bool {
+	return target == ErrShed
+}
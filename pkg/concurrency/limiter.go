@@ -0,0 +1,349 @@
+// Package concurrency adapts the number of calls in flight to an upstream to its observed
+// latency, queueing calls by priority and shedding them when the queue is full
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrShed is returned when a call gets no slot, because the queue was full or the wait timed out
+var ErrShed = errors.New("concurrency limit reached")
+
+// Priority orders the calls waiting for a slot, higher classes are served first and shed last
+type Priority int
+
+const (
+	// Low is background work such as mirrored calls
+	Low Priority = iota
+	Normal
+	// Critical is admin traffic
+	Critical
+
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"low", "normal", "critical"}
+
+func (p Priority) String() string {
+	if p < 0 || p >= numPriorities {
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+type priorityKey struct{}
+
+// WithPriority sets the priority of the upstream calls made with ctx
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set with WithPriority
+func PriorityFromContext(ctx context.Context) (Priority, bool) {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	return p, ok
+}
+
+// Outcome is how a call ended, it decides how the call's latency moves the limit
+type Outcome int
+
+const (
+	Success Outcome = iota
+	// Dropped calls timed out or were rejected as overloaded, they lower the limit
+	Dropped
+	// Ignored calls, e.g. canceled by the client, say nothing about the upstream
+	Ignored
+)
+
+const (
+	// EWMA weights of the recent and the baseline latency
+	shortAlpha = 2.0 / (10 + 1)
+	longAlpha  = 2.0 / (500 + 1)
+	// smoothing damps gradient limit changes
+	smoothing = 0.2
+	// backoff is the multiplicative decrease of AIMD
+	backoff = 0.9
+)
+
+// Config selects the algorithm and bounds of a Limiter
+type Config struct {
+	// Algorithm is "gradient", "aimd" or "off"
+	Algorithm    string
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// Tolerance is how many times the baseline latency calls may take before the limit is lowered
+	Tolerance float64
+	// QueueSize bounds the calls waiting for a slot, QueueTimeout how long each waits
+	QueueSize    int
+	QueueTimeout time.Duration
+}
+
+// Stats describes a limiter for admin inspection
+type Stats struct {
+	Algorithm         string         `json:"algorithm"`
+	Limit             int            `json:"limit"`
+	InFlight          int            `json:"in_flight"`
+	Queued            map[string]int `json:"queued"`
+	Accepted          uint64         `json:"accepted"`
+	Shed              uint64         `json:"shed"`
+	LatencyMS         float64        `json:"latency_ms"`
+	BaselineLatencyMS float64        `json:"baseline_latency_ms"`
+}
+
+// Limiter bounds the calls in flight. The gradient algorithm scales the limit by how far the
+// recent latency strays from the baseline, AIMD adds one slot per fast call under load and cuts
+// the limit by a tenth on slow or dropped calls.
+type Limiter struct {
+	config Config
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queues   [numPriorities][]*waiter
+	queued   int
+	// Recent and baseline latency in nanoseconds
+	short, long float64
+	samples     uint64
+	accepted    uint64
+	shed        uint64
+}
+
+type waiter struct {
+	priority Priority
+	ready    chan struct{}
+	// granted is set before ready is closed, a waiter closed without it was evicted
+	granted bool
+}
+
+// New creates a Limiter, nil when the algorithm is "off"
+func New(config Config) (*Limiter, error) {
+	switch config.Algorithm {
+	case "off":
+		return nil, nil
+	case "", "gradient":
+		config.Algorithm = "gradient"
+	case "aimd":
+	default:
+		return nil, fmt.Errorf("unknown concurrency algorithm %q, expected gradient, aimd or off", config.Algorithm)
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = 5
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 500
+	}
+	if config.MinLimit > config.MaxLimit {
+		return nil, fmt.Errorf("minimum concurrency limit %d is above the maximum %d", config.MinLimit, config.MaxLimit)
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = 20
+	}
+	if config.Tolerance < 1 {
+		config.Tolerance = 2
+	}
+	if config.QueueSize < 0 {
+		config.QueueSize = 0
+	}
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = 500 * time.Millisecond
+	}
+
+	l := &Limiter{config: config}
+	l.limit = l.clamp(float64(config.InitialLimit))
+	return l, nil
+}
+
+// Permit is a slot held by a call, it must be released once the call ends
+type Permit struct {
+	l     *Limiter
+	start time.Time
+}
+
+// Acquire waits for a slot. A call that finds the queue full evicts the newest waiter of a lower
+// priority, or is shed with ErrShed when there is none.
+func (l *Limiter) Acquire(ctx context.Context, p Priority) (*Permit, error) {
+	if p < 0 || p >= numPriorities {
+		p = Normal
+	}
+
+	l.mu.Lock()
+	// Waiters are only queued while every slot is taken, so a free slot is theirs to skip
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.accepted++
+		l.mu.Unlock()
+		return &Permit{l: l, start: time.Now()}, nil
+	}
+	w := &waiter{priority: p, ready: make(chan struct{})}
+	if !l.enqueue(w) {
+		l.shed++
+		l.mu.Unlock()
+		return nil, ErrShed
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		err = ErrShed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case w.granted:
+		// Granted while timing out, the slot is taken anyway
+		return &Permit{l: l, start: time.Now()}, nil
+	case err == nil:
+		// Evicted by a call of a higher priority, already counted as shed
+		return nil, ErrShed
+	}
+	l.remove(w)
+	if err == ErrShed {
+		l.shed++
+	}
+	return nil, err
+}
+
+// Release frees the slot and feeds the call's latency to the limit
+func (p *Permit) Release(outcome Outcome) {
+	l := p.l
+	rtt := time.Since(p.start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if outcome != Ignored {
+		l.update(float64(rtt), outcome == Dropped)
+	}
+	l.inFlight--
+	l.dispatch()
+}
+
+// update moves the limit with a latency sample, the slot of the sampled call is still counted in flight
+func (l *Limiter) update(rtt float64, dropped bool) {
+	if l.samples == 0 {
+		l.short, l.long = rtt, rtt
+	} else {
+		l.short += (rtt - l.short) * shortAlpha
+		// The baseline follows faster calls quickly and slower ones slowly, so sustained overload
+		// doesn't become the new normal
+		if rtt < l.long {
+			l.long += (rtt - l.long) * shortAlpha
+		} else {
+			l.long += (rtt - l.long) * longAlpha
+		}
+	}
+	l.samples++
+
+	// A limit far above the load says nothing about the upstream, it is neither raised nor lowered
+	// on fast calls
+	appLimited := float64(l.inFlight) < l.limit/2
+	slow := rtt > l.config.Tolerance*l.long
+
+	switch l.config.Algorithm {
+	case "aimd":
+		if dropped || slow {
+			l.limit *= backoff
+		} else if !appLimited {
+			l.limit++
+		}
+	default:
+		if appLimited && !dropped {
+			return
+		}
+		gradient := math.Max(0.5, math.Min(1, l.config.Tolerance*l.long/l.short))
+		if dropped {
+			gradient = 0.5
+		}
+		// The square root leaves room for a small queue at the upstream
+		next := l.limit*gradient + math.Sqrt(l.limit)
+		l.limit = l.limit*(1-smoothing) + next*smoothing
+	}
+	l.limit = l.clamp(l.limit)
+}
+
+// enqueue queues w, evicting a lower priority waiter when the queue is full
+func (l *Limiter) enqueue(w *waiter) bool {
+	if l.queued >= l.config.QueueSize {
+		evicted := false
+		for p := Low; p < w.priority && !evicted; p++ {
+			if n := len(l.queues[p]); n > 0 {
+				victim := l.queues[p][n-1]
+				l.queues[p] = l.queues[p][:n-1]
+				l.queued--
+				l.shed++
+				close(victim.ready)
+				evicted = true
+			}
+		}
+		if !evicted {
+			return false
+		}
+	}
+	l.queues[w.priority] = append(l.queues[w.priority], w)
+	l.queued++
+	return true
+}
+
+func (l *Limiter) remove(w *waiter) {
+	q := l.queues[w.priority]
+	for i, other := range q {
+		if other == w {
+			l.queues[w.priority] = append(q[:i], q[i+1:]...)
+			l.queued--
+			return
+		}
+	}
+}
+
+// dispatch hands free slots to the oldest waiters of the highest priority
+func (l *Limiter) dispatch() {
+	for p := numPriorities - 1; p >= Low; p-- {
+		for len(l.queues[p]) > 0 && l.inFlight < int(l.limit) {
+			w := l.queues[p][0]
+			l.queues[p] = l.queues[p][1:]
+			l.queued--
+			l.inFlight++
+			l.accepted++
+			w.granted = true
+			close(w.ready)
+		}
+	}
+}
+
+func (l *Limiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), limit))
+}
+
+// Stats returns the current limit, load and counters
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queued := make(map[string]int, numPriorities)
+	for p := Low; p < numPriorities; p++ {
+		queued[p.String()] = len(l.queues[p])
+	}
+	return Stats{
+		Algorithm:         l.config.Algorithm,
+		Limit:             int(l.limit),
+		InFlight:          l.inFlight,
+		Queued:            queued,
+		Accepted:          l.accepted,
+		Shed:              l.shed,
+		LatencyMS:         l.short / float64(time.Millisecond),
+		BaselineLatencyMS: l.long / float64(time.Millisecond),
+	}
+}
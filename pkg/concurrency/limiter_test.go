@@ -0,0 +1,161 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newLimiter(t *testing.T, config Config) *Limiter {
+	t.Helper()
+	l, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestNewConfig(t *testing.T) {
+	if l, err := New(Config{Algorithm: "off"}); l != nil || err != nil {
+		t.Fatalf("off must disable the limiter, got %v, %v", l, err)
+	}
+	if _, err := New(Config{Algorithm: "vegas"}); err == nil {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+	if _, err := New(Config{MinLimit: 10, MaxLimit: 5}); err == nil {
+		t.Fatal("expected an error for a minimum above the maximum")
+	}
+}
+
+func TestAcquireShedsWhenQueueIsFull(t *testing.T) {
+	l := newLimiter(t, Config{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, QueueSize: 0})
+	ctx := context.Background()
+
+	permit, err := l.Acquire(ctx, Normal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(ctx, Normal); !errors.Is(err, ErrShed) {
+		t.Fatalf("expected ErrShed, got %v", err)
+	}
+	permit.Release(Success)
+	if _, err := l.Acquire(ctx, Normal); err != nil {
+		t.Fatalf("the released slot was not reused: %v", err)
+	}
+	if stats := l.Stats(); stats.Shed != 1 || stats.Accepted != 2 || stats.InFlight != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAcquireQueueTimeout(t *testing.T) {
+	l := newLimiter(t, Config{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, QueueSize: 1, QueueTimeout: 20 * time.Millisecond})
+	if _, err := l.Acquire(context.Background(), Normal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), Normal); !errors.Is(err, ErrShed) {
+		t.Fatalf("expected a queued call to be shed after the timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, Normal); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	if stats := l.Stats(); stats.Queued["normal"] != 0 {
+		t.Fatalf("waiters left in the queue: %+v", stats)
+	}
+}
+
+func TestHigherPriorityIsServedFirstAndEvicts(t *testing.T) {
+	l := newLimiter(t, Config{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, QueueSize: 1, QueueTimeout: time.Second})
+	ctx := context.Background()
+
+	permit, err := l.Acquire(ctx, Normal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	low := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire(ctx, Low)
+		low <- err
+	}()
+	waitQueued(t, l, "low", 1)
+
+	critical := make(chan error, 1)
+	go func() {
+		p, err := l.Acquire(ctx, Critical)
+		if err == nil {
+			p.Release(Success)
+		}
+		critical <- err
+	}()
+
+	// The full queue makes room for the critical call by shedding the low one
+	if err := <-low; !errors.Is(err, ErrShed) {
+		t.Fatalf("expected the low priority call to be evicted, got %v", err)
+	}
+	waitQueued(t, l, "critical", 1)
+	permit.Release(Success)
+	if err := <-critical; err != nil {
+		t.Fatalf("critical call: %v", err)
+	}
+}
+
+func waitQueued(t *testing.T, l *Limiter, priority string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Queued[priority] != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d %s waiters, stats %+v", n, priority, l.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAIMDAdjustsLimit(t *testing.T) {
+	l := newLimiter(t, Config{Algorithm: "aimd", InitialLimit: 10, MinLimit: 2, MaxLimit: 20})
+
+	// Dropped calls cut the limit
+	for i := 0; i < 5; i++ {
+		l.inFlight++
+		l.update(float64(time.Millisecond), true)
+		l.inFlight--
+	}
+	if got := l.Stats().Limit; got >= 10 {
+		t.Fatalf("limit %d was not lowered by dropped calls", got)
+	}
+
+	// Fast calls under load raise it, up to the maximum
+	l.limit = 4
+	for i := 0; i < 50; i++ {
+		l.inFlight = int(l.limit)
+		l.update(float64(time.Millisecond), false)
+	}
+	l.inFlight = 0
+	if got := l.Stats().Limit; got != 20 {
+		t.Fatalf("limit %d, want the maximum of 20", got)
+	}
+}
+
+func TestGradientLowersLimitWhenLatencyRises(t *testing.T) {
+	l := newLimiter(t, Config{InitialLimit: 50, MinLimit: 5, MaxLimit: 100})
+
+	for i := 0; i < 100; i++ {
+		l.inFlight = int(l.limit)
+		l.update(float64(10*time.Millisecond), false)
+	}
+	before := l.limit
+	for i := 0; i < 50; i++ {
+		l.inFlight = int(l.limit)
+		l.update(float64(100*time.Millisecond), false)
+	}
+	l.inFlight = 0
+	if l.limit >= before {
+		t.Fatalf("limit %.1f did not drop below %.1f as latency rose tenfold", l.limit, before)
+	}
+	if l.limit < 5 {
+		t.Fatalf("limit %.1f fell below the minimum", l.limit)
+	}
+}
@@ -1,11 +1,149 @@
 package config
 
+import "time"
+
 type Config struct {
 	JWTSecret      string `mapstructure:"JWT_SRC"`
 	HTTPPort       string `mapstructure:"HTTP_PORT"`
 	GRPCPort       string `mapstructure:"GRPC_PORT"`
 	AuthServiceURL string `mapstructure:"AUTH_SERVICE_URL"`
 	UserServiceURL string `mapstructure:"USER_SERVICE_URL"`
+	APIKeyFile     string `mapstructure:"API_KEY_FILE"`
+
+	IntrospectionURL          string        `mapstructure:"INTROSPECTION_URL"`
+	IntrospectionClientID     string        `mapstructure:"INTROSPECTION_CLIENT_ID"`
+	IntrospectionClientSecret string        `mapstructure:"INTROSPECTION_CLIENT_SECRET"`
+	IntrospectionCacheTTL     time.Duration `mapstructure:"INTROSPECTION_CACHE_TTL"`
+
+	IntrospectionTLSCAFile     string `mapstructure:"INTROSPECTION_TLS_CA_FILE"`
+	IntrospectionTLSCertFile   string `mapstructure:"INTROSPECTION_TLS_CERT_FILE"`
+	IntrospectionTLSKeyFile    string `mapstructure:"INTROSPECTION_TLS_KEY_FILE"`
+	IntrospectionTLSServerName string `mapstructure:"INTROSPECTION_TLS_SERVER_NAME"`
+
+	TLSCertFile       string        `mapstructure:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `mapstructure:"TLS_KEY_FILE"`
+	TLSClientCAFile   string        `mapstructure:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth     string        `mapstructure:"TLS_CLIENT_AUTH"`
+	TLSClientScopes   string        `mapstructure:"TLS_CLIENT_SCOPES"`
+	TLSMinVersion     string        `mapstructure:"TLS_MIN_VERSION"`
+	TLSCipherSuites   string        `mapstructure:"TLS_CIPHER_SUITES"`
+	TLSReloadInterval time.Duration `mapstructure:"TLS_RELOAD_INTERVAL"`
+
+	AuthServiceTLS           bool   `mapstructure:"AUTH_SERVICE_TLS"`
+	AuthServiceTLSCAFile     string `mapstructure:"AUTH_SERVICE_TLS_CA_FILE"`
+	AuthServiceTLSCertFile   string `mapstructure:"AUTH_SERVICE_TLS_CERT_FILE"`
+	AuthServiceTLSKeyFile    string `mapstructure:"AUTH_SERVICE_TLS_KEY_FILE"`
+	AuthServiceTLSServerName string `mapstructure:"AUTH_SERVICE_TLS_SERVER_NAME"`
+	UserServiceTLS           bool   `mapstructure:"USER_SERVICE_TLS"`
+	UserServiceTLSCAFile     string `mapstructure:"USER_SERVICE_TLS_CA_FILE"`
+	UserServiceTLSCertFile   string `mapstructure:"USER_SERVICE_TLS_CERT_FILE"`
+	UserServiceTLSKeyFile    string `mapstructure:"USER_SERVICE_TLS_KEY_FILE"`
+	UserServiceTLSServerName string `mapstructure:"USER_SERVICE_TLS_SERVER_NAME"`
+
+	RevocationRedisAddr     string `mapstructure:"REVOCATION_REDIS_ADDR"`
+	RevocationRedisPassword string `mapstructure:"REVOCATION_REDIS_PASSWORD"`
+	RevocationRedisDB       int    `mapstructure:"REVOCATION_REDIS_DB"`
+
+	InternalTokenSecret string        `mapstructure:"INTERNAL_TOKEN_SECRET"`
+	InternalTokenTTL    time.Duration `mapstructure:"INTERNAL_TOKEN_TTL"`
+
+	AdminToken string `mapstructure:"ADMIN_TOKEN"`
+
+	HTTPReadHeaderTimeout time.Duration `mapstructure:"HTTP_READ_HEADER_TIMEOUT"`
+	HTTPIdleTimeout       time.Duration `mapstructure:"HTTP_IDLE_TIMEOUT"`
+	HTTPMaxHeaderBytes    int           `mapstructure:"HTTP_MAX_HEADER_BYTES"`
+	HTTPMaxBodyBytes      int64         `mapstructure:"HTTP_MAX_BODY_BYTES"`
+	HTTPRouteBodyLimits   string        `mapstructure:"HTTP_ROUTE_BODY_LIMITS"`
+	GRPCMaxRecvMsgSize    int           `mapstructure:"GRPC_MAX_RECV_MSG_SIZE"`
+	GRPCConnectionTimeout time.Duration `mapstructure:"GRPC_CONNECTION_TIMEOUT"`
+	GRPCKeepaliveTime     time.Duration `mapstructure:"GRPC_KEEPALIVE_TIME"`
+	GRPCKeepaliveTimeout  time.Duration `mapstructure:"GRPC_KEEPALIVE_TIMEOUT"`
+	GRPCKeepaliveMinTime  time.Duration `mapstructure:"GRPC_KEEPALIVE_MIN_TIME"`
+	GRPCMaxConnectionIdle time.Duration `mapstructure:"GRPC_MAX_CONNECTION_IDLE"`
+
+	GRPCLogSampleRate    *float64      `mapstructure:"GRPC_LOG_SAMPLE_RATE"` // nil when unset, 0 is a valid rate
+	GRPCLogSlowThreshold time.Duration `mapstructure:"GRPC_LOG_SLOW_THRESHOLD"`
+	GRPCLogMetadata      string        `mapstructure:"GRPC_LOG_METADATA"`
+
+	ValidationRulesFile string `mapstructure:"VALIDATION_RULES_FILE"`
+
+	IdempotencyTTL time.Duration `mapstructure:"IDEMPOTENCY_TTL"`
+
+	UserBatchConcurrency int `mapstructure:"USER_BATCH_CONCURRENCY"`
+
+	GraphQLMaxDepth      int `mapstructure:"GRAPHQL_MAX_DEPTH"`
+	GraphQLMaxComplexity int `mapstructure:"GRAPHQL_MAX_COMPLEXITY"`
+
+	AuditLogFile       string `mapstructure:"AUDIT_LOG_FILE"`
+	AuditLogMaxSize    int    `mapstructure:"AUDIT_LOG_MAX_SIZE"`
+	AuditLogMaxBackups int    `mapstructure:"AUDIT_LOG_MAX_BACKUPS"`
+	AuditLogMaxAge     int    `mapstructure:"AUDIT_LOG_MAX_AGE"`
+
+	RedactHashKey   string `mapstructure:"REDACT_HASH_KEY"`
+	RedactRulesFile string `mapstructure:"REDACT_RULES_FILE"`
+
+	LogLevel              string `mapstructure:"LOG_LEVEL"`
+	LogEncoding           string `mapstructure:"LOG_ENCODING"`
+	LogFile               string `mapstructure:"LOG_FILE"`
+	LogMaxSize            int    `mapstructure:"LOG_MAX_SIZE"`
+	LogMaxBackups         int    `mapstructure:"LOG_MAX_BACKUPS"`
+	LogMaxAge             int    `mapstructure:"LOG_MAX_AGE"`
+	LogCompress           bool   `mapstructure:"LOG_COMPRESS"`
+	LogSamplingInitial    int    `mapstructure:"LOG_SAMPLING_INITIAL"`
+	LogSamplingThereafter int    `mapstructure:"LOG_SAMPLING_THEREAFTER"`
+
+	MiddlewareChainsFile string `mapstructure:"MIDDLEWARE_CHAINS_FILE"`
+	WASMFiltersFile      string `mapstructure:"WASM_FILTERS_FILE"`
+
+	UserServiceSubsetsFile string `mapstructure:"USER_SERVICE_SUBSETS_FILE"`
+
+	UserServiceMirrorURL            string        `mapstructure:"USER_SERVICE_MIRROR_URL"`
+	UserServiceMirrorMethods        string        `mapstructure:"USER_SERVICE_MIRROR_METHODS"`
+	UserServiceMirrorTimeout        time.Duration `mapstructure:"USER_SERVICE_MIRROR_TIMEOUT"`
+	UserServiceMirrorMaxConcurrency int           `mapstructure:"USER_SERVICE_MIRROR_MAX_CONCURRENCY"`
+
+	Env                           string `mapstructure:"ENV"`
+	FaultInjectionAllowProduction bool   `mapstructure:"FAULT_INJECTION_ALLOW_PRODUCTION"`
+
+	UpstreamConcurrencyAlgorithm    string        `mapstructure:"UPSTREAM_CONCURRENCY_ALGORITHM"`
+	UpstreamConcurrencyInitialLimit int           `mapstructure:"UPSTREAM_CONCURRENCY_INITIAL_LIMIT"`
+	UpstreamConcurrencyMinLimit     int           `mapstructure:"UPSTREAM_CONCURRENCY_MIN_LIMIT"`
+	UpstreamConcurrencyMaxLimit     int           `mapstructure:"UPSTREAM_CONCURRENCY_MAX_LIMIT"`
+	UpstreamConcurrencyTolerance    float64       `mapstructure:"UPSTREAM_CONCURRENCY_TOLERANCE"`
+	UpstreamConcurrencyQueueSize    int           `mapstructure:"UPSTREAM_CONCURRENCY_QUEUE_SIZE"`
+	UpstreamConcurrencyQueueTimeout time.Duration `mapstructure:"UPSTREAM_CONCURRENCY_QUEUE_TIMEOUT"`
+
+	UpstreamCircuitFailureThreshold int           `mapstructure:"UPSTREAM_CIRCUIT_FAILURE_THRESHOLD"`
+	UpstreamCircuitOpenTimeout      time.Duration `mapstructure:"UPSTREAM_CIRCUIT_OPEN_TIMEOUT"`
+	UpstreamCircuitHalfOpenCalls    int           `mapstructure:"UPSTREAM_CIRCUIT_HALF_OPEN_CALLS"`
 }
 
-var envs = []string{"JWT_SRC", "HTTP_PORT", "GRPC_PORT", "AUTH_SERVICE_URL", "USER_SERVICE_URL"}
+var envs = []string{
+	"JWT_SRC", "HTTP_PORT", "GRPC_PORT", "AUTH_SERVICE_URL", "USER_SERVICE_URL", "API_KEY_FILE",
+	"INTROSPECTION_URL", "INTROSPECTION_CLIENT_ID", "INTROSPECTION_CLIENT_SECRET", "INTROSPECTION_CACHE_TTL",
+	"INTROSPECTION_TLS_CA_FILE", "INTROSPECTION_TLS_CERT_FILE", "INTROSPECTION_TLS_KEY_FILE", "INTROSPECTION_TLS_SERVER_NAME",
+	"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_CLIENT_CA_FILE", "TLS_CLIENT_AUTH", "TLS_CLIENT_SCOPES", "TLS_MIN_VERSION", "TLS_CIPHER_SUITES", "TLS_RELOAD_INTERVAL",
+	"AUTH_SERVICE_TLS", "AUTH_SERVICE_TLS_CA_FILE", "AUTH_SERVICE_TLS_CERT_FILE", "AUTH_SERVICE_TLS_KEY_FILE", "AUTH_SERVICE_TLS_SERVER_NAME",
+	"USER_SERVICE_TLS", "USER_SERVICE_TLS_CA_FILE", "USER_SERVICE_TLS_CERT_FILE", "USER_SERVICE_TLS_KEY_FILE", "USER_SERVICE_TLS_SERVER_NAME",
+	"REVOCATION_REDIS_ADDR", "REVOCATION_REDIS_PASSWORD", "REVOCATION_REDIS_DB",
+	"INTERNAL_TOKEN_SECRET", "INTERNAL_TOKEN_TTL",
+	"ADMIN_TOKEN",
+	"HTTP_READ_HEADER_TIMEOUT", "HTTP_IDLE_TIMEOUT", "HTTP_MAX_HEADER_BYTES", "HTTP_MAX_BODY_BYTES", "HTTP_ROUTE_BODY_LIMITS",
+	"GRPC_MAX_RECV_MSG_SIZE", "GRPC_CONNECTION_TIMEOUT", "GRPC_KEEPALIVE_TIME", "GRPC_KEEPALIVE_TIMEOUT", "GRPC_KEEPALIVE_MIN_TIME", "GRPC_MAX_CONNECTION_IDLE",
+	"GRPC_LOG_SAMPLE_RATE", "GRPC_LOG_SLOW_THRESHOLD", "GRPC_LOG_METADATA",
+	"VALIDATION_RULES_FILE",
+	"IDEMPOTENCY_TTL",
+	"USER_BATCH_CONCURRENCY",
+	"GRAPHQL_MAX_DEPTH", "GRAPHQL_MAX_COMPLEXITY",
+	"AUDIT_LOG_FILE", "AUDIT_LOG_MAX_SIZE", "AUDIT_LOG_MAX_BACKUPS", "AUDIT_LOG_MAX_AGE",
+	"REDACT_HASH_KEY", "REDACT_RULES_FILE",
+	"LOG_LEVEL", "LOG_ENCODING", "LOG_FILE", "LOG_MAX_SIZE", "LOG_MAX_BACKUPS", "LOG_MAX_AGE", "LOG_COMPRESS",
+	"LOG_SAMPLING_INITIAL", "LOG_SAMPLING_THEREAFTER",
+	"MIDDLEWARE_CHAINS_FILE", "WASM_FILTERS_FILE",
+	"USER_SERVICE_SUBSETS_FILE",
+	"USER_SERVICE_MIRROR_URL", "USER_SERVICE_MIRROR_METHODS", "USER_SERVICE_MIRROR_TIMEOUT", "USER_SERVICE_MIRROR_MAX_CONCURRENCY",
+	"ENV", "FAULT_INJECTION_ALLOW_PRODUCTION",
+	"UPSTREAM_CONCURRENCY_ALGORITHM", "UPSTREAM_CONCURRENCY_INITIAL_LIMIT", "UPSTREAM_CONCURRENCY_MIN_LIMIT", "UPSTREAM_CONCURRENCY_MAX_LIMIT",
+	"UPSTREAM_CONCURRENCY_TOLERANCE", "UPSTREAM_CONCURRENCY_QUEUE_SIZE", "UPSTREAM_CONCURRENCY_QUEUE_TIMEOUT",
+	"UPSTREAM_CIRCUIT_FAILURE_THRESHOLD", "UPSTREAM_CIRCUIT_OPEN_TIMEOUT", "UPSTREAM_CIRCUIT_HALF_OPEN_CALLS",
+}
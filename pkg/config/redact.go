@@ -0,0 +1,47 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// secretMarkers flag config keys whose values must never be exposed
+var secretMarkers = []string{"SECRET", "PASSWORD", "JWT_SRC", "ADMIN_TOKEN", "HASH_KEY"}
+
+// Redacted returns the config keyed by env name with secret values masked
+func Redacted(conf Config) map[string]interface{} {
+	out := make(map[string]interface{})
+
+	v := reflect.ValueOf(conf)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		if isSecret(key) {
+			if v.Field(i).IsZero() {
+				value = ""
+			} else {
+				value = "[REDACTED]"
+			}
+		}
+		out[key] = value
+	}
+	return out
+}
+
+func isSecret(key string) bool {
+	for _, marker := range secretMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+// Package fault injects latency, aborts and dropped responses into matching requests, for
+// testing how clients cope with a failing gateway
+package fault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// maxDelay bounds injected latency
+const maxDelay = 5 * time.Minute
+
+// ErrProduction is returned when faults are enabled in production without the override
+var ErrProduction = errors.New("fault injection is disabled in production")
+
+// Rule injects faults into a percentage of the requests it matches. Route limits it to HTTP
+// paths with that prefix and Method to gRPC methods with that prefix, with neither it
+// applies to both. Header and UserID narrow it further.
+type Rule struct {
+	Route       string `json:"route,omitempty"`
+	Method      string `json:"method,omitempty"`
+	Header      string `json:"header,omitempty"`
+	HeaderValue string `json:"header_value,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+
+	Percent float64 `json:"percent"`
+	// Delay is added before the request is handled, e.g. "250ms"
+	Delay string `json:"delay,omitempty"`
+	// AbortHTTP and AbortGRPC answer the request with this status instead of handling it
+	AbortHTTP int         `json:"abort_http,omitempty"`
+	AbortGRPC *codes.Code `json:"abort_grpc,omitempty"`
+	// Drop handles the request and discards the response, the client sees the connection
+	// reset (HTTP) or its deadline pass (gRPC)
+	Drop bool `json:"drop,omitempty"`
+
+	// Injected counts the requests the rule injected a fault into, it is ignored on input
+	Injected uint64 `json:"injected"`
+}
+
+// Request is what rules are matched against
+type Request struct {
+	// Path is the HTTP path or the full gRPC method
+	Path   string
+	GRPC   bool
+	Header func(name string) string
+	UserID string
+}
+
+// Fault is what to do with a matched request
+type Fault struct {
+	Delay     time.Duration
+	AbortHTTP int
+	AbortGRPC codes.Code
+	Abort     bool
+	Drop      bool
+}
+
+// rule is an enabled Rule with its parsed delay
+type rule struct {
+	Rule
+	delay    time.Duration
+	injected atomic.Uint64
+}
+
+// Injector holds the fault rules, it starts disabled
+type Injector struct {
+	env             string
+	allowProduction bool
+
+	mu      sync.RWMutex
+	enabled bool
+	rules   []*rule
+}
+
+// NewInjector creates a disabled Injector. In a production env it only enables with allowProduction.
+func NewInjector(env string, allowProduction bool) *Injector {
+	return &Injector{env: env, allowProduction: allowProduction}
+}
+
+// Enable replaces the rules and starts injecting
+func (i *Injector) Enable(rules []Rule) error {
+	if strings.EqualFold(i.env, "production") && !i.allowProduction {
+		return ErrProduction
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("no fault rules")
+	}
+
+	parsed := make([]*rule, len(rules))
+	for n, r := range rules {
+		p, err := parseRule(r)
+		if err != nil {
+			return fmt.Errorf("rule %d: %v", n, err)
+		}
+		parsed[n] = p
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.enabled = true
+	i.rules = parsed
+	return nil
+}
+
+// Disable stops injecting and drops the rules
+func (i *Injector) Disable() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.enabled = false
+	i.rules = nil
+}
+
+// Rules reports whether injection is enabled and returns the rules with their counts
+func (i *Injector) Rules() (bool, []Rule) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	rules := make([]Rule, len(i.rules))
+	for n, r := range i.rules {
+		rules[n] = r.Rule
+		rules[n].Injected = r.injected.Load()
+	}
+	return i.enabled, rules
+}
+
+// Match returns the fault for req, nil when no rule matches or the percentage roll misses.
+// Only the first matching rule is considered.
+func (i *Injector) Match(req Request) *Fault {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.enabled {
+		return nil
+	}
+
+	for _, r := range i.rules {
+		if !r.matches(req) {
+			continue
+		}
+		if rand.Float64()*100 >= r.Percent {
+			return nil
+		}
+		r.injected.Add(1)
+
+		f := &Fault{Delay: r.delay, AbortHTTP: r.AbortHTTP, Drop: r.Drop}
+		if r.AbortGRPC != nil {
+			f.AbortGRPC = *r.AbortGRPC
+		}
+		f.Abort = (req.GRPC && r.AbortGRPC != nil) || (!req.GRPC && r.AbortHTTP != 0)
+		return f
+	}
+	return nil
+}
+
+// Wait sleeps for the delay of f, returning early with the context error when ctx ends
+func (f *Fault) Wait(ctx context.Context) error {
+	if f.Delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(f.Delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *rule) matches(req Request) bool {
+	abort := (req.GRPC && r.AbortGRPC != nil) || (!req.GRPC && r.AbortHTTP != 0)
+	switch {
+	case r.delay == 0 && !r.Drop && !abort:
+		// Only aborts the other protocol
+		return false
+	case r.Route != "" && (req.GRPC || !strings.HasPrefix(req.Path, r.Route)):
+		return false
+	case r.Method != "" && (!req.GRPC || !strings.HasPrefix(req.Path, r.Method)):
+		return false
+	case r.UserID != "" && req.UserID != r.UserID:
+		return false
+	}
+	if r.Header != "" {
+		v := req.Header(r.Header)
+		if v == "" || (r.HeaderValue != "" && v != r.HeaderValue) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRule(r Rule) (*rule, error) {
+	p := &rule{Rule: r}
+	p.Injected = 0
+
+	if r.Percent <= 0 || r.Percent > 100 {
+		return nil, fmt.Errorf("percent must be above 0 and at most 100")
+	}
+	if r.Route != "" && r.Method != "" {
+		return nil, fmt.Errorf("route and method are exclusive")
+	}
+	if r.Delay != "" {
+		d, err := time.ParseDuration(r.Delay)
+		if err != nil || d <= 0 || d > maxDelay {
+			return nil, fmt.Errorf("invalid delay %q, expected a duration up to %s", r.Delay, maxDelay)
+		}
+		p.delay = d
+	}
+	if r.AbortHTTP != 0 && (r.AbortHTTP < 400 || r.AbortHTTP > 599) {
+		return nil, fmt.Errorf("abort_http must be a 4xx or 5xx status")
+	}
+	if r.AbortGRPC != nil && (*r.AbortGRPC == codes.OK || *r.AbortGRPC > codes.Unauthenticated) {
+		return nil, fmt.Errorf("abort_grpc must be an error code")
+	}
+	abort := r.AbortHTTP != 0 || r.AbortGRPC != nil
+	if r.Drop && abort {
+		return nil, fmt.Errorf("drop and abort are exclusive")
+	}
+	if p.delay == 0 && !abort && !r.Drop {
+		return nil, fmt.Errorf("rule injects no fault, set delay, abort_http, abort_grpc or drop")
+	}
+	return p, nil
+}
@@ -0,0 +1,122 @@
+package fault
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestEnableInProduction(t *testing.T) {
+	rules := []Rule{{Percent: 100, AbortHTTP: http.StatusServiceUnavailable}}
+
+	tests := []struct {
+		env             string
+		allowProduction bool
+		want            error
+	}{
+		{env: "production", want: ErrProduction},
+		{env: "Production", want: ErrProduction},
+		{env: "production", allowProduction: true},
+		{env: "development"},
+		{env: ""},
+	}
+	for _, tt := range tests {
+		i := NewInjector(tt.env, tt.allowProduction)
+		if err := i.Enable(rules); !errors.Is(err, tt.want) {
+			t.Errorf("%q, override %v: got %v, want %v", tt.env, tt.allowProduction, err, tt.want)
+		}
+		if enabled, _ := i.Rules(); enabled != (tt.want == nil) {
+			t.Errorf("%q, override %v: enabled is %v", tt.env, tt.allowProduction, enabled)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	unavailable, ok := codes.Unavailable, codes.OK
+
+	tests := []struct {
+		name  string
+		rule  Rule
+		valid bool
+	}{
+		{name: "delay", rule: Rule{Percent: 50, Delay: "250ms"}, valid: true},
+		{name: "http abort", rule: Rule{Percent: 100, Route: "/api/v1/users", AbortHTTP: 503}, valid: true},
+		{name: "grpc abort", rule: Rule{Percent: 100, Method: "/user.UserService/", AbortGRPC: &unavailable}, valid: true},
+		{name: "drop with delay", rule: Rule{Percent: 1, Drop: true, Delay: "1s"}, valid: true},
+		{name: "no percent", rule: Rule{Delay: "1s"}},
+		{name: "percent above 100", rule: Rule{Percent: 101, Delay: "1s"}},
+		{name: "route and method", rule: Rule{Percent: 10, Route: "/api", Method: "/user.", Delay: "1s"}},
+		{name: "malformed delay", rule: Rule{Percent: 10, Delay: "soon"}},
+		{name: "negative delay", rule: Rule{Percent: 10, Delay: "-1s"}},
+		{name: "delay above the maximum", rule: Rule{Percent: 10, Delay: "1h"}},
+		{name: "success status", rule: Rule{Percent: 10, AbortHTTP: 200}},
+		{name: "grpc OK", rule: Rule{Percent: 10, AbortGRPC: &ok}},
+		{name: "drop and abort", rule: Rule{Percent: 10, Drop: true, AbortHTTP: 500}},
+		{name: "no fault", rule: Rule{Percent: 10}},
+	}
+	for _, tt := range tests {
+		_, err := parseRule(tt.rule)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	unavailable := codes.Unavailable
+	i := NewInjector("development", false)
+	err := i.Enable([]Rule{
+		{Percent: 100, Route: "/api/v1/users", Header: "X-Fault", HeaderValue: "abort", AbortHTTP: 503},
+		{Percent: 100, Method: "/user.UserService/", UserID: "u1", AbortGRPC: &unavailable},
+		{Percent: 100, Header: "X-Fault", Drop: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := func(value string) func(string) string {
+		return func(name string) string {
+			if name == "X-Fault" {
+				return value
+			}
+			return ""
+		}
+	}
+	tests := []struct {
+		name string
+		req  Request
+		want *Fault
+	}{
+		{name: "route and header value", req: Request{Path: "/api/v1/users/1", Header: header("abort")},
+			want: &Fault{AbortHTTP: 503, Abort: true}},
+		{name: "other header value falls through", req: Request{Path: "/api/v1/users/1", Header: header("drop")},
+			want: &Fault{Drop: true}},
+		{name: "no header", req: Request{Path: "/api/v1/users/1", Header: header("")}},
+		{name: "method and user", req: Request{Path: "/user.UserService/GetUser", GRPC: true, UserID: "u1", Header: header("")},
+			want: &Fault{AbortGRPC: codes.Unavailable, Abort: true}},
+		{name: "other user", req: Request{Path: "/user.UserService/GetUser", GRPC: true, UserID: "u2", Header: header("")}},
+		{name: "http abort doesn't apply to grpc", req: Request{Path: "/api/v1/users", GRPC: true, Header: header("abort")},
+			want: &Fault{Drop: true}},
+	}
+	for _, tt := range tests {
+		got := i.Match(tt.req)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	_, rules := i.Rules()
+	if rules[0].Injected != 1 || rules[1].Injected != 1 || rules[2].Injected != 2 {
+		t.Fatalf("unexpected injected counts %d, %d, %d", rules[0].Injected, rules[1].Injected, rules[2].Injected)
+	}
+
+	i.Disable()
+	if f := i.Match(Request{Path: "/api/v1/users", Header: header("abort")}); f != nil {
+		t.Fatalf("disabled injector matched %+v", f)
+	}
+}
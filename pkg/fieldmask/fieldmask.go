@@ -0,0 +1,178 @@
+package fieldmask
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// MaskField is the request field holding the update mask, as in the AIP-134 convention
+const MaskField = "update_mask"
+
+// AnyRole is the Policy entry used for roles without an entry of their own
+const AnyRole = "*"
+
+// Policy maps a resource message's full name to roles and the field paths they may write.
+// Fields that no role may write, such as IDs and timestamps, are output only.
+type Policy map[string]map[string][]string
+
+var profileFields = []string{"username", "email", "first_name", "last_name", "phone_number"}
+
+// DefaultPolicy lets admins and services manage status and roles, everyone else only the profile fields
+var DefaultPolicy = Policy{
+	"user.User": {
+		"admin":   append([]string{"status", "roles"}, profileFields...),
+		"service": append([]string{"status", "roles"}, profileFields...),
+		AnyRole:   profileFields,
+	},
+}
+
+// FromJSON derives a mask from the top level keys of a JSON object.
+// Keys may use either the JSON or the proto name of a field of md.
+func FromJSON(data []byte, md protoreflect.MessageDescriptor) (*fieldmaskpb.FieldMask, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("body must be a JSON object: %v", err)
+	}
+
+	mask := &fieldmaskpb.FieldMask{}
+	for key := range object {
+		fd := md.Fields().ByJSONName(key)
+		if fd == nil {
+			fd = md.Fields().ByName(protoreflect.Name(key))
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+		mask.Paths = append(mask.Paths, string(fd.Name()))
+	}
+	sort.Strings(mask.Paths)
+	return mask, nil
+}
+
+// Check verifies the update mask of req against the policy for role.
+// Requests without an update_mask field are not updates and always pass. Paths that
+// don't exist or are output only are rejected with codes.InvalidArgument, paths the role
+// may not write with codes.PermissionDenied. Without a mask the populated fields of the
+// resource are checked instead, skipping output only fields.
+func (p Policy) Check(role string, req proto.Message) error {
+	m := req.ProtoReflect()
+	maskField := m.Descriptor().Fields().ByName(MaskField)
+	resource := resourceField(m.Descriptor())
+	if maskField == nil || resource == nil {
+		return nil
+	}
+	roles, hasPolicy := p[string(resource.Message().FullName())]
+
+	mask, _ := m.Get(maskField).Message().Interface().(*fieldmaskpb.FieldMask)
+	if len(mask.GetPaths()) == 0 {
+		if !hasPolicy || !m.Has(resource) {
+			return nil
+		}
+		var denied []string
+		m.Get(resource).Message().Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			path := string(fd.Name())
+			if writableByAny(roles, path) && !writable(roles, role, path) {
+				denied = append(denied, path)
+			}
+			return true
+		})
+		return deniedError(denied)
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	var denied []string
+	for _, path := range mask.GetPaths() {
+		switch {
+		case !validPath(resource.Message(), path):
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: MaskField, Description: fmt.Sprintf("unknown field %q", path)})
+		case !hasPolicy:
+		case !writableByAny(roles, path):
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: MaskField, Description: fmt.Sprintf("field %q is output only", path)})
+		case !writable(roles, role, path):
+			denied = append(denied, path)
+		}
+	}
+
+	if len(violations) > 0 {
+		st := status.New(codes.InvalidArgument, "invalid update mask")
+		detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+		if err != nil {
+			return st.Err()
+		}
+		return detailed.Err()
+	}
+	return deniedError(denied)
+}
+
+// resourceField returns the message field being updated, i.e. the only message field besides the mask
+func resourceField(md protoreflect.MessageDescriptor) protoreflect.FieldDescriptor {
+	var resource protoreflect.FieldDescriptor
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Name() == MaskField || fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			continue
+		}
+		if resource != nil {
+			return nil
+		}
+		resource = fd
+	}
+	return resource
+}
+
+// validPath reports whether a dotted path names a field of md, descending into singular messages
+func validPath(md protoreflect.MessageDescriptor, path string) bool {
+	name, rest, nested := strings.Cut(path, ".")
+	fd := md.Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		return false
+	}
+	if !nested {
+		return true
+	}
+	if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+		return false
+	}
+	return validPath(fd.Message(), rest)
+}
+
+// writable reports whether role may write path. Granting a field grants its nested paths.
+func writable(roles map[string][]string, role, path string) bool {
+	fields, ok := roles[role]
+	if !ok {
+		fields = roles[AnyRole]
+	}
+	for _, field := range fields {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// writableByAny reports whether any role may write path
+func writableByAny(roles map[string][]string, path string) bool {
+	for role := range roles {
+		if writable(roles, role, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func deniedError(denied []string) error {
+	if len(denied) == 0 {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "not allowed to update %s", strings.Join(denied, ", "))
+}
@@ -0,0 +1,91 @@
+package fieldmask
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		req  *userpb.UpdateUserRequest
+		code codes.Code
+	}{
+		{
+			name: "profile fields",
+			role: "user",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email", "first_name"}}},
+			code: codes.OK,
+		},
+		{
+			name: "status by a user",
+			role: "user",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email", "status"}}},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "status by an admin",
+			role: "admin",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status", "roles"}}},
+			code: codes.OK,
+		},
+		{
+			name: "output only field",
+			role: "admin",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"created_at"}}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown field",
+			role: "admin",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "no mask checks the populated fields",
+			role: "user",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1", Email: "a@example.com", Status: userpb.UserStatus_USER_STATUS_SUSPENDED}},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "no mask skips output only fields",
+			role: "user",
+			req:  &userpb.UpdateUserRequest{User: &userpb.User{Id: "1", Email: "a@example.com"}},
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DefaultPolicy.Check(tt.role, tt.req)
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("got %s (%v), want %s", got, err, tt.code)
+			}
+		})
+	}
+}
+
+func TestCheckIgnoresOtherRequests(t *testing.T) {
+	if err := DefaultPolicy.Check("user", &userpb.CreateUserRequest{User: &userpb.User{Status: userpb.UserStatus_USER_STATUS_SUSPENDED}}); err != nil {
+		t.Fatalf("requests without an update mask must pass: %v", err)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	md := (&userpb.User{}).ProtoReflect().Descriptor()
+	mask, err := FromJSON([]byte(`{"firstName": "A", "phone_number": "+1 555 0100"}`), md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mask.Paths) != 2 || mask.Paths[0] != "first_name" || mask.Paths[1] != "phone_number" {
+		t.Fatalf("unexpected paths %v", mask.Paths)
+	}
+	if _, err := FromJSON([]byte(`{"nickname": "A"}`), md); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+}
@@ -0,0 +1,107 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+var errMissingResult = errors.New("batch returned no result for this request")
+
+// Request is a single GraphQL operation as posted by clients
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Handler serves POST requests carrying one operation or a JSON array of operations.
+// Operations of one HTTP request share a loader, so repeated lookups are batched and cached.
+// charge is called with the complexity of each operation before it runs, returning
+// false rejects it as rate limited. A nil charge accepts every operation.
+func Handler(schema *Schema, limits Limits, charge func(r *http.Request, complexity int) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeErrors(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeErrors(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+				return
+			}
+			writeErrors(w, http.StatusBadRequest, errors.New("failed to read request body"))
+			return
+		}
+
+		batched := len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '['
+		var reqs []Request
+		if batched {
+			err = json.Unmarshal(body, &reqs)
+		} else {
+			reqs = make([]Request, 1)
+			err = json.Unmarshal(body, &reqs[0])
+		}
+		if err != nil || len(reqs) == 0 {
+			writeErrors(w, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+
+		// Check every operation before running any of them
+		for _, req := range reqs {
+			doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+			if err != nil {
+				writeErrors(w, http.StatusBadRequest, err)
+				return
+			}
+			c, err := analyze(doc, req.OperationName, req.Variables)
+			if err == nil {
+				err = limits.check(c)
+			}
+			if err != nil {
+				writeErrors(w, http.StatusBadRequest, err)
+				return
+			}
+			if charge != nil && !charge(r, c.complexity) {
+				writeErrors(w, http.StatusTooManyRequests, errors.New("too many requests"))
+				return
+			}
+		}
+
+		ctx := contextWithLoader(r.Context(), newLoader())
+		results := make([]*graphql.Result, len(reqs))
+		for i, req := range reqs {
+			results[i] = graphql.Do(graphql.Params{
+				Schema:         schema.schema,
+				RequestString:  req.Query,
+				VariableValues: req.Variables,
+				OperationName:  req.OperationName,
+				Context:        ctx,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if batched {
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+		json.NewEncoder(w).Encode(results[0])
+	})
+}
+
+// writeErrors writes a GraphQL result that only carries errors
+func writeErrors(w http.ResponseWriter, code int, errs ...error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&graphql.Result{Errors: gqlerrors.FormatErrors(errs...)})
+}
@@ -0,0 +1,150 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// defaultListSize is the assumed length of list results whose size can't be told from the arguments
+const defaultListSize = 10
+
+// Limits bounds the shape of accepted operations, zero disables a limit
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+}
+
+// cost is the estimated shape of an operation
+type cost struct {
+	depth      int
+	complexity int
+}
+
+// analyze estimates the operation to be run. Every field costs one, the fields below
+// a list are multiplied by its expected length: the pageSize argument or the length of a
+// list argument. Introspection fields are free.
+func analyze(doc *ast.Document, operationName string, variables map[string]interface{}) (cost, error) {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	var operation *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				if operation != nil && operationName == "" {
+					return cost{}, fmt.Errorf("operationName is required for documents with several operations")
+				}
+				operation = def
+			}
+		}
+	}
+	if operation == nil {
+		return cost{}, fmt.Errorf("unknown operation %q", operationName)
+	}
+
+	a := &analyzer{fragments: fragments, variables: variables, visiting: make(map[string]bool)}
+	return a.selectionSet(operation.SelectionSet, 1), nil
+}
+
+type analyzer struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+}
+
+func (a *analyzer) selectionSet(set *ast.SelectionSet, depth int) cost {
+	var total cost
+	if set == nil {
+		return total
+	}
+
+	for _, sel := range set.Selections {
+		var c cost
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+			c = cost{depth: depth, complexity: 1}
+			if sel.SelectionSet != nil {
+				sub := a.selectionSet(sel.SelectionSet, depth+1)
+				c.depth = sub.depth
+				c.complexity += sub.complexity * a.multiplier(sel.Arguments)
+			}
+		case *ast.InlineFragment:
+			c = a.selectionSet(sel.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			fragment, ok := a.fragments[name]
+			// Cycles are rejected by validation later, don't follow them here
+			if !ok || a.visiting[name] {
+				continue
+			}
+			a.visiting[name] = true
+			c = a.selectionSet(fragment.SelectionSet, depth)
+			delete(a.visiting, name)
+		}
+
+		total.complexity += c.complexity
+		if c.depth > total.depth {
+			total.depth = c.depth
+		}
+	}
+	return total
+}
+
+// multiplier guesses how many items a field returns from its arguments
+func (a *analyzer) multiplier(args []*ast.Argument) int {
+	for _, arg := range args {
+		value := a.value(arg.Value)
+		if arg.Name.Value == "pageSize" {
+			if n, ok := value.(int); ok && n > 0 {
+				return n
+			}
+			return defaultListSize
+		}
+		if list, ok := value.([]interface{}); ok && len(list) > 0 {
+			return len(list)
+		}
+	}
+	return 1
+}
+
+// value returns the int or list behind a literal or variable, nil for anything else
+func (a *analyzer) value(v ast.Value) interface{} {
+	switch v := v.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(v.Value)
+		if err != nil {
+			return nil
+		}
+		return n
+	case *ast.ListValue:
+		return make([]interface{}, len(v.Values))
+	case *ast.Variable:
+		switch value := a.variables[v.Name.Value].(type) {
+		case float64:
+			return int(value)
+		case int:
+			return value
+		case []interface{}:
+			return value
+		}
+	}
+	return nil
+}
+
+// check reports the first limit c exceeds
+func (l Limits) check(c cost) error {
+	if l.MaxDepth > 0 && c.depth > l.MaxDepth {
+		return fmt.Errorf("query depth %d exceeds the limit of %d", c.depth, l.MaxDepth)
+	}
+	if l.MaxComplexity > 0 && c.complexity > l.MaxComplexity {
+		return fmt.Errorf("query complexity %d exceeds the limit of %d", c.complexity, l.MaxComplexity)
+	}
+	return nil
+}
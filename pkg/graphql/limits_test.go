@@ -0,0 +1,120 @@
+package graphql
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func analyzeQuery(t *testing.T, query, operationName string, variables map[string]interface{}) (cost, error) {
+	t.Helper()
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return analyze(doc, operationName, variables)
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		variables  map[string]interface{}
+		depth      int
+		complexity int
+	}{
+		{
+			name:       "single object",
+			query:      `{ user(id: "1") { id username } }`,
+			depth:      2,
+			complexity: 3,
+		},
+		{
+			name:       "page size multiplies the selection",
+			query:      `{ users(pageSize: 50) { id username } }`,
+			depth:      2,
+			complexity: 1 + 2*50,
+		},
+		{
+			name:       "page size from a variable",
+			query:      `query($n: Int) { users(pageSize: $n) { id } }`,
+			variables:  map[string]interface{}{"n": float64(20)},
+			depth:      2,
+			complexity: 1 + 20,
+		},
+		{
+			name:       "unknown page size",
+			query:      `query($n: Int) { users(pageSize: $n) { id } }`,
+			depth:      2,
+			complexity: 1 + defaultListSize,
+		},
+		{
+			name:       "list argument",
+			query:      `{ batchGetUsers(ids: ["1", "2", "3"]) { id } }`,
+			depth:      2,
+			complexity: 1 + 3,
+		},
+		{
+			name:       "fragments and nesting",
+			query:      `{ users(pageSize: 2) { ...f } } fragment f on User { id createdAt { seconds } }`,
+			depth:      3,
+			complexity: 1 + (1+2)*2,
+		},
+		{
+			name:       "introspection is free",
+			query:      `{ __schema { types { name } } user(id: "1") { id } }`,
+			depth:      2,
+			complexity: 2,
+		},
+		{
+			name:       "fragment cycles end",
+			query:      `{ user(id: "1") { ...a } } fragment a on User { id ...b } fragment b on User { ...a }`,
+			depth:      2,
+			complexity: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := analyzeQuery(t, tt.query, "", tt.variables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.depth != tt.depth || c.complexity != tt.complexity {
+				t.Fatalf("got depth %d complexity %d, want %d and %d", c.depth, c.complexity, tt.depth, tt.complexity)
+			}
+		})
+	}
+}
+
+func TestAnalyzeOperationName(t *testing.T) {
+	query := `query a { user(id: "1") { id } } query b { users(pageSize: 5) { id } }`
+	if _, err := analyzeQuery(t, query, "", nil); err == nil {
+		t.Fatal("expected an error without operationName")
+	}
+	if _, err := analyzeQuery(t, query, "c", nil); err == nil {
+		t.Fatal("expected an error for an unknown operation")
+	}
+	c, err := analyzeQuery(t, query, "b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.complexity != 6 {
+		t.Fatalf("got complexity %d, want the one of operation b", c.complexity)
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{MaxDepth: 3, MaxComplexity: 100}
+	if err := limits.check(cost{depth: 3, complexity: 100}); err != nil {
+		t.Fatalf("operation at the limits was rejected: %v", err)
+	}
+	if err := limits.check(cost{depth: 4, complexity: 1}); err == nil {
+		t.Fatal("expected the depth limit to reject")
+	}
+	if err := limits.check(cost{depth: 1, complexity: 101}); err == nil {
+		t.Fatal("expected the complexity limit to reject")
+	}
+	if err := (Limits{}).check(cost{depth: 50, complexity: 1e6}); err != nil {
+		t.Fatalf("zero limits must be disabled: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// maxBatchSize caps the requests sent in one Batcher call
+const maxBatchSize = 100
+
+// loader batches and caches RPC calls for the lifetime of one HTTP request. Resolvers
+// register their request and get a thunk back. The executor runs all resolvers of a
+// level before the thunks, so the first thunk sends every request registered so far.
+type loader struct {
+	mu      sync.Mutex
+	pending map[string]*batch
+	cache   map[string]*loadResult
+}
+
+type batch struct {
+	batcher    Batcher
+	reqs       []proto.Message
+	results    []*loadResult
+	dispatched bool
+}
+
+type loadResult struct {
+	batch *batch
+	resp  proto.Message
+	err   error
+}
+
+type loaderKey struct{}
+
+func newLoader() *loader {
+	return &loader{
+		pending: make(map[string]*batch),
+		cache:   make(map[string]*loadResult),
+	}
+}
+
+func contextWithLoader(ctx context.Context, l *loader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, l)
+}
+
+func loaderFromContext(ctx context.Context) *loader {
+	l, _ := ctx.Value(loaderKey{}).(*loader)
+	return l
+}
+
+// load queues req for method, identical requests share one result
+func (l *loader) load(ctx context.Context, method string, batcher Batcher, req proto.Message) func() (proto.Message, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return func() (proto.Message, error) { return nil, err }
+	}
+	key := method + "\x00" + string(data)
+
+	l.mu.Lock()
+	res, ok := l.cache[key]
+	if !ok {
+		b := l.pending[method]
+		if b == nil || len(b.reqs) >= maxBatchSize {
+			b = &batch{batcher: batcher}
+			l.pending[method] = b
+		}
+		res = &loadResult{batch: b}
+		b.reqs = append(b.reqs, req)
+		b.results = append(b.results, res)
+		l.cache[key] = res
+	}
+	l.mu.Unlock()
+
+	return func() (proto.Message, error) {
+		l.dispatch(ctx, method, res.batch)
+		return res.resp, res.err
+	}
+}
+
+// dispatch sends b unless that already happened
+func (l *loader) dispatch(ctx context.Context, method string, b *batch) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b.dispatched {
+		return
+	}
+	b.dispatched = true
+	if l.pending[method] == b {
+		delete(l.pending, method)
+	}
+
+	resps, errs := b.batcher(ctx, b.reqs)
+	for i, res := range b.results {
+		if i < len(resps) {
+			res.resp = resps[i]
+		}
+		if i < len(errs) {
+			res.err = errs[i]
+		}
+		if res.resp == nil && res.err == nil {
+			res.err = errMissingResult
+		}
+	}
+}
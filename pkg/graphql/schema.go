@@ -0,0 +1,398 @@
+package graphql
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Service exposes the unary RPCs of a proto service. Impl must have a method
+// func(context.Context, *Request) (*Response, error) for every RPC.
+type Service struct {
+	Descriptor protoreflect.ServiceDescriptor
+	Impl       interface{}
+	// Batchers resolve many calls of the named RPC at once, see Batcher
+	Batchers map[protoreflect.Name]Batcher
+}
+
+// Batcher resolves several requests to one RPC in a single upstream call.
+// It returns a response or an error for every request, in request order.
+type Batcher func(ctx context.Context, reqs []proto.Message) ([]proto.Message, []error)
+
+// Schema is a GraphQL schema generated from proto services
+type Schema struct {
+	schema graphql.Schema
+}
+
+// queryPrefixes mark the RPCs that become query fields, all other unary RPCs become mutations
+var queryPrefixes = []string{"Get", "List", "BatchGet", "Search"}
+
+// NewSchema generates a schema whose query and mutation fields are the unary RPCs of services.
+// Field names are the lower camel case RPC names, arguments are the request fields and
+// results the response messages, all using the proto JSON names.
+func NewSchema(services ...Service) (*Schema, error) {
+	b := &builder{
+		objects: make(map[protoreflect.FullName]*graphql.Object),
+		inputs:  make(map[protoreflect.FullName]*graphql.InputObject),
+		enums:   make(map[protoreflect.FullName]*graphql.Enum),
+	}
+
+	queries := graphql.Fields{}
+	mutations := graphql.Fields{}
+	for _, svc := range services {
+		methods := svc.Descriptor.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			md := methods.Get(i)
+			if md.IsStreamingClient() || md.IsStreamingServer() {
+				continue
+			}
+
+			call, err := bindMethod(svc.Impl, md)
+			if err != nil {
+				return nil, err
+			}
+			field := &graphql.Field{
+				Type:        b.object(md.Output()),
+				Args:        b.args(md.Input()),
+				Description: fmt.Sprintf("%s.%s", svc.Descriptor.Name(), md.Name()),
+				Resolve:     resolveRPC(md, call, svc.Batchers[md.Name()]),
+			}
+
+			name := lowerCamel(string(md.Name()))
+			if isQuery(md.Name()) {
+				queries[name] = field
+			} else {
+				mutations[name] = field
+			}
+		}
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no query RPCs to expose")
+	}
+
+	config := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries}),
+	}
+	if len(mutations) > 0 {
+		config.Mutation = graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations})
+	}
+
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build graphql schema: %v", err)
+	}
+	return &Schema{schema: schema}, nil
+}
+
+type rpcFunc func(ctx context.Context, req proto.Message) (proto.Message, error)
+
+// bindMethod finds the Go method implementing md on impl
+func bindMethod(impl interface{}, md protoreflect.MethodDescriptor) (rpcFunc, error) {
+	method := reflect.ValueOf(impl).MethodByName(string(md.Name()))
+	if !method.IsValid() {
+		return nil, fmt.Errorf("%T does not implement %s", impl, md.FullName())
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return nil, err
+	}
+
+	t := method.Type()
+	if t.NumIn() != 2 || t.In(1) != reflect.TypeOf(mt.Zero().Interface()) ||
+		t.NumOut() != 2 || !t.Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		return nil, fmt.Errorf("%T.%s is not a unary RPC method", impl, md.Name())
+	}
+	return func(ctx context.Context, req proto.Message) (proto.Message, error) {
+		out := method.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(req)})
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
+		}
+		resp, _ := out[0].Interface().(proto.Message)
+		return resp, nil
+	}, nil
+}
+
+// resolveRPC builds the request from the field arguments and calls the RPC, through the
+// request's loader when the RPC has a batcher
+func resolveRPC(md protoreflect.MethodDescriptor, call rpcFunc, batcher Batcher) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		req, err := newRequest(md.Input(), p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		if l := loaderFromContext(p.Context); l != nil && batcher != nil {
+			thunk := l.load(p.Context, string(md.FullName()), batcher, req)
+			return func() (interface{}, error) {
+				resp, err := thunk()
+				return reflectMessage(resp), rpcError(err)
+			}, nil
+		}
+
+		resp, err := call(p.Context, req)
+		return reflectMessage(resp), rpcError(err)
+	}
+}
+
+// newRequest converts GraphQL arguments into a request message. The arguments use the
+// proto JSON names and encodings, so they round-trip through protojson.
+func newRequest(desc protoreflect.MessageDescriptor, args map[string]interface{}) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName())
+	if err != nil {
+		return nil, err
+	}
+	req := mt.New().Interface()
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := protojson.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	return req, nil
+}
+
+func reflectMessage(msg proto.Message) interface{} {
+	if msg == nil || reflect.ValueOf(msg).IsNil() {
+		return nil
+	}
+	return msg.ProtoReflect()
+}
+
+// codedError carries the gRPC code of a failed RPC into the GraphQL error extensions
+type codedError struct {
+	st *status.Status
+}
+
+func (e *codedError) Error() string {
+	return e.st.Message()
+}
+
+func (e *codedError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.st.Code().String()}
+}
+
+func rpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{st: status.Convert(err)}
+}
+
+func isQuery(name protoreflect.Name) bool {
+	for _, prefix := range queryPrefixes {
+		if strings.HasPrefix(string(name), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerCamel(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// builder converts proto descriptors into GraphQL types, reusing types seen before
+type builder struct {
+	objects map[protoreflect.FullName]*graphql.Object
+	inputs  map[protoreflect.FullName]*graphql.InputObject
+	enums   map[protoreflect.FullName]*graphql.Enum
+}
+
+func (b *builder) object(md protoreflect.MessageDescriptor) *graphql.Object {
+	if obj, ok := b.objects[md.FullName()]; ok {
+		return obj
+	}
+
+	obj := graphql.NewObject(graphql.ObjectConfig{
+		Name: string(md.Name()),
+		// Fields are resolved lazily so messages can refer to each other
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			fields := graphql.Fields{}
+			for i := 0; i < md.Fields().Len(); i++ {
+				fd := md.Fields().Get(i)
+				fields[fd.JSONName()] = &graphql.Field{
+					Type:    b.outputType(fd),
+					Resolve: resolveField(fd),
+				}
+			}
+			return fields
+		}),
+	})
+	b.objects[md.FullName()] = obj
+	return obj
+}
+
+func (b *builder) input(md protoreflect.MessageDescriptor) *graphql.InputObject {
+	if in, ok := b.inputs[md.FullName()]; ok {
+		return in
+	}
+
+	in := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: string(md.Name()) + "Input",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			fields := graphql.InputObjectConfigFieldMap{}
+			for i := 0; i < md.Fields().Len(); i++ {
+				fd := md.Fields().Get(i)
+				fields[fd.JSONName()] = &graphql.InputObjectFieldConfig{Type: b.inputType(fd)}
+			}
+			return fields
+		}),
+	})
+	b.inputs[md.FullName()] = in
+	return in
+}
+
+func (b *builder) args(md protoreflect.MessageDescriptor) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{}
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		args[fd.JSONName()] = &graphql.ArgumentConfig{Type: b.inputType(fd)}
+	}
+	return args
+}
+
+func (b *builder) enum(ed protoreflect.EnumDescriptor) *graphql.Enum {
+	if e, ok := b.enums[ed.FullName()]; ok {
+		return e
+	}
+
+	values := graphql.EnumValueConfigMap{}
+	for i := 0; i < ed.Values().Len(); i++ {
+		name := string(ed.Values().Get(i).Name())
+		values[name] = &graphql.EnumValueConfig{Value: name}
+	}
+	e := graphql.NewEnum(graphql.EnumConfig{Name: string(ed.Name()), Values: values})
+	b.enums[ed.FullName()] = e
+	return e
+}
+
+func (b *builder) outputType(fd protoreflect.FieldDescriptor) graphql.Output {
+	var t graphql.Output
+	switch {
+	case fd.Kind() == protoreflect.EnumKind:
+		t = b.enum(fd.Enum())
+	case fd.Message() != nil && !isWellKnown(fd.Message()):
+		t = b.object(fd.Message())
+	default:
+		t = scalarType(fd)
+	}
+	if fd.IsList() {
+		return graphql.NewList(t)
+	}
+	return t
+}
+
+func (b *builder) inputType(fd protoreflect.FieldDescriptor) graphql.Input {
+	var t graphql.Input
+	switch {
+	case fd.Kind() == protoreflect.EnumKind:
+		t = b.enum(fd.Enum())
+	case fd.Message() != nil && !isWellKnown(fd.Message()):
+		t = b.input(fd.Message())
+	default:
+		t = scalarType(fd)
+	}
+	if fd.IsList() {
+		return graphql.NewList(t)
+	}
+	return t
+}
+
+// isWellKnown reports messages that have a string form in proto JSON
+func isWellKnown(md protoreflect.MessageDescriptor) bool {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp", "google.protobuf.FieldMask":
+		return true
+	}
+	return false
+}
+
+// scalarType maps proto scalars onto GraphQL ones. 64 bit integers and bytes are
+// strings as in proto JSON, since GraphQL Int is 32 bit.
+func scalarType(fd protoreflect.FieldDescriptor) *graphql.Scalar {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return graphql.Boolean
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return graphql.Int
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return graphql.Float
+	default:
+		return graphql.String
+	}
+}
+
+// resolveField reads fd from the proto message being resolved
+func resolveField(fd protoreflect.FieldDescriptor) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		m, ok := p.Source.(protoreflect.Message)
+		if !ok {
+			return nil, nil
+		}
+		// Unset messages and oneof members are null, scalars resolve to their defaults
+		if (fd.Message() != nil || fd.ContainingOneof() != nil) && !fd.IsList() && !m.Has(fd) {
+			return nil, nil
+		}
+
+		v := m.Get(fd)
+		if fd.IsList() {
+			list := v.List()
+			items := make([]interface{}, list.Len())
+			for i := range items {
+				items[i] = fieldValue(fd, list.Get(i))
+			}
+			return items, nil
+		}
+		return fieldValue(fd, v), nil
+	}
+}
+
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		switch msg := v.Message().Interface().(type) {
+		case *timestamppb.Timestamp:
+			return msg.AsTime().Format(time.RFC3339Nano)
+		case *fieldmaskpb.FieldMask:
+			return strings.Join(msg.Paths, ",")
+		}
+		return v.Message()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return int(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return int(v.Uint())
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(v.Uint(), 10)
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	default:
+		return v.Interface()
+	}
+}
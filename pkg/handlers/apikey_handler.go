@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kannan112/gateway-structure/pkg/apikey"
+	"go.uber.org/zap"
+)
+
+// APIKeyHandler exposes admin operations for API keys
+type APIKeyHandler struct {
+	keys   *apikey.Manager
+	logger *zap.Logger
+}
+
+// NewAPIKeyHandler creates a new instance of APIKeyHandler
+func NewAPIKeyHandler(keys *apikey.Manager, logger *zap.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		keys:   keys,
+		logger: logger,
+	}
+}
+
+type createKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Tier   string   `json:"tier"`
+	TTL    string   `json:"ttl"` // Go duration, empty for no expiry
+}
+
+type createKeyResponse struct {
+	Key    string      `json:"key"`
+	APIKey *apikey.Key `json:"api_key"`
+}
+
+// CreateKey issues a new API key. The raw key is only returned in this response.
+func (h *APIKeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "ttl must be a positive duration")
+			return
+		}
+		ttl = d
+	}
+	if req.Tier == "" {
+		req.Tier = "default"
+	}
+
+	raw, key, err := h.keys.Create(req.Name, req.Scopes, req.Tier, ttl)
+	if err != nil {
+		h.logger.Error("API key creation failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to create api key")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createKeyResponse{Key: raw, APIKey: key})
+}
+
+// ListKeys returns all API keys without their raw values
+func (h *APIKeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.List()
+	if err != nil {
+		h.logger.Error("List API keys failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to list api keys")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"api_keys": keys})
+}
+
+// RevokeKey revokes the API key identified by the {id} route variable
+func (h *APIKeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.keys.Revoke(id); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "api key not found")
+			return
+		}
+		h.logger.Error("API key revocation failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to revoke api key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
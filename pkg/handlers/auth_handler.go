@@ -1,19 +1,18 @@
 package handlers
 
 import (
-	"log"
-
 	"github.com/kannan112/gateway-structure/pkg/service"
+	"go.uber.org/zap"
 )
 
 // AuthHandler handles authentication related requests
 type AuthHandler struct {
 	authClient service.AuthService
-	logger     *log.Logger
+	logger     *zap.Logger
 }
 
 // NewAuthHandler creates a new instance of AuthHandler
-func NewAuthHandler(authClient service.AuthService, logger *log.Logger) *AuthHandler {
+func NewAuthHandler(authClient service.AuthService, logger *zap.Logger) *AuthHandler {
 	return &AuthHandler{
 		authClient: authClient,
 		logger:     logger,
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/revocation"
+	"go.uber.org/zap"
+)
+
+// RevocationHandler handles logout and admin token revocation requests
+type RevocationHandler struct {
+	store  revocation.Store
+	logger *zap.Logger
+}
+
+// NewRevocationHandler creates a new instance of RevocationHandler
+func NewRevocationHandler(store revocation.Store, logger *zap.Logger) *RevocationHandler {
+	return &RevocationHandler{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Logout revokes the token used to authenticate the request
+func (h *RevocationHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if claims.ID == "" {
+		writeError(w, http.StatusBadRequest, "token has no jti and can't be revoked individually")
+		return
+	}
+
+	expiresAt := time.Now().Add(revocation.MaxTokenLifetime)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	if err := h.store.RevokeToken(r.Context(), claims.ID, expiresAt); err != nil {
+		h.logger.Error("Logout failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type revokeTokenRequest struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RevokeToken denylists a single token by its jti
+func (h *RevocationHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req revokeTokenRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.JTI == "" {
+		writeError(w, http.StatusBadRequest, "jti is required")
+		return
+	}
+	if req.ExpiresAt.IsZero() {
+		req.ExpiresAt = time.Now().Add(revocation.MaxTokenLifetime)
+	}
+
+	if err := h.store.RevokeToken(r.Context(), req.JTI, req.ExpiresAt); err != nil {
+		h.logger.Error("Token revocation failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUser invalidates every token issued to the {id} user until now
+func (h *RevocationHandler) RevokeUser(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+
+	if err := h.store.RevokeUser(r.Context(), userID, time.Now()); err != nil {
+		h.logger.Error("User revocation failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to revoke user tokens")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment so proxies keep it open
+const sseKeepAlive = 15 * time.Second
+
+// sseStream adapts a server-sent events response to the WatchUsers server stream
+type sseStream struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	mu      sync.Mutex
+	started bool
+}
+
+func newSSEStream(ctx context.Context, w http.ResponseWriter) *sseStream {
+	return &sseStream{ctx: ctx, w: w, rc: http.NewResponseController(w)}
+}
+
+// start writes the response headers
+func (s *sseStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// keepAlive sends comment lines until ctx is done
+func (s *sseStream) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			s.start()
+			_, err := fmt.Fprint(s.w, ": keep-alive\n\n")
+			if err == nil {
+				s.rc.Flush()
+			}
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Send writes event with its resume token as the SSE id, so EventSource reconnects resume from it
+func (s *sseStream) Send(event *userpb.UserEvent) error {
+	data, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+	name := strings.ToLower(strings.TrimPrefix(event.Type.String(), "USER_EVENT_TYPE_"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	if event.ResumeToken != "" {
+		fmt.Fprintf(s.w, "id: %s\n", event.ResumeToken)
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// sendError reports a failure after the stream started, when the status code can no longer change
+func (s *sseStream) sendError(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "event: error\ndata: {\"error\":%q}\n\n", message)
+	s.rc.Flush()
+}
+
+// Started reports whether any part of the response has been written
+func (s *sseStream) Started() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+func (s *sseStream) Context() context.Context     { return s.ctx }
+func (s *sseStream) SetHeader(metadata.MD) error  { return nil }
+func (s *sseStream) SendHeader(metadata.MD) error { return nil }
+func (s *sseStream) SetTrailer(metadata.MD)       {}
+func (s *sseStream) SendMsg(m interface{}) error  { return s.Send(m.(*userpb.UserEvent)) }
+func (s *sseStream) RecvMsg(m interface{}) error  { return fmt.Errorf("sse stream is send only") }
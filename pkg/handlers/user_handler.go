@@ -2,40 +2,77 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"time"
 
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
-	"github.com/fluxor-api-gateway/pkg/service"
+	"github.com/kannan112/gateway-structure/pkg/audit"
+	"github.com/kannan112/gateway-structure/pkg/fieldmask"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	"github.com/kannan112/gateway-structure/pkg/redact"
+	"github.com/kannan112/gateway-structure/pkg/revocation"
+	"github.com/kannan112/gateway-structure/pkg/service"
+	"github.com/kannan112/gateway-structure/pkg/validation"
 
-	userpb "github.com/fluxor-api-gateway/pkg/proto/user"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
 )
 
-// UserHandler handles user-related requests
+// UserHandler handles user-related requests, it serves the gRPC UserService as well as REST and GraphQL
 type UserHandler struct {
-	userClient service.UserService
-	logger     *log.Logger
+	userpb.UnimplementedUserServiceServer
+	userClient  service.UserService
+	logger      *zap.Logger
+	revocations revocation.Store
+	audit       *audit.Log
+	validator   *validation.Validator
 }
 
 // NewUserHandler creates a new instance of UserHandler
-func NewUserHandler(userClient service.UserService, logger *log.Logger) *UserHandler {
+func NewUserHandler(userClient service.UserService, logger *zap.Logger) *UserHandler {
 	return &UserHandler{
 		userClient: userClient,
 		logger:     logger,
 	}
 }
 
+// SetRevocationStore makes UpdateUser revoke all tokens of users that get suspended
+func (h *UserHandler) SetRevocationStore(store revocation.Store) {
+	h.revocations = store
+}
+
+// SetAuditLog records the outcome of every mutating request in log
+func (h *UserHandler) SetAuditLog(log *audit.Log) {
+	h.audit = log
+}
+
+// SetValidator checks every request against v's rules before it reaches the user service
+func (h *UserHandler) SetValidator(v *validation.Validator) {
+	h.validator = v
+}
+
+// validate checks req when a validator is set, the gRPC server validates in its interceptors
+func (h *UserHandler) validate(req proto.Message) error {
+	if h.validator == nil {
+		return nil
+	}
+	return h.validator.Validate(req)
+}
+
 // CreateUser handles user creation requests
-func (h *UserHandler) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
-	if err := validateCreateUserRequest(req); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+func (h *UserHandler) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (response *userpb.CreateUserResponse, err error) {
+	defer func() { middleware.RecordAudit(ctx, h.audit, "CreateUser", req, response, err) }()
+
+	if err := h.validate(req); err != nil {
+		return nil, err
 	}
 
-	response, err := h.userClient.CreateUser(ctx, req)
+	response, err = h.userClient.CreateUser(ctx, req)
 	if err != nil {
-		h.logger.Printf("User creation failed: %v", err)
-		return nil, status.Error(codes.Internal, "failed to create user")
+		h.logger.Error("User creation failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to create user")
 	}
 
 	return response, nil
@@ -43,44 +80,58 @@ func (h *UserHandler) CreateUser(ctx context.Context, req *userpb.CreateUserRequ
 
 // GetUser retrieves user information
 func (h *UserHandler) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
-	if err := validateGetUserRequest(req); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+	if err := h.validate(req); err != nil {
+		return nil, err
 	}
 
 	response, err := h.userClient.GetUser(ctx, req)
 	if err != nil {
-		h.logger.Printf("Get user failed: %v", err)
-		return nil, status.Error(codes.Internal, "failed to get user")
+		h.logger.Error("Get user failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to get user")
 	}
 
 	return response, nil
 }
 
 // UpdateUser handles user update requests
-func (h *UserHandler) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
-	if err := validateUpdateUserRequest(req); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+func (h *UserHandler) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (response *userpb.UpdateUserResponse, err error) {
+	defer func() { middleware.RecordAudit(ctx, h.audit, "UpdateUser", req, response, err) }()
+
+	if err := h.validate(req); err != nil {
+		return nil, err
+	}
+	if err := fieldmask.DefaultPolicy.Check(middleware.RoleFromContext(ctx), req); err != nil {
+		return nil, err
 	}
 
-	response, err := h.userClient.UpdateUser(ctx, req)
+	response, err = h.userClient.UpdateUser(ctx, req)
 	if err != nil {
-		h.logger.Printf("User update failed: %v", err)
-		return nil, status.Error(codes.Internal, "failed to update user")
+		h.logger.Error("User update failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to update user")
+	}
+
+	// Suspended users must not keep using tokens issued before the suspension
+	if h.revocations != nil && response.User != nil && response.User.Status == userpb.UserStatus_USER_STATUS_SUSPENDED {
+		if err := h.revocations.RevokeUser(ctx, response.User.Id, time.Now()); err != nil {
+			h.logger.Error("Revoking tokens of suspended user failed", zap.String("user_id", response.User.Id), redact.Error(err))
+		}
 	}
 
 	return response, nil
 }
 
 // DeleteUser handles user deletion requests
-func (h *UserHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
-	if err := validateDeleteUserRequest(req); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+func (h *UserHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (response *userpb.DeleteUserResponse, err error) {
+	defer func() { middleware.RecordAudit(ctx, h.audit, "DeleteUser", req, response, err) }()
+
+	if err := h.validate(req); err != nil {
+		return nil, err
 	}
 
-	response, err := h.userClient.DeleteUser(ctx, req)
+	response, err = h.userClient.DeleteUser(ctx, req)
 	if err != nil {
-		h.logger.Printf("User deletion failed: %v", err)
-		return nil, status.Error(codes.Internal, "failed to delete user")
+		h.logger.Error("User deletion failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to delete user")
 	}
 
 	return response, nil
@@ -88,57 +139,91 @@ func (h *UserHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequ
 
 // ListUsers retrieves a list of users with pagination
 func (h *UserHandler) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
-	if err := validateListUsersRequest(req); err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+	if err := h.validate(req); err != nil {
+		return nil, err
 	}
 
 	response, err := h.userClient.ListUsers(ctx, req)
 	if err != nil {
-		h.logger.Printf("List users failed: %v", err)
-		return nil, status.Error(codes.Internal, "failed to list users")
+		h.logger.Error("List users failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to list users")
 	}
 
 	return response, nil
 }
 
-// Helper functions for request validation
-func validateCreateUserRequest(req *userpb.CreateUserRequest) error {
-	if req.User == nil {
-		return status.Error(codes.InvalidArgument, "user information is required")
+// BatchGetUsers retrieves several users, failures are reported per user
+func (h *UserHandler) BatchGetUsers(ctx context.Context, req *userpb.BatchGetUsersRequest) (*userpb.BatchGetUsersResponse, error) {
+	if err := h.validate(req); err != nil {
+		return nil, err
 	}
-	if req.User.Email == "" || req.User.Username == "" {
-		return status.Error(codes.InvalidArgument, "email and username are required")
+
+	response, err := h.userClient.BatchGetUsers(ctx, req)
+	if err != nil {
+		h.logger.Error("Batch get users failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to get users")
 	}
-	return nil
+
+	return response, nil
 }
 
-func validateGetUserRequest(req *userpb.GetUserRequest) error {
-	if req.UserId == "" {
-		return status.Error(codes.InvalidArgument, "user ID is required")
+// BatchUpdateUserStatus sets the status of several users, failures are reported per user
+func (h *UserHandler) BatchUpdateUserStatus(ctx context.Context, req *userpb.BatchUpdateUserStatusRequest) (response *userpb.BatchUpdateUserStatusResponse, err error) {
+	defer func() { middleware.RecordAudit(ctx, h.audit, "BatchUpdateUserStatus", req, response, err) }()
+
+	if err := h.validate(req); err != nil {
+		return nil, err
+	}
+	if role := middleware.RoleFromContext(ctx); role != "admin" && role != middleware.RoleService {
+		return nil, status.Error(codes.PermissionDenied, "insufficient permissions")
 	}
-	return nil
-}
 
-func validateUpdateUserRequest(req *userpb.UpdateUserRequest) error {
-	if req.User == nil {
-		return status.Error(codes.InvalidArgument, "user information is required")
+	response, err = h.userClient.BatchUpdateUserStatus(ctx, req)
+	if err != nil {
+		h.logger.Error("Batch status update failed", redact.Proto("request", req), redact.Error(err))
+		return nil, upstreamError(err, "failed to update users")
 	}
-	if req.User.Id == "" {
-		return status.Error(codes.InvalidArgument, "user ID is required")
+
+	if h.revocations != nil && req.Status == userpb.UserStatus_USER_STATUS_SUSPENDED {
+		for _, result := range response.Results {
+			if result.GetError() != nil {
+				continue
+			}
+			if err := h.revocations.RevokeUser(ctx, result.UserId, time.Now()); err != nil {
+				h.logger.Error("Revoking tokens of suspended user failed", zap.String("user_id", result.UserId), redact.Error(err))
+			}
+		}
 	}
-	return nil
+
+	return response, nil
 }
 
-func validateDeleteUserRequest(req *userpb.DeleteUserRequest) error {
-	if req.UserId == "" {
-		return status.Error(codes.InvalidArgument, "user ID is required")
+// WatchUsers relays user change events to stream
+func (h *UserHandler) WatchUsers(req *userpb.WatchUsersRequest, stream userpb.UserService_WatchUsersServer) error {
+	if err := h.validate(req); err != nil {
+		return err
 	}
+
+	if err := h.userClient.WatchUsers(req, stream); err != nil {
+		// Clients closing the stream is the normal way for a watch to end
+		if stream.Context().Err() != nil {
+			return nil
+		}
+		h.logger.Error("Watch users failed", redact.Proto("request", req), redact.Error(err))
+		return upstreamError(err, "failed to watch users")
+	}
+
 	return nil
 }
 
-func validateListUsersRequest(req *userpb.ListUsersRequest) error {
-	if req.PageSize < 0 {
-		return status.Error(codes.InvalidArgument, "page size must be non-negative")
+// upstreamError hides the upstream's error message but keeps the codes callers act on: overload
+// and timeouts so they back off and retry (503/504 over REST), and errors in their own request
+func upstreamError(err error, msg string) error {
+	switch code := status.Code(err); code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded,
+		codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.FailedPrecondition, codes.Aborted, codes.OutOfRange, codes.Unauthenticated:
+		return status.Error(code, msg)
 	}
-	return nil
+	return status.Error(codes.Internal, msg)
 }
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+	"github.com/kannan112/gateway-structure/pkg/revocation"
+	"github.com/kannan112/gateway-structure/pkg/validation"
+)
+
+// stubUsers answers UpdateUser with the submitted user
+type stubUsers struct {
+	userpb.UnimplementedUserServiceServer
+}
+
+func (stubUsers) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+	return &userpb.UpdateUserResponse{User: req.User}, nil
+}
+
+func (stubUsers) Name() string              { return "user" }
+func (stubUsers) Address() string           { return "stub" }
+func (stubUsers) State() connectivity.State { return connectivity.Ready }
+func (stubUsers) SetDraining(bool)          {}
+func (stubUsers) Draining() bool            { return false }
+func (stubUsers) Close() error              { return nil }
+
+func TestUpdateUserRevokesSuspendedUser(t *testing.T) {
+	store := revocation.NewMemoryStore(time.Minute)
+	h := NewUserHandler(stubUsers{}, zap.NewNop())
+	h.SetRevocationStore(store)
+
+	issued := time.Now().Add(-time.Minute)
+	ctx := middleware.ContextWithClaims(context.Background(), &middleware.Claims{UserID: "admin-1", Role: "admin"})
+	_, err := h.UpdateUser(ctx, &userpb.UpdateUserRequest{
+		User: &userpb.User{Id: "u1", Status: userpb.UserStatus_USER_STATUS_SUSPENDED},
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	revoked, err := store.IsRevoked(ctx, "jti-1", "u1", issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("tokens of the suspended user were not revoked")
+	}
+	if revoked, _ := store.IsRevoked(ctx, "jti-2", "admin-1", issued); revoked {
+		t.Fatal("tokens of another user were revoked")
+	}
+}
+
+func TestFailedCallLogsRedactedRequest(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	h := NewUserHandler(stubUsers{}, zap.New(core))
+
+	_, err := h.CreateUser(context.Background(), &userpb.CreateUserRequest{
+		User:     &userpb.User{Username: "jdoe", Email: "jdoe@example.com", FirstName: "John"},
+		Password: "Hunter22!pass",
+	})
+	if err == nil {
+		t.Fatal("expected the upstream error")
+	}
+
+	entries := logs.FilterMessage("User creation failed").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d log entries, want 1", len(entries))
+	}
+	request, ok := entries[0].ContextMap()["request"]
+	if !ok {
+		t.Fatal("the request was not logged")
+	}
+	logged, _ := json.Marshal(request)
+	for _, secret := range []string{"jdoe@example.com", "Hunter22!pass", "John"} {
+		if strings.Contains(string(logged), secret) {
+			t.Errorf("logged request %s contains %q", logged, secret)
+		}
+	}
+	if !strings.Contains(string(logged), "jdoe") {
+		t.Errorf("logged request %s lost the username", logged)
+	}
+}
+
+func TestUpstreamErrorKeepsActionableCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+		http int
+	}{
+		{status.Error(codes.Unavailable, "user is overloaded"), codes.Unavailable, http.StatusServiceUnavailable},
+		{status.Error(codes.DeadlineExceeded, "timeout"), codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{status.Error(codes.NotFound, "no user 42"), codes.NotFound, http.StatusNotFound},
+		{status.Error(codes.Internal, "db: connection refused"), codes.Internal, http.StatusInternalServerError},
+		{errors.New("plain error"), codes.Internal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		st := status.Convert(upstreamError(tt.err, "failed to get user"))
+		if st.Code() != tt.code {
+			t.Errorf("%v: code = %s, want %s", tt.err, st.Code(), tt.code)
+		}
+		if st.Message() != "failed to get user" {
+			t.Errorf("%v: upstream message leaked: %q", tt.err, st.Message())
+		}
+		if got := httpStatus(st.Code()); got != tt.http {
+			t.Errorf("%v: HTTP status = %d, want %d", tt.err, got, tt.http)
+		}
+	}
+}
+
+func TestRESTReportsFieldViolations(t *testing.T) {
+	users := NewUserHandler(stubUsers{}, zap.NewNop())
+	users.SetValidator(validation.Default())
+	rest := NewUserRESTHandler(users)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(`{"user": {"username": "jdoe", "email": "not-an-email"}, "password": "Hunter22!pass"}`))
+	w := httptest.NewRecorder()
+	rest.CreateUser(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want 400", w.Code)
+	}
+
+	var body struct {
+		Error      string           `json:"error"`
+		Violations []fieldViolation `json:"field_violations"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Violations) != 1 || body.Violations[0].Field != "user.email" {
+		t.Fatalf("unexpected violations %+v", body.Violations)
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kannan112/gateway-structure/pkg/fieldmask"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// UserRESTHandler maps the REST surface of the gateway onto UserHandler
+type UserRESTHandler struct {
+	users *UserHandler
+}
+
+// NewUserRESTHandler creates a new instance of UserRESTHandler
+func NewUserRESTHandler(users *UserHandler) *UserRESTHandler {
+	return &UserRESTHandler{users: users}
+}
+
+// CreateUser handles POST /users with a CreateUserRequest body
+func (h *UserRESTHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	req := &userpb.CreateUserRequest{}
+	if !decodeProto(w, r, req) {
+		return
+	}
+
+	resp, err := h.users.CreateUser(r.Context(), req)
+	writeProto(w, http.StatusCreated, resp, err)
+}
+
+// GetUser handles GET /users/{id}
+func (h *UserRESTHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	req := &userpb.GetUserRequest{UserId: mux.Vars(r)["id"]}
+
+	resp, err := h.users.GetUser(r.Context(), req)
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// UpdateUser handles PUT /users/{id} with an UpdateUserRequest body, the path ID wins over the body
+func (h *UserRESTHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	req := &userpb.UpdateUserRequest{}
+	if !decodeProto(w, r, req) {
+		return
+	}
+	if req.User == nil {
+		req.User = &userpb.User{}
+	}
+	req.User.Id = mux.Vars(r)["id"]
+
+	resp, err := h.users.UpdateUser(r.Context(), req)
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// PatchUser handles PATCH /users/{id} with a partial User body, the update mask is derived from the body keys
+func (h *UserRESTHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	user := &userpb.User{}
+	if err := protojson.Unmarshal(body, user); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	mask, err := fieldmask.FromJSON(body, user.ProtoReflect().Descriptor())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(mask.Paths) == 0 {
+		writeError(w, http.StatusBadRequest, "no fields to update")
+		return
+	}
+	user.Id = mux.Vars(r)["id"]
+
+	resp, err := h.users.UpdateUser(r.Context(), &userpb.UpdateUserRequest{User: user, UpdateMask: mask})
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// DeleteUser handles DELETE /users/{id}
+func (h *UserRESTHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	req := &userpb.DeleteUserRequest{UserId: mux.Vars(r)["id"]}
+
+	resp, err := h.users.DeleteUser(r.Context(), req)
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// ListUsers handles GET /users?page_size=&page_token=&status=&search=
+func (h *UserRESTHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &userpb.ListUsersRequest{PageToken: query.Get("page_token")}
+
+	if v := query.Get("page_size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "page_size must be a number")
+			return
+		}
+		req.PageSize = int32(size)
+	}
+	if v := query.Get("status"); v != "" {
+		st, ok := userpb.UserStatus_value[v]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unknown status "+v)
+			return
+		}
+		req.Status = userpb.UserStatus(st).Enum()
+	}
+	if v := query.Get("search"); v != "" {
+		req.Search = proto.String(v)
+	}
+
+	resp, err := h.users.ListUsers(r.Context(), req)
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// BatchGetUsers handles GET /users/batchGet?user_ids=&user_ids=
+func (h *UserRESTHandler) BatchGetUsers(w http.ResponseWriter, r *http.Request) {
+	req := &userpb.BatchGetUsersRequest{UserIds: r.URL.Query()["user_ids"]}
+
+	resp, err := h.users.BatchGetUsers(r.Context(), req)
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// BatchUpdateUserStatus handles POST /users/batchUpdateStatus with a BatchUpdateUserStatusRequest body
+func (h *UserRESTHandler) BatchUpdateUserStatus(w http.ResponseWriter, r *http.Request) {
+	req := &userpb.BatchUpdateUserStatusRequest{}
+	if !decodeProto(w, r, req) {
+		return
+	}
+
+	resp, err := h.users.BatchUpdateUserStatus(r.Context(), req)
+	writeProto(w, http.StatusOK, resp, err)
+}
+
+// WatchUsers handles GET /users/watch?status=&resume_token= as server-sent events.
+// A reconnecting EventSource sends Last-Event-ID, which takes precedence over resume_token.
+func (h *UserRESTHandler) WatchUsers(w http.ResponseWriter, r *http.Request) {
+	// The server write timeout would cut the stream, even while waiting for the first event
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	query := r.URL.Query()
+	req := &userpb.WatchUsersRequest{ResumeToken: query.Get("resume_token")}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		req.ResumeToken = id
+	}
+	for _, v := range query["status"] {
+		st, ok := userpb.UserStatus_value[v]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unknown status "+v)
+			return
+		}
+		req.Statuses = append(req.Statuses, userpb.UserStatus(st))
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	stream := newSSEStream(ctx, w)
+	done := make(chan struct{})
+	go func() {
+		stream.keepAlive(ctx)
+		close(done)
+	}()
+
+	err := h.users.WatchUsers(req, stream)
+	// Stop the keep-alives before touching the response again
+	cancel()
+	<-done
+
+	if err != nil {
+		st := status.Convert(err)
+		if !stream.Started() {
+			writeStatus(w, st)
+			return
+		}
+		stream.sendError(st.Message())
+	}
+}
+
+// decodeProto decodes a protojson request body and writes the error response on failure
+func decodeProto(w http.ResponseWriter, r *http.Request, msg proto.Message) bool {
+	body, ok := readBody(w, r)
+	if !ok {
+		return false
+	}
+
+	if err := protojson.Unmarshal(body, msg); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return false
+	}
+	return true
+}
+
+// readBody reads the whole request body and writes the error response on failure
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return nil, false
+		}
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return nil, false
+	}
+	return body, true
+}
+
+// writeProto writes msg as protojson, or maps err onto the matching HTTP status
+func writeProto(w http.ResponseWriter, code int, msg proto.Message, err error) {
+	if err != nil {
+		writeStatus(w, status.Convert(err))
+		return
+	}
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+type fieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// writeStatus writes st as a JSON error, listing the field violations of invalid requests
+func writeStatus(w http.ResponseWriter, st *status.Status) {
+	var violations []fieldViolation
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, fv := range badRequest.FieldViolations {
+				violations = append(violations, fieldViolation{Field: fv.Field, Description: fv.Description})
+			}
+		}
+	}
+	if len(violations) == 0 {
+		writeError(w, httpStatus(st.Code()), st.Message())
+		return
+	}
+
+	writeJSON(w, httpStatus(st.Code()), struct {
+		Error      string           `json:"error"`
+		Violations []fieldViolation `json:"field_violations"`
+	}{Error: st.Message(), Violations: violations})
+}
+
+// httpStatus maps gRPC codes onto HTTP status codes as grpc-gateway does
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
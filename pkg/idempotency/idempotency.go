@@ -0,0 +1,129 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned while the first request with a key is still running
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrMismatch is returned when a key is reused with a different payload
+	ErrMismatch = errors.New("idempotency key reused with a different payload")
+)
+
+// Record is the stored outcome of the first request made with a key
+type Record struct {
+	Fingerprint string
+	Completed   bool
+	Status      int // HTTP status, unused for gRPC
+	Header      map[string][]string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Store persists idempotency records
+type Store interface {
+	// Reserve claims key for a new request. If the key was already used with the same
+	// fingerprint the completed record is returned, otherwise ErrInProgress or ErrMismatch.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*Record, error)
+
+	// Complete stores the response of the request that reserved key
+	Complete(ctx context.Context, key string, record *Record) error
+
+	// Release drops a reservation so the request can be retried, e.g. after a failure
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps idempotency records in process memory
+type MemoryStore struct {
+	records map[string]*Record
+	mu      sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemoryStore creates a new MemoryStore that drops expired records every cleanupInterval
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		records: make(map[string]*Record),
+		stop:    make(chan struct{}),
+	}
+	go s.cleanup(cleanupInterval)
+	return s
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if record, ok := s.records[key]; ok && now.Before(record.ExpiresAt) {
+		if record.Fingerprint != fingerprint {
+			return nil, ErrMismatch
+		}
+		if !record.Completed {
+			return nil, ErrInProgress
+		}
+		r := *record
+		return &r, nil
+	}
+
+	s.records[key] = &Record{Fingerprint: fingerprint, ExpiresAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.records[key]
+	if !ok {
+		return nil
+	}
+	r := *record
+	r.Completed = true
+	r.Fingerprint = existing.Fingerprint
+	r.ExpiresAt = existing.ExpiresAt
+	s.records[key] = &r
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[key]; ok && !record.Completed {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// Close stops the cleanup goroutine
+func (s *MemoryStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, record := range s.records {
+				if now.After(record.ExpiresAt) {
+					delete(s.records, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
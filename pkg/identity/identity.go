@@ -0,0 +1,109 @@
+package identity
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+)
+
+// Metadata keys attached to outgoing upstream calls
+const (
+	UserIDKey = middleware.IdentityHeaderPrefix + "user-id"
+	RoleKey   = middleware.IdentityHeaderPrefix + "role"
+	ScopesKey = middleware.IdentityHeaderPrefix + "scopes"
+	TokenKey  = middleware.IdentityHeaderPrefix + "identity"
+)
+
+// Audience is set on internal tokens so upstreams can tell them apart from client tokens
+const Audience = "gateway-internal"
+
+// Signer issues short lived internal tokens that upstreams can verify instead of re-validating client tokens
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSigner creates a new Signer, tokens are valid for ttl (30 seconds if zero)
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	if ttl == 0 {
+		ttl = 30 * time.Second
+	}
+	return &Signer{secret: secret, ttl: ttl}
+}
+
+// Sign returns an HS256 token carrying the verified claims
+func (s *Signer) Sign(claims *middleware.Claims) (string, error) {
+	now := time.Now()
+	internal := &middleware.Claims{
+		UserID: claims.UserID,
+		Role:   claims.Role,
+		Scopes: claims.Scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "gateway",
+			Subject:   claims.Subject,
+			Audience:  jwt.ClaimStrings{Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, internal).SignedString(s.secret)
+}
+
+// UnaryClientInterceptor attaches the caller's verified identity to outgoing calls.
+// A nil signer only attaches the plain metadata keys.
+func UnaryClientInterceptor(signer *Signer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := outgoingContext(ctx, signer)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is the streaming counterpart of UnaryClientInterceptor
+func StreamClientInterceptor(signer *Signer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := outgoingContext(ctx, signer)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func outgoingContext(ctx context.Context, signer *Signer) (context.Context, error) {
+	// Never forward identity keys that didn't come from the gateway itself
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for key := range md {
+		if strings.HasPrefix(key, middleware.IdentityHeaderPrefix) {
+			delete(md, key)
+		}
+	}
+
+	claims, ok := middleware.ClaimsFromContext(ctx)
+	if ok {
+		md.Set(UserIDKey, claims.UserID)
+		md.Set(RoleKey, claims.Role)
+		if len(claims.Scopes) > 0 {
+			md.Set(ScopesKey, strings.Join(claims.Scopes, " "))
+		}
+
+		if signer != nil {
+			token, err := signer.Sign(claims)
+			if err != nil {
+				return nil, err
+			}
+			md.Set(TokenKey, token)
+		}
+	}
+
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
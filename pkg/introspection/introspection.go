@@ -0,0 +1,255 @@
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
+)
+
+// tlsReloadInterval is how often the endpoint's certificate files are checked for changes
+const tlsReloadInterval = 30 * time.Second
+
+var ErrInactive = errors.New("token is not active")
+
+// Response is an RFC 7662 token introspection response
+type Response struct {
+	Active    bool            `json:"active"`
+	Scope     string          `json:"scope,omitempty"`
+	ClientID  string          `json:"client_id,omitempty"`
+	Username  string          `json:"username,omitempty"`
+	TokenType string          `json:"token_type,omitempty"`
+	Exp       int64           `json:"exp,omitempty"`
+	Iat       int64           `json:"iat,omitempty"`
+	Nbf       int64           `json:"nbf,omitempty"`
+	Sub       string          `json:"sub,omitempty"`
+	Aud       json.RawMessage `json:"aud,omitempty"` // string or array of strings
+	Iss       string          `json:"iss,omitempty"`
+	Jti       string          `json:"jti,omitempty"`
+
+	// Non-standard members commonly added by identity providers
+	UserID string `json:"user_id,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+// Scopes splits the space separated scope member
+func (r *Response) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+// Audience returns the aud member as a list
+func (r *Response) Audience() []string {
+	if len(r.Aud) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(r.Aud, &single); err == nil {
+		return []string{single}
+	}
+	var many []string
+	json.Unmarshal(r.Aud, &many)
+	return many
+}
+
+// Config holds configuration for the introspection client
+type Config struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	Timeout      time.Duration
+	CacheTTL     time.Duration // upper bound, entries never outlive the token's exp
+	CacheSize    int
+	HTTPClient   *http.Client // optional, mainly for tests
+	// TLS verifies the endpoint against a private CA and presents a client certificate, ignored with HTTPClient
+	TLS tlsutil.Config
+}
+
+type cacheEntry struct {
+	resp    *Response
+	expires time.Time
+}
+
+// Introspector verifies opaque tokens against an RFC 7662 endpoint
+type Introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	ttl          time.Duration
+	size         int
+	tls          *tlsutil.Reloader
+
+	cache map[[32]byte]cacheEntry
+	mu    sync.Mutex
+}
+
+// New creates a new Introspector
+func New(config Config) (*Introspector, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("introspection endpoint cannot be empty")
+	}
+
+	// Set defaults
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = time.Minute
+	}
+	if config.CacheSize == 0 {
+		config.CacheSize = 10000
+	}
+	client := config.HTTPClient
+	var reloader *tlsutil.Reloader
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+		if config.TLS.Enabled() {
+			var err error
+			reloader, err = tlsutil.NewReloader(config.TLS, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load introspection TLS files: %v", err)
+			}
+			transport, err := reloader.HTTPTransport()
+			if err != nil {
+				reloader.Stop()
+				return nil, fmt.Errorf("invalid introspection TLS config: %v", err)
+			}
+			reloader.Watch(tlsReloadInterval)
+			client.Transport = transport
+		}
+	}
+
+	return &Introspector{
+		endpoint:     config.Endpoint,
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		client:       client,
+		ttl:          config.CacheTTL,
+		size:         config.CacheSize,
+		tls:          reloader,
+		cache:        make(map[[32]byte]cacheEntry),
+	}, nil
+}
+
+// Introspect returns the introspection result for an active token, or ErrInactive
+func (i *Introspector) Introspect(ctx context.Context, token string) (*Response, error) {
+	// Tokens are cached by hash so raw credentials are never held in memory longer than needed
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	if resp, ok := i.lookup(key, now); ok {
+		if !resp.Active {
+			return nil, ErrInactive
+		}
+		return resp, nil
+	}
+
+	resp, err := i.fetch(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	i.store(key, resp, now)
+	if !resp.Active {
+		return nil, ErrInactive
+	}
+	return resp, nil
+}
+
+// Close stops watching the endpoint's certificate files
+func (i *Introspector) Close() {
+	if i.tls != nil {
+		i.tls.Stop()
+	}
+}
+
+func (i *Introspector) fetch(ctx context.Context, token string) (*Response, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+
+	res, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %s", res.Status)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %v", err)
+	}
+	return &resp, nil
+}
+
+func (i *Introspector) lookup(key [32]byte, now time.Time) (*Response, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, ok := i.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(entry.expires) {
+		delete(i.cache, key)
+		return nil, false
+	}
+	return entry.resp, true
+}
+
+func (i *Introspector) store(key [32]byte, resp *Response, now time.Time) {
+	expires := now.Add(i.ttl)
+	if resp.Exp > 0 {
+		if exp := time.Unix(resp.Exp, 0); exp.Before(expires) {
+			expires = exp
+		}
+	}
+	if !expires.After(now) {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.cache) >= i.size {
+		i.evict(now)
+	}
+	i.cache[key] = cacheEntry{resp: resp, expires: expires}
+}
+
+// evict drops expired entries, and if the cache is still full an arbitrary half of it
+func (i *Introspector) evict(now time.Time) {
+	for k, e := range i.cache {
+		if now.After(e.expires) {
+			delete(i.cache, k)
+		}
+	}
+	for k := range i.cache {
+		if len(i.cache) < i.size/2 {
+			break
+		}
+		delete(i.cache, k)
+	}
+}
@@ -0,0 +1,145 @@
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// endpoint serves introspection responses, tokens starting with "active" are active
+type endpoint struct {
+	*httptest.Server
+	calls atomic.Int32
+	exp   int64
+}
+
+func newEndpoint(t *testing.T) *endpoint {
+	t.Helper()
+	e := &endpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.calls.Add(1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "gateway" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := r.PostFormValue("token")
+		resp := Response{Active: len(token) >= 6 && token[:6] == "active"}
+		if resp.Active {
+			resp.Sub = token
+			resp.Scope = "users admin"
+			resp.Exp = e.exp
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func newIntrospector(t *testing.T, e *endpoint, ttl time.Duration, size int) *Introspector {
+	t.Helper()
+	i, err := New(Config{
+		Endpoint:     e.URL,
+		ClientID:     "gateway",
+		ClientSecret: "s3cret",
+		CacheTTL:     ttl,
+		CacheSize:    size,
+		HTTPClient:   e.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestIntrospectCachesResponses(t *testing.T) {
+	e := newEndpoint(t)
+	i := newIntrospector(t, e, time.Minute, 100)
+	ctx := context.Background()
+
+	for n := 0; n < 3; n++ {
+		resp, err := i.Introspect(ctx, "active-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Sub != "active-1" || len(resp.Scopes()) != 2 {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+	}
+	for n := 0; n < 2; n++ {
+		if _, err := i.Introspect(ctx, "revoked"); !errors.Is(err, ErrInactive) {
+			t.Fatalf("expected ErrInactive, got %v", err)
+		}
+	}
+	if got := e.calls.Load(); got != 2 {
+		t.Fatalf("endpoint called %d times, want one call per token", got)
+	}
+}
+
+func TestIntrospectCacheTTL(t *testing.T) {
+	e := newEndpoint(t)
+	i := newIntrospector(t, e, 50*time.Millisecond, 100)
+	ctx := context.Background()
+
+	i.Introspect(ctx, "active-1")
+	time.Sleep(100 * time.Millisecond)
+	if _, err := i.Introspect(ctx, "active-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.calls.Load(); got != 2 {
+		t.Fatalf("endpoint called %d times, the expired entry was served", got)
+	}
+}
+
+func TestIntrospectCacheNeverOutlivesToken(t *testing.T) {
+	e := newEndpoint(t)
+	// The token expires long before the cache TTL
+	e.exp = time.Now().Add(-time.Second).Unix()
+	i := newIntrospector(t, e, time.Hour, 100)
+	ctx := context.Background()
+
+	i.Introspect(ctx, "active-1")
+	i.Introspect(ctx, "active-1")
+	if got := e.calls.Load(); got != 2 {
+		t.Fatalf("endpoint called %d times, an expired token was cached", got)
+	}
+}
+
+func TestIntrospectCacheEviction(t *testing.T) {
+	e := newEndpoint(t)
+	i := newIntrospector(t, e, time.Minute, 4)
+	ctx := context.Background()
+
+	for n := 0; n < 10; n++ {
+		if _, err := i.Introspect(ctx, fmt.Sprintf("active-%d", n)); err != nil {
+			t.Fatal(err)
+		}
+		i.mu.Lock()
+		size := len(i.cache)
+		i.mu.Unlock()
+		if size > 4 {
+			t.Fatalf("cache holds %d entries, limit is 4", size)
+		}
+	}
+}
+
+func TestIntrospectEndpointErrors(t *testing.T) {
+	e := newEndpoint(t)
+	i, err := New(Config{Endpoint: e.URL, ClientID: "gateway", ClientSecret: "wrong", HTTPClient: e.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.Introspect(context.Background(), "active-1"); err == nil || errors.Is(err, ErrInactive) {
+		t.Fatalf("expected an endpoint error, got %v", err)
+	}
+	// Failures are not cached
+	i.Introspect(context.Background(), "active-1")
+	if got := e.calls.Load(); got != 2 {
+		t.Fatalf("endpoint called %d times, want 2", got)
+	}
+}
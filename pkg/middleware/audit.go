@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"path"
+
+	"github.com/kannan112/gateway-structure/pkg/audit"
+	"github.com/kannan112/gateway-structure/pkg/redact"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// RecordAudit writes the audit trail of a mutating user operation, a nil log records nothing.
+// Failing to write is logged, the operation has already happened by then.
+func RecordAudit(ctx context.Context, log *audit.Log, operation string, req, resp proto.Message, err error) {
+	if log == nil {
+		return
+	}
+
+	base := audit.Event{
+		RequestID: RequestIDFromContext(ctx),
+		Actor:     UserIDFromContext(ctx),
+		ActorRole: RoleFromContext(ctx),
+		SourceIP:  ClientIPFromContext(ctx),
+		Operation: operation,
+	}
+	for _, e := range audit.Events(base, audit.UserChanges(req, resp), err) {
+		// Upstream error messages may quote the submitted values
+		e.Error = redact.Text(e.Error)
+		if werr := log.Record(e); werr != nil {
+			zap.L().Error("Failed to write audit event",
+				zap.Error(werr),
+				zap.String("operation", operation),
+				zap.String("target", e.Target),
+			)
+		}
+	}
+}
+
+// GRPCAudit records the outcome of the given methods in the audit trail
+func GRPCAudit(log *audit.Log, methods ...string) grpc.UnaryServerInterceptor {
+	audited := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		audited[m] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !audited[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+		reqMsg, _ := req.(proto.Message)
+		respMsg, _ := resp.(proto.Message)
+		RecordAudit(ctx, log, path.Base(info.FullMethod), reqMsg, respMsg, err)
+		return resp, err
+	}
+}
@@ -2,30 +2,105 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/kannan112/gateway-structure/pkg/apikey"
+	"github.com/kannan112/gateway-structure/pkg/introspection"
+	"github.com/kannan112/gateway-structure/pkg/revocation"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+// RoleService is assigned to callers authenticated with an API key
+const RoleService = "service"
+
+const (
+	apiKeyHeader   = "X-API-Key"
+	apiKeyMetadata = "x-api-key"
+)
+
 type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	UserID string   `json:"user_id"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtSecret = []byte("your-secret-key") // add it from config.jwt-srca
+// jwtSecret is replaced on config reload while requests are validated, so it is swapped atomically
+var jwtSecret atomic.Pointer[[]byte]
+
+func init() {
+	secret := []byte("your-secret-key") // add it from config.jwt-srca
+	jwtSecret.Store(&secret)
+}
+
+// SetJWTSecret replaces the key used to validate locally issued tokens
+func SetJWTSecret(secret string) {
+	key := []byte(secret)
+	jwtSecret.Store(&key)
+}
+
+// apiKeys verifies X-API-Key credentials, API key auth is disabled while nil
+var apiKeys *apikey.Manager
+
+// SetAPIKeyManager enables API key authentication in Authenticate and GRPCAuth
+func SetAPIKeyManager(m *apikey.Manager) {
+	apiKeys = m
+}
+
+// introspector verifies tokens that can't be validated locally, disabled while nil
+var introspector *introspection.Introspector
+
+// SetIntrospector enables RFC 7662 introspection for opaque and foreign tokens
+func SetIntrospector(i *introspection.Introspector) {
+	introspector = i
+}
+
+// revocations is consulted for every bearer token, revocation checks are skipped while nil
+var revocations revocation.Store
+
+// SetRevocationStore enables the token and user denylist in Authenticate and GRPCAuth
+func SetRevocationStore(s revocation.Store) {
+	revocations = s
+}
 
 // HTTP Authentication middleware
 func Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rawKey := r.Header.Get(apiKeyHeader); rawKey != "" && apiKeys != nil {
+			claims, tier, err := validateAPIKey(rawKey)
+			if err != nil {
+				http.Error(w, "Invalid or expired API key", http.StatusUnauthorized)
+				return
+			}
+			if !allowTier(tier, claims.UserID) {
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			ctx := ContextWithClaims(r.Context(), claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
+			// Fall back to the identity of a verified client certificate
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				if claims := claimsFromCertificate(r.TLS.VerifiedChains[0][0]); claims != nil {
+					ctx := ContextWithClaims(r.Context(), claims)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
 			http.Error(w, "Authorization header required", http.StatusUnauthorized)
 			return
 		}
@@ -36,53 +111,306 @@ func Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		claims, err := validateToken(bearerToken[1])
+		claims, err := validateToken(r.Context(), bearerToken[1])
 		if err != nil {
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
 
 		// Add claims to request context
-		ctx := context.WithValue(r.Context(), "claims", claims)
+		ctx := ContextWithClaims(r.Context(), claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireRole rejects authenticated requests whose role is not one of roles.
+// It must run after Authenticate.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			for _, role := range roles {
+				if claims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+// RequireScope admits callers granted one of the given scopes. Scopes restrict API keys and tokens
+// issued with scopes, user tokens without any are left to role checks.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !hasScope(claims, scopes) {
+				http.Error(w, "Insufficient scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireToken only admits requests carrying the given static bearer token
+func RequireToken(token string) func(http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if token == "" || subtle.ConstantTimeCompare(got, expected) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // gRPC Authentication interceptor
 func GRPCAuth() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.Unauthenticated, "metadata is not provided")
+		newCtx, err := grpcAuthenticate(ctx)
+		if err != nil {
+			return nil, err
 		}
+		return handler(newCtx, req)
+	}
+}
 
-		authHeader, ok := md["authorization"]
-		if !ok || len(authHeader) == 0 {
-			return nil, status.Error(codes.Unauthenticated, "authorization token is not provided")
+// GRPCStreamAuth is the streaming counterpart of GRPCAuth. Server reflection stays public for grpcurl.
+func GRPCStreamAuth() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, "/grpc.reflection.") {
+			return handler(srv, ss)
 		}
 
-		bearerToken := strings.Split(authHeader[0], " ")
-		if len(bearerToken) != 2 || strings.ToLower(bearerToken[0]) != "bearer" {
-			return nil, status.Error(codes.Unauthenticated, "invalid authorization format")
+		newCtx, err := grpcAuthenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: newCtx})
+	}
+}
+
+// GRPCRequireRole restricts the given unary method to the listed roles, other methods pass through.
+// An empty method restricts every method. It must run after GRPCAuth.
+func GRPCRequireRole(method string, roles ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if method != "" && info.FullMethod != method {
+			return handler(ctx, req)
+		}
+		if !hasRole(RoleFromContext(ctx), roles) {
+			return nil, status.Error(codes.PermissionDenied, "insufficient permissions")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamRequireRole restricts the given streaming method to the listed roles, other streams pass through
+func GRPCStreamRequireRole(method string, roles ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod != method {
+			return handler(srv, ss)
+		}
+		if !hasRole(RoleFromContext(ss.Context()), roles) {
+			return status.Error(codes.PermissionDenied, "insufficient permissions")
+		}
+		return handler(srv, ss)
+	}
+}
+
+// GRPCRequireScope restricts the given method, or every method when empty, to callers granted one of scopes
+func GRPCRequireScope(method string, scopes ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if method != "" && info.FullMethod != method {
+			return handler(ctx, req)
+		}
+		if err := checkScope(ctx, scopes); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamRequireScope restricts the given streaming method to callers granted one of scopes
+func GRPCStreamRequireScope(method string, scopes ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod != method {
+			return handler(srv, ss)
+		}
+		if err := checkScope(ss.Context(), scopes); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkScope(ctx context.Context, scopes []string) error {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if !hasScope(claims, scopes) {
+		return status.Error(codes.PermissionDenied, "insufficient scope")
+	}
+	return nil
+}
+
+// hasScope reports whether claims hold one of scopes. API keys are always scoped, even when
+// created without any.
+func hasScope(claims *Claims, scopes []string) bool {
+	if claims.Role != RoleService && len(claims.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		if claims.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRole(role string, roles []string) bool {
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
 		}
+	}
+	return false
+}
+
+// grpcAuthenticate verifies the caller from incoming metadata and returns a context carrying its claims
+func grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "metadata is not provided")
+	}
 
-		claims, err := validateToken(bearerToken[1])
+	if rawKey := md.Get(apiKeyMetadata); len(rawKey) > 0 && apiKeys != nil {
+		claims, tier, err := validateAPIKey(rawKey[0])
 		if err != nil {
-			return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired api key")
+		}
+		if !allowTier(tier, claims.UserID) {
+			return nil, status.Error(codes.ResourceExhausted, "too many requests")
 		}
 
-		newCtx := context.WithValue(ctx, "claims", claims)
-		return handler(newCtx, req)
+		return ContextWithClaims(ctx, claims), nil
+	}
+
+	authHeader, ok := md["authorization"]
+	if !ok || len(authHeader) == 0 {
+		// Fall back to the identity of a verified client certificate
+		if claims := peerCertificateClaims(ctx); claims != nil {
+			return ContextWithClaims(ctx, claims), nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "authorization token is not provided")
+	}
+
+	bearerToken := strings.Split(authHeader[0], " ")
+	if len(bearerToken) != 2 || strings.ToLower(bearerToken[0]) != "bearer" {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization format")
+	}
+
+	claims, err := validateToken(ctx, bearerToken[1])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+	}
+
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// claimsKey is the context key for verified claims, a private type so it never collides with other keys
+type claimsKey struct{}
+
+// ContextWithClaims returns a copy of ctx carrying the verified claims
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	if entry := accessEntryFromContext(ctx); entry != nil && claims != nil {
+		entry.userID = claims.UserID
+	}
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by Authenticate or GRPCAuth
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// UserIDFromContext returns the authenticated user ID, or "" for anonymous requests
+func UserIDFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.UserID
+	}
+	return ""
+}
+
+// RoleFromContext returns the authenticated role, or "" for anonymous requests
+func RoleFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.Role
+	}
+	return ""
+}
+
+// HasScope reports whether the claims were granted the given scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateToken validates a JWT locally and falls back to introspection for foreign tokens when
+// configured. Tokens that were revoked are rejected, store errors reject the token as well.
+func validateToken(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := validateJWT(tokenString)
+	if err != nil && introspector != nil && foreignToken(tokenString) {
+		claims, err = introspectToken(ctx, tokenString)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revocations != nil {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		revoked, err := revocations.IsRevoked(ctx, claims.ID, claims.UserID, issuedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check revocation: %v", err)
+		}
+		if revoked {
+			return nil, fmt.Errorf("token has been revoked")
+		}
+	}
+
+	return claims, nil
 }
 
-func validateToken(tokenString string) (*Claims, error) {
+func validateJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return jwtSecret, nil
+		return *jwtSecret.Load(), nil
 	})
 
 	if err != nil {
@@ -95,3 +423,71 @@ func validateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// foreignToken reports whether tokenString isn't one of the gateway's own JWTs, which are HMAC
+// signed without a key ID. Opaque tokens and an IdP's signed JWTs are foreign, expired or
+// tampered local JWTs are not, so the IdP never sees them.
+func foreignToken(tokenString string) bool {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		return true
+	}
+	if _, ok := token.Header["kid"]; ok {
+		return true
+	}
+	_, local := token.Method.(*jwt.SigningMethodHMAC)
+	return !local
+}
+
+// introspectToken maps an active introspection response onto Claims
+func introspectToken(ctx context.Context, tokenString string) (*Claims, error) {
+	resp, err := introspector.Introspect(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		UserID: resp.UserID,
+		Role:   resp.Role,
+		Scopes: resp.Scopes(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   resp.Iss,
+			Subject:  resp.Sub,
+			Audience: resp.Audience(),
+			ID:       resp.Jti,
+		},
+	}
+	if claims.UserID == "" {
+		claims.UserID = resp.Sub
+	}
+	// Every caller needs an identity, for ownership checks, revocation and rate limits
+	if claims.UserID == "" {
+		return nil, fmt.Errorf("introspection response names no user")
+	}
+	if resp.Exp > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Unix(resp.Exp, 0))
+	}
+	if resp.Iat > 0 {
+		claims.IssuedAt = jwt.NewNumericDate(time.Unix(resp.Iat, 0))
+	}
+	if resp.Nbf > 0 {
+		claims.NotBefore = jwt.NewNumericDate(time.Unix(resp.Nbf, 0))
+	}
+	return claims, nil
+}
+
+// validateAPIKey maps a verified API key onto Claims and returns its rate limit tier
+func validateAPIKey(rawKey string) (*Claims, string, error) {
+	key, err := apiKeys.Verify(rawKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	claims := &Claims{
+		UserID: "apikey:" + key.ID,
+		Role:   RoleService,
+		Scopes: key.Scopes,
+	}
+	claims.Subject = key.Name
+	return claims, key.Tier, nil
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kannan112/gateway-structure/pkg/introspection"
+)
+
+func signToken(t *testing.T, secret string) string {
+	t.Helper()
+	claims := &Claims{
+		UserID:           "u1",
+		Role:             "user",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSetJWTSecretWhileValidating(t *testing.T) {
+	defer SetJWTSecret("your-secret-key")
+
+	old, rotated := signToken(t, "old-secret"), signToken(t, "new-secret")
+	SetJWTSecret("old-secret")
+	if _, err := validateJWT(old); err != nil {
+		t.Fatalf("token signed with the current secret rejected: %v", err)
+	}
+
+	// Run with -race, reloads swap the secret while requests are validated
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				validateJWT(old)
+			}
+		}()
+	}
+	SetJWTSecret("new-secret")
+	wg.Wait()
+
+	if _, err := validateJWT(old); err == nil {
+		t.Fatal("token signed with the replaced secret accepted")
+	}
+	if _, err := validateJWT(rotated); err != nil {
+		t.Fatalf("token signed with the new secret rejected: %v", err)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{name: "user token without scopes", claims: &Claims{UserID: "u1", Role: "user"}, want: http.StatusOK},
+		{name: "API key with the scope", claims: &Claims{Role: RoleService, Scopes: []string{"users"}}, want: http.StatusOK},
+		{name: "API key with every scope", claims: &Claims{Role: RoleService, Scopes: []string{"*"}}, want: http.StatusOK},
+		{name: "API key with another scope", claims: &Claims{Role: RoleService, Scopes: []string{"admin"}}, want: http.StatusForbidden},
+		{name: "API key without scopes", claims: &Claims{Role: RoleService}, want: http.StatusForbidden},
+		{name: "scoped user token", claims: &Claims{UserID: "u1", Role: "user", Scopes: []string{"profile"}}, want: http.StatusForbidden},
+		{name: "anonymous", want: http.StatusUnauthorized},
+	}
+	handler := RequireScope("users")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			if tt.claims != nil {
+				r = r.WithContext(ContextWithClaims(context.Background(), tt.claims))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateScopes(t *testing.T) {
+	defer SetCertificateScopes(nil)
+
+	tests := []struct {
+		name   string
+		scopes map[string][]string
+		want   int
+	}{
+		{name: "identity with the scope", scopes: map[string][]string{"spiffe://example.org/billing": {"users"}}, want: http.StatusOK},
+		{name: "identity with another scope", scopes: map[string][]string{"spiffe://example.org/billing": {"admin"}}, want: http.StatusForbidden},
+		{name: "default scopes", scopes: map[string][]string{"*": {"users"}}, want: http.StatusOK},
+		{name: "listed identity ignores the default", scopes: map[string][]string{"spiffe://example.org/billing": {}, "*": {"users"}}, want: http.StatusForbidden},
+		{name: "no scopes configured", want: http.StatusForbidden},
+	}
+
+	id, _ := url.Parse("spiffe://example.org/billing")
+	cert := &x509.Certificate{URIs: []*url.URL{id}, DNSNames: []string{"billing.internal"}}
+	handler := Authenticate(RequireScope("users")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.UserID != "cert:spiffe://example.org/billing" || claims.Role != RoleService {
+			t.Errorf("unexpected claims %+v", claims)
+		}
+	})))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetCertificateScopes(tt.scopes)
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectionFallback(t *testing.T) {
+	defer SetJWTSecret("your-secret-key")
+	SetJWTSecret("local-secret")
+
+	// The IdP knows opaque-user and every JWT it signed, opaque-anonymous is active without a subject
+	var calls atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		resp := introspection.Response{Active: true}
+		switch token := r.PostFormValue("token"); {
+		case token == "opaque-user":
+			resp.Sub = "u2"
+		case strings.Count(token, ".") == 2:
+			resp.UserID = "idp-user"
+		case token != "opaque-anonymous":
+			resp.Active = false
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer idp.Close()
+	i, err := introspection.New(introspection.Config{Endpoint: idp.URL, HTTPClient: idp.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetIntrospector(i)
+	defer SetIntrospector(nil)
+
+	sign := func(secret string, expires time.Time, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+			UserID:           "u1",
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expires)},
+		})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	hour := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name         string
+		token        string
+		want         string
+		introspected bool
+	}{
+		{name: "local JWT", token: sign("local-secret", hour, ""), want: "u1"},
+		{name: "tampered local JWT", token: sign("other-secret", hour, "")},
+		{name: "expired local JWT", token: sign("local-secret", time.Now().Add(-time.Hour), "")},
+		{name: "JWT with a foreign key ID", token: sign("idp-secret", hour, "idp-key-1"), want: "idp-user", introspected: true},
+		{name: "opaque token", token: "opaque-user", want: "u2", introspected: true},
+		{name: "opaque token without a subject", token: "opaque-anonymous", introspected: true},
+		{name: "inactive opaque token", token: "opaque-revoked", introspected: true},
+	}
+	for _, tt := range tests {
+		calls.Store(0)
+		claims, err := validateToken(context.Background(), tt.token)
+		if tt.want == "" && err == nil {
+			t.Errorf("%s: accepted as %q", tt.name, claims.UserID)
+		}
+		if tt.want != "" && (err != nil || claims.UserID != tt.want) {
+			t.Errorf("%s: got %+v, %v, want user %s", tt.name, claims, err, tt.want)
+		}
+		if introspected := calls.Load() > 0; introspected != tt.introspected {
+			t.Errorf("%s: introspected %v, want %v", tt.name, introspected, tt.introspected)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// BodyLimit caps request bodies at defaultLimit bytes, or at the limit of the longest
+// matching path prefix in routeLimits. Oversized requests get 413 Request Entity Too Large.
+// A limit of zero or less disables the cap.
+func BodyLimit(defaultLimit int64, routeLimits map[string]int64) func(http.Handler) http.Handler {
+	prefixes := make([]string, 0, len(routeLimits))
+	for prefix := range routeLimits {
+		prefixes = append(prefixes, prefix)
+	}
+	// Longest prefix first so the most specific route wins
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := defaultLimit
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					limit = routeLimits[prefix]
+					break
+				}
+			}
+
+			if limit > 0 {
+				// Reject early when the client announces an oversized body
+				if r.ContentLength > limit {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kannan112/gateway-structure/pkg/fault"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// FaultInjection delays, aborts or drops requests matching the injector's rules.
+// It must run after Authenticate for rules targeting a user ID.
+func FaultInjection(injector *fault.Injector) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var userID string
+			if claims, ok := ClaimsFromContext(r.Context()); ok {
+				userID = claims.UserID
+			}
+			f := injector.Match(fault.Request{Path: r.URL.Path, Header: r.Header.Get, UserID: userID})
+			if f == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := f.Wait(r.Context()); err != nil {
+				return
+			}
+			switch {
+			case f.Abort:
+				http.Error(w, "Fault injected", f.AbortHTTP)
+			case f.Drop:
+				next.ServeHTTP(discardResponseWriter{header: make(http.Header)}, r)
+				panic(http.ErrAbortHandler)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// GRPCFaultInjection is the gRPC counterpart of FaultInjection. Dropped calls are handled
+// and then held until the client gives up.
+func GRPCFaultInjection(injector *fault.Injector) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var userID string
+		if claims, ok := ClaimsFromContext(ctx); ok {
+			userID = claims.UserID
+		}
+		header := func(name string) string { return RoutingHeader(ctx, name) }
+		f := injector.Match(fault.Request{Path: info.FullMethod, GRPC: true, Header: header, UserID: userID})
+		if f == nil {
+			return handler(ctx, req)
+		}
+
+		if err := f.Wait(ctx); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		switch {
+		case f.Abort:
+			return nil, status.Error(f.AbortGRPC, "fault injected")
+		case f.Drop:
+			handler(ctx, req)
+			<-ctx.Done()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// discardResponseWriter swallows the response of a dropped request
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d discardResponseWriter) Header() http.Header         { return d.header }
+func (d discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponseWriter) WriteHeader(int)             {}
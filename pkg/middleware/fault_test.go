@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kannan112/gateway-structure/pkg/fault"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newInjector(t *testing.T, rules ...fault.Rule) *fault.Injector {
+	t.Helper()
+	i := fault.NewInjector("development", false)
+	if err := i.Enable(rules); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestFaultInjectionAbort(t *testing.T) {
+	injector := newInjector(t, fault.Rule{Percent: 100, Route: "/api/v1/users", AbortHTTP: http.StatusServiceUnavailable})
+
+	var handled atomic.Bool
+	handler := Recovery(zap.NewNop())(FaultInjection(injector)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled.Store(true)
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+	if w.Code != http.StatusServiceUnavailable || handled.Load() {
+		t.Fatalf("got %d with handled %v, want an aborted 503", w.Code, handled.Load())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/auth/login", nil))
+	if w.Code != http.StatusOK || !handled.Load() {
+		t.Fatalf("unmatched route got %d with handled %v", w.Code, handled.Load())
+	}
+}
+
+func TestFaultInjectionDrop(t *testing.T) {
+	injector := newInjector(t, fault.Rule{Percent: 100, Header: "X-Fault", Drop: true})
+
+	var handled atomic.Bool
+	// Recovery passes http.ErrAbortHandler on, so the server resets the connection
+	server := httptest.NewServer(Recovery(zap.NewNop())(FaultInjection(injector)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled.Store(true)
+		w.Write([]byte("discarded"))
+	}))))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/users", nil)
+	req.Header.Set("X-Fault", "1")
+	resp, err := server.Client().Do(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected the connection to be dropped, got %s", resp.Status)
+	}
+	if !handled.Load() {
+		t.Fatal("dropped request wasn't handled")
+	}
+}
+
+func TestGRPCFaultInjection(t *testing.T) {
+	unavailable := codes.Unavailable
+	injector := newInjector(t,
+		fault.Rule{Percent: 100, Method: "/user.UserService/GetUser", AbortGRPC: &unavailable},
+		fault.Rule{Percent: 100, Method: "/user.UserService/ListUsers", Drop: true},
+	)
+	interceptor := GRPCFaultInjection(injector)
+
+	var handled atomic.Int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled.Add(1)
+		return "ok", nil
+	}
+
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}, handler)
+	if status.Code(err) != codes.Unavailable || handled.Load() != 0 {
+		t.Fatalf("got %v with %d handled, want an aborted Unavailable", err, handled.Load())
+	}
+
+	// Dropped calls are handled, then held until the client's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/ListUsers"}, handler)
+	if status.Code(err) != codes.DeadlineExceeded || handled.Load() != 1 {
+		t.Fatalf("got %v with %d handled, want a handled call ending at the deadline", err, handled.Load())
+	}
+
+	if _, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/DeleteUser"}, handler); err != nil {
+		t.Fatalf("unmatched method failed: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/kannan112/gateway-structure/pkg/fieldmask"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// GRPCFieldMask rejects update requests whose mask names fields the caller's role may not write
+func GRPCFieldMask(policy fieldmask.Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if msg, ok := req.(proto.Message); ok {
+			if err := policy.Check(RoleFromContext(ctx), msg); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
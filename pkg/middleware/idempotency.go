@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kannan112/gateway-structure/pkg/idempotency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	idempotencyHeader   = "Idempotency-Key"
+	idempotencyMetadata = "idempotency-key"
+	replayedHeader      = "Idempotent-Replayed"
+)
+
+// Idempotency replays the stored response for mutating requests that repeat an
+// Idempotency-Key header. Keys are scoped per caller and must run after Authenticate.
+func Idempotency(store idempotency.Store, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scoped := idempotencyScope(r.Context(), r.RemoteAddr) + ":" + key
+			fingerprint := fingerprint([]byte(r.Method), []byte(r.URL.Path), []byte(r.URL.RawQuery), body)
+
+			record, err := store.Reserve(r.Context(), scoped, fingerprint, ttl)
+			switch {
+			case errors.Is(err, idempotency.ErrMismatch):
+				http.Error(w, "Idempotency key reused with a different payload", http.StatusUnprocessableEntity)
+				return
+			case errors.Is(err, idempotency.ErrInProgress):
+				http.Error(w, "Request with this idempotency key is in progress", http.StatusConflict)
+				return
+			case err != nil:
+				http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			case record != nil:
+				for name, values := range record.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(replayedHeader, "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+				return
+			}
+
+			// Failed or panicking requests give the key back so it can be retried
+			completed := false
+			defer func() {
+				if !completed {
+					store.Release(r.Context(), scoped)
+				}
+			}()
+
+			rec := newRecordingResponseWriter(w)
+			next.ServeHTTP(rec, r)
+
+			// Only successful responses are kept, failures may be retried with the same key
+			if rec.status >= 200 && rec.status < 300 {
+				store.Complete(r.Context(), scoped, &idempotency.Record{
+					Status: rec.status,
+					Header: rec.Header().Clone(),
+					Body:   rec.body.Bytes(),
+				})
+				completed = true
+			}
+		})
+	}
+}
+
+// GRPCIdempotency is the gRPC counterpart of Idempotency for the given full method names
+func GRPCIdempotency(store idempotency.Store, ttl time.Duration, methods ...string) grpc.UnaryServerInterceptor {
+	enabled := make(map[string]bool)
+	for _, m := range methods {
+		enabled[m] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		msg, ok := req.(proto.Message)
+		if !ok || !enabled[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		keys := md.Get(idempotencyMetadata)
+		if len(keys) == 0 || keys[0] == "" {
+			return handler(ctx, req)
+		}
+
+		payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to fingerprint request")
+		}
+		scoped := idempotencyScope(ctx, "") + ":" + keys[0]
+		fingerprint := fingerprint([]byte(info.FullMethod), payload)
+
+		record, err := store.Reserve(ctx, scoped, fingerprint, ttl)
+		switch {
+		case errors.Is(err, idempotency.ErrMismatch):
+			return nil, status.Error(codes.FailedPrecondition, "idempotency key reused with a different payload")
+		case errors.Is(err, idempotency.ErrInProgress):
+			return nil, status.Error(codes.Aborted, "request with this idempotency key is in progress")
+		case err != nil:
+			return nil, status.Error(codes.Unavailable, "idempotency store unavailable")
+		case record != nil:
+			var stored anypb.Any
+			if err := proto.Unmarshal(record.Body, &stored); err != nil {
+				return nil, status.Error(codes.Internal, "failed to decode stored response")
+			}
+			grpc.SetHeader(ctx, metadata.Pairs(replayedHeader, "true"))
+			return stored.UnmarshalNew()
+		}
+
+		// Failed or panicking calls give the key back so it can be retried
+		completed := false
+		defer func() {
+			if !completed {
+				store.Release(ctx, scoped)
+			}
+		}()
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+
+		if out, ok := resp.(proto.Message); ok {
+			if packed, err := anypb.New(out); err == nil {
+				if body, err := proto.Marshal(packed); err == nil {
+					store.Complete(ctx, scoped, &idempotency.Record{Body: body})
+					completed = true
+				}
+			}
+		}
+		return resp, nil
+	}
+}
+
+// idempotencyScope keys records by caller so different callers can't replay each other's responses
+func idempotencyScope(ctx context.Context, fallback string) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.UserID
+	}
+	return "anonymous:" + fallback
+}
+
+func fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingResponseWriter passes the response through while keeping a copy
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newRecordingResponseWriter(w http.ResponseWriter) *recordingResponseWriter {
+	return &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
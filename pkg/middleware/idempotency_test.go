@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kannan112/gateway-structure/pkg/idempotency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	store := idempotency.NewMemoryStore(time.Minute)
+	defer store.Close()
+
+	panicking := Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(`{"username":"jdoe"}`))
+		r.Header.Set(idempotencyHeader, "key-1")
+		return r
+	}
+
+	func() {
+		defer func() { recover() }()
+		panicking.ServeHTTP(httptest.NewRecorder(), request())
+	}()
+
+	ok := Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	w := httptest.NewRecorder()
+	ok.ServeHTTP(w, request())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("retry after a panic got %d, the key was left in progress", w.Code)
+	}
+}
+
+func TestGRPCIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	store := idempotency.NewMemoryStore(time.Minute)
+	defer store.Close()
+
+	const method = "/user.UserService/CreateUser"
+	interceptor := GRPCIdempotency(store, time.Hour, method)
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyMetadata, "key-1"))
+
+	func() {
+		defer func() { recover() }()
+		interceptor(ctx, &emptypb.Empty{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("handler failed")
+		})
+	}()
+
+	_, err := interceptor(ctx, &emptypb.Empty{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &emptypb.Empty{}, nil
+	})
+	if err != nil {
+		t.Fatalf("retry after a panic failed, the key was left in progress: %v", err)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	store := idempotency.NewMemoryStore(time.Minute)
+	defer store.Close()
+
+	var handled atomic.Int32
+	release := make(chan struct{})
+	handler := Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled.Add(1)
+		if r.URL.Path == "/api/v1/users/slow" {
+			<-release
+		}
+		w.Header().Set("Location", "/api/v1/users/"+strconv.Itoa(int(handled.Load())))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"` + strconv.Itoa(int(handled.Load())) + `"}`))
+	}))
+	request := func(user, target, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set(idempotencyHeader, key)
+		r = r.WithContext(ContextWithClaims(r.Context(), &Claims{UserID: user}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := request("u1", "/api/v1/users", "k1", `{"username":"jdoe"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request got %d", first.Code)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		target  string
+		body    string
+		want    int
+		handled int32
+	}{
+		{name: "replay", user: "u1", target: "/api/v1/users", body: `{"username":"jdoe"}`, want: http.StatusCreated, handled: 1},
+		{name: "different payload", user: "u1", target: "/api/v1/users", body: `{"username":"jsmith"}`, want: http.StatusUnprocessableEntity, handled: 1},
+		{name: "different query", user: "u1", target: "/api/v1/users?dry_run=true", body: `{"username":"jdoe"}`, want: http.StatusUnprocessableEntity, handled: 1},
+		{name: "other caller", user: "u2", target: "/api/v1/users", body: `{"username":"jdoe"}`, want: http.StatusCreated, handled: 2},
+	}
+	for _, tt := range tests {
+		w := request(tt.user, tt.target, "k1", tt.body)
+		if w.Code != tt.want || handled.Load() != tt.handled {
+			t.Errorf("%s: got %d with %d handled, want %d with %d", tt.name, w.Code, handled.Load(), tt.want, tt.handled)
+		}
+	}
+
+	replay := request("u1", "/api/v1/users", "k1", `{"username":"jdoe"}`)
+	if replay.Body.String() != first.Body.String() || replay.Header().Get("Location") != first.Header().Get("Location") || replay.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("replay got %q at %q, want the stored %q at %q", replay.Body.String(), replay.Header().Get("Location"), first.Body.String(), first.Header().Get("Location"))
+	}
+
+	// A retry while the first request still runs is turned away
+	done := make(chan int)
+	go func() { done <- request("u1", "/api/v1/users/slow", "k2", `{}`).Code }()
+	for handled.Load() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if w := request("u1", "/api/v1/users/slow", "k2", `{}`); w.Code != http.StatusConflict {
+		t.Fatalf("retry while in progress got %d, want 409", w.Code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusCreated {
+		t.Fatalf("slow request got %d", code)
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// IdentityHeaderPrefix marks headers and metadata keys that carry the identity
+// verified by the gateway. Only the gateway may set them.
+const IdentityHeaderPrefix = "x-gateway-"
+
+// StripIdentityHeaders removes identity headers sent by clients so they can't be spoofed upstream
+func StripIdentityHeaders() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name := range r.Header {
+				if strings.HasPrefix(strings.ToLower(name), IdentityHeaderPrefix) {
+					r.Header.Del(name)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GRPCStripIdentity removes identity metadata sent by clients so it can't be spoofed upstream
+func GRPCStripIdentity() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(stripIdentityMetadata(ctx), req)
+	}
+}
+
+// GRPCStreamStripIdentity is the streaming counterpart of GRPCStripIdentity
+func GRPCStreamStripIdentity() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: stripIdentityMetadata(ss.Context())})
+	}
+}
+
+func stripIdentityMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	var cleaned metadata.MD
+	for key := range md {
+		if strings.HasPrefix(key, IdentityHeaderPrefix) {
+			if cleaned == nil {
+				cleaned = md.Copy()
+			}
+			delete(cleaned, key)
+		}
+	}
+	if cleaned == nil {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, cleaned)
+}
@@ -2,11 +2,20 @@ package middleware
 
 import (
 	"context"
+	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/kannan112/gateway-structure/pkg/redact"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // HTTP Logger middleware
@@ -30,30 +39,197 @@ func Logger(logger *zap.Logger) func(http.Handler) http.Handler {
 				zap.Duration("latency", time.Since(start)),
 				zap.Int("bytes", wrw.bytesWritten),
 				zap.String("user_agent", r.UserAgent()),
+				zap.String("request_id", RequestIDFromContext(r.Context())),
 			)
 		})
 	}
 }
 
+// AccessLogConfig controls which gRPC calls are logged and what they carry
+type AccessLogConfig struct {
+	// SampleRate is the share of successful calls that are logged, failed calls are always logged
+	SampleRate float64
+	// SlowThreshold logs every unary call that takes longer, zero disables it
+	SlowThreshold time.Duration
+	// MetadataKeys are copied from the incoming metadata, credentials are masked
+	MetadataKeys []string
+}
+
+// sensitiveMetadata are never logged in clear
+var sensitiveMetadata = map[string]bool{"authorization": true, "cookie": true, apiKeyMetadata: true}
+
+// accessLogKey is the context key of the entry inner interceptors fill in
+type accessLogKey struct{}
+
+// accessEntry collects what is only known after the logger ran, such as the authenticated user
+type accessEntry struct {
+	userID    string
+	requestID string
+}
+
+func accessEntryFromContext(ctx context.Context) *accessEntry {
+	entry, _ := ctx.Value(accessLogKey{}).(*accessEntry)
+	return entry
+}
+
 // gRPC Logger interceptor
-func GRPCLogger(logger *zap.Logger) grpc.UnaryServerInterceptor {
+func GRPCLogger(logger *zap.Logger, config AccessLogConfig) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		start := time.Now()
+		entry := &accessEntry{}
+		fields := callFields(ctx, config)
 
 		// Process request
-		resp, err := handler(ctx, req)
+		resp, err := handler(context.WithValue(ctx, accessLogKey{}, entry), req)
+
+		latency := time.Since(start)
+		slow := config.SlowThreshold > 0 && latency > config.SlowThreshold
+		if err == nil && !slow && !sampled(config.SampleRate) {
+			return resp, err
+		}
 
 		// Log the request details
-		logger.Info("gRPC Request",
+		fields = append(fields,
 			zap.String("method", info.FullMethod),
-			zap.Duration("latency", time.Since(start)),
-			zap.Error(err),
+			zap.String("code", status.Code(err).String()),
+			zap.Duration("latency", latency),
+			zap.Int("request_bytes", messageSize(req)),
+			zap.Int("response_bytes", messageSize(resp)),
+			zap.String("user_id", entry.userID),
+			zap.String("request_id", entry.requestID),
+			redact.Error(err),
 		)
+		if slow {
+			fields = append(fields, zap.Bool("slow", true))
+		}
+		logger.Log(accessLevel(err, slow), "gRPC Request", fields...)
 
 		return resp, err
 	}
 }
 
+// gRPC stream Logger interceptor, logs once the stream ends. SlowThreshold doesn't apply,
+// streams such as WatchUsers stay open for as long as the client listens.
+func GRPCStreamLogger(logger *zap.Logger, config AccessLogConfig) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		entry := &accessEntry{}
+		fields := callFields(ss.Context(), config)
+		counted := &countingServerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), accessLogKey{}, entry)}
+
+		err := handler(srv, counted)
+
+		if err == nil && !sampled(config.SampleRate) {
+			return err
+		}
+
+		fields = append(fields,
+			zap.String("method", info.FullMethod),
+			zap.String("code", status.Code(err).String()),
+			zap.Duration("duration", time.Since(start)),
+			zap.Int("messages_received", counted.received),
+			zap.Int("messages_sent", counted.sent),
+			zap.Int("bytes_received", counted.receivedBytes),
+			zap.Int("bytes_sent", counted.sentBytes),
+			zap.String("user_id", entry.userID),
+			redact.Error(err),
+		)
+		logger.Log(accessLevel(err, false), "gRPC Stream", fields...)
+
+		return err
+	}
+}
+
+// callFields describes the caller from the peer and the incoming metadata
+func callFields(ctx context.Context, config AccessLogConfig) []zap.Field {
+	var fields []zap.Field
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		fields = append(fields, zap.Duration("deadline_remaining", time.Until(deadline)))
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		fields = append(fields, zap.String("user_agent", ua[0]))
+	}
+	if len(config.MetadataKeys) > 0 {
+		selected := make(map[string][]string)
+		for _, key := range config.MetadataKeys {
+			values := md.Get(key)
+			if len(values) == 0 {
+				continue
+			}
+			if sensitiveMetadata[strings.ToLower(key)] {
+				values = []string{redact.Placeholder}
+			}
+			selected[key] = values
+		}
+		if len(selected) > 0 {
+			fields = append(fields, zap.Any("metadata", selected))
+		}
+	}
+	return fields
+}
+
+// accessLevel logs server side failures as errors, client errors and slow calls as warnings
+func accessLevel(err error, slow bool) zapcore.Level {
+	switch status.Code(err) {
+	case codes.OK:
+		if slow {
+			return zapcore.WarnLevel
+		}
+		return zapcore.InfoLevel
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded, codes.Unimplemented:
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.WarnLevel
+	}
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+// messageSize is the encoded size of a proto message, 0 for anything else
+func messageSize(msg interface{}) int {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// countingServerStream counts the messages and bytes of a stream for its access log
+type countingServerStream struct {
+	grpc.ServerStream
+	ctx                      context.Context
+	sent, received           int
+	sentBytes, receivedBytes int
+}
+
+func (s *countingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+		s.sentBytes += messageSize(m)
+	}
+	return err
+}
+
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+		s.receivedBytes += messageSize(m)
+	}
+	return err
+}
+
 // Custom response writer to capture status code and bytes written
 type wrappedResponseWriter struct {
 	http.ResponseWriter
@@ -75,3 +251,8 @@ func (w *wrappedResponseWriter) Write(b []byte) (int, error) {
 	w.bytesWritten += n
 	return n, err
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streamed responses
+func (w *wrappedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
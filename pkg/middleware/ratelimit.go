@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
@@ -36,9 +37,68 @@ func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
 	return limiter
 }
 
+// Clients returns the number of callers currently tracked by the limiter
+func (i *IPRateLimiter) Clients() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.ips)
+}
+
+// RateLimiterStats describes a limiter for admin inspection
+type RateLimiterStats struct {
+	Name    string  `json:"name"`
+	Rate    float64 `json:"rate"`
+	Burst   int     `json:"burst"`
+	Clients int     `json:"clients"`
+}
+
+func (i *IPRateLimiter) stats(name string) RateLimiterStats {
+	return RateLimiterStats{
+		Name:    name,
+		Rate:    float64(i.r),
+		Burst:   i.b,
+		Clients: i.Clients(),
+	}
+}
+
+// RateLimitStats returns the state of the global and per API key tier limiters
+func RateLimitStats() []RateLimiterStats {
+	stats := []RateLimiterStats{limiter.stats("ip")}
+	for _, tier := range []string{"default", "standard", "premium"} {
+		stats = append(stats, tierLimiters[tier].stats("apikey:"+tier))
+	}
+	return stats
+}
+
 // Global rate limiter instance
 var limiter = NewIPRateLimiter(rate.Limit(100), 150) // 100 requests per second with burst of 150
 
+// Per API key limiters, keyed by the tier assigned to the key
+var tierLimiters = map[string]*IPRateLimiter{
+	"default":  NewIPRateLimiter(rate.Limit(10), 20),
+	"standard": NewIPRateLimiter(rate.Limit(50), 100),
+	"premium":  NewIPRateLimiter(rate.Limit(200), 300),
+}
+
+// allowTier applies the tier's limit to the given caller, unknown tiers fall back to default
+func allowTier(tier, id string) bool {
+	l, ok := tierLimiters[tier]
+	if !ok {
+		l = tierLimiters["default"]
+	}
+	return l.GetLimiter(id).Allow()
+}
+
+// ChargeRateLimit takes n more tokens from the caller's limiter, for requests that cost
+// more than a single call. It reports false when the caller has no tokens left.
+func ChargeRateLimit(r *http.Request, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	return limiter.GetLimiter(r.RemoteAddr).AllowN(time.Now(), n)
+}
+
 func RateLimit() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"runtime/debug"
 
+	"github.com/kannan112/gateway-structure/pkg/redact"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -13,15 +14,19 @@ import (
 )
 
 // HTTP Recovery middleware
-func Recovery() func(http.Handler) http.Handler {
+func Recovery(logger *zap.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
+					// Deliberate aborts close the connection without a response
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+
 					// Log the stack trace
-					logger, _ := zap.NewProduction()
 					logger.Error("panic recovered",
-						zap.Any("error", err),
+						zap.String("error", redact.Text(fmt.Sprint(err))),
 						zap.String("stack", string(debug.Stack())),
 					)
 
@@ -38,14 +43,13 @@ func Recovery() func(http.Handler) http.Handler {
 }
 
 // gRPC Recovery interceptor
-func GRPCRecovery() grpc.UnaryServerInterceptor {
+func GRPCRecovery(logger *zap.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		defer func() {
 			if r := recover(); r != nil {
 				// Log the stack trace
-				logger, _ := zap.NewProduction()
 				logger.Error("panic recovered in gRPC call",
-					zap.Any("error", r),
+					zap.String("error", redact.Text(fmt.Sprint(r))),
 					zap.String("stack", string(debug.Stack())),
 				)
 
@@ -56,3 +60,22 @@ func GRPCRecovery() grpc.UnaryServerInterceptor {
 		return handler(ctx, req)
 	}
 }
+
+// gRPC stream Recovery interceptor
+func GRPCStreamRecovery(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				// Log the stack trace
+				logger.Error("panic recovered in gRPC stream",
+					zap.String("error", redact.Text(fmt.Sprint(r))),
+					zap.String("stack", string(debug.Stack())),
+				)
+
+				err = status.Errorf(codes.Internal, "Internal server error")
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// GlobalChain is the chain key applied to every route or method before the more specific chains
+const GlobalChain = "*"
+
+// HTTPFactory builds an HTTP middleware from the arguments of its chain entry.
+// A nil middleware with a nil error means the plugin is disabled and is left out.
+type HTTPFactory func(args []string) (func(http.Handler) http.Handler, error)
+
+// GRPCFactory builds a unary interceptor from the arguments of its chain entry, nil disables it
+type GRPCFactory func(args []string) (grpc.UnaryServerInterceptor, error)
+
+// Chains maps route prefixes (HTTP) or full method prefixes (gRPC) to ordered plugin entries.
+// An entry is a plugin name with optional arguments, e.g. "require_role:admin,service".
+type Chains struct {
+	HTTP map[string][]string `json:"http,omitempty"`
+	GRPC map[string][]string `json:"grpc,omitempty"`
+}
+
+// Registry holds the named middleware and interceptor factories
+type Registry struct {
+	mu   sync.RWMutex
+	http map[string]HTTPFactory
+	grpc map[string]GRPCFactory
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		http: make(map[string]HTTPFactory),
+		grpc: make(map[string]GRPCFactory),
+	}
+}
+
+var plugins = NewRegistry()
+
+// Plugins returns the registry plugins add themselves to, usually from an init function
+func Plugins() *Registry {
+	return plugins
+}
+
+// RegisterHTTP adds an HTTP middleware factory, replacing one with the same name
+func (r *Registry) RegisterHTTP(name string, factory HTTPFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.http[name] = factory
+}
+
+// RegisterGRPC adds an interceptor factory, replacing one with the same name
+func (r *Registry) RegisterGRPC(name string, factory GRPCFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grpc[name] = factory
+}
+
+// Clone returns a copy of the registry that can be extended without touching r
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clone := NewRegistry()
+	for name, f := range r.http {
+		clone.http[name] = f
+	}
+	for name, f := range r.grpc {
+		clone.grpc[name] = f
+	}
+	return clone
+}
+
+// Names returns the sorted names of the registered HTTP and gRPC plugins
+func (r *Registry) Names() (httpNames, grpcNames []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for name := range r.http {
+		httpNames = append(httpNames, name)
+	}
+	for name := range r.grpc {
+		grpcNames = append(grpcNames, name)
+	}
+	sort.Strings(httpNames)
+	sort.Strings(grpcNames)
+	return httpNames, grpcNames
+}
+
+// HTTPChain builds the middleware of the given entries in order
+func (r *Registry) HTTPChain(entries []string) ([]func(http.Handler) http.Handler, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var chain []func(http.Handler) http.Handler
+	for _, entry := range entries {
+		name, args := parseEntry(entry)
+		factory, ok := r.http[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown HTTP middleware %q", name)
+		}
+		mw, err := factory(args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build HTTP middleware %q: %v", name, err)
+		}
+		if mw != nil {
+			chain = append(chain, mw)
+		}
+	}
+	return chain, nil
+}
+
+// GRPCChain builds the interceptors of the given entries in order
+func (r *Registry) GRPCChain(entries []string) ([]grpc.UnaryServerInterceptor, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var chain []grpc.UnaryServerInterceptor
+	for _, entry := range entries {
+		name, args := parseEntry(entry)
+		factory, ok := r.grpc[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown gRPC interceptor %q", name)
+		}
+		interceptor, err := factory(args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build gRPC interceptor %q: %v", name, err)
+		}
+		if interceptor != nil {
+			chain = append(chain, interceptor)
+		}
+	}
+	return chain, nil
+}
+
+// LoadChains reads a JSON chain file in the same shape as Chains
+func LoadChains(path string) (Chains, error) {
+	var chains Chains
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return chains, fmt.Errorf("failed to read middleware chains %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &chains); err != nil {
+		return chains, fmt.Errorf("failed to parse middleware chains %s: %v", path, err)
+	}
+	return chains, nil
+}
+
+// parseEntry splits "name:arg1,arg2" into its name and arguments
+func parseEntry(entry string) (string, []string) {
+	name, rawArgs, ok := strings.Cut(strings.TrimSpace(entry), ":")
+	if !ok {
+		return name, nil
+	}
+	var args []string
+	for _, arg := range strings.Split(rawArgs, ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			args = append(args, arg)
+		}
+	}
+	return name, args
+}
+
+// Merge returns the chains of c with those of overlay replacing the same keys
+func (c Chains) Merge(overlay Chains) Chains {
+	merged := Chains{HTTP: make(map[string][]string), GRPC: make(map[string][]string)}
+	for _, m := range []Chains{c, overlay} {
+		for key, entries := range m.HTTP {
+			merged.HTTP[key] = entries
+		}
+		for key, entries := range m.GRPC {
+			merged.GRPC[key] = entries
+		}
+	}
+	return merged
+}
+
+// GRPCEntries returns the entries that apply to method
+func (c Chains) GRPCEntries(method string) []string {
+	var entries []string
+	for _, key := range c.grpcKeys(method) {
+		entries = append(entries, c.GRPC[key]...)
+	}
+	return entries
+}
+
+// grpcKeys returns the keys of the chains that apply to method: the global chain, then
+// every key that prefixes method, shortest first
+func (c Chains) grpcKeys(method string) []string {
+	keys := []string{GlobalChain}
+	var matched []string
+	for key := range c.GRPC {
+		if key != GlobalChain && strings.HasPrefix(method, key) {
+			matched = append(matched, key)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if len(matched[i]) != len(matched[j]) {
+			return len(matched[i]) < len(matched[j])
+		}
+		return matched[i] < matched[j]
+	})
+	return append(keys, matched...)
+}
+
+// GRPCChains dispatches every call through the interceptors that apply to its method.
+// All chains are built up front, so unknown plugins fail here rather than on the first call.
+func GRPCChains(registry *Registry, chains Chains) (grpc.UnaryServerInterceptor, error) {
+	built := make(map[string][]grpc.UnaryServerInterceptor, len(chains.GRPC))
+	for key, entries := range chains.GRPC {
+		chain, err := registry.GRPCChain(entries)
+		if err != nil {
+			return nil, fmt.Errorf("gRPC chain %s: %v", key, err)
+		}
+		built[key] = chain
+	}
+
+	var cache sync.Map // full method -> []grpc.UnaryServerInterceptor
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		cached, ok := cache.Load(info.FullMethod)
+		if !ok {
+			var chain []grpc.UnaryServerInterceptor
+			for _, key := range chains.grpcKeys(info.FullMethod) {
+				chain = append(chain, built[key]...)
+			}
+			cached, _ = cache.LoadOrStore(info.FullMethod, chain)
+		}
+		return runChain(cached.([]grpc.UnaryServerInterceptor), ctx, req, info, handler)
+	}, nil
+}
+
+// runChain calls the interceptors in order, the last one calls handler
+func runChain(chain []grpc.UnaryServerInterceptor, ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if len(chain) == 0 {
+		return handler(ctx, req)
+	}
+	return chain[0](ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return runChain(chain[1:], ctx, req, info, handler)
+	})
+}
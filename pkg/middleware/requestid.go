@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RequestIDHeader carries the request ID on HTTP requests and responses, lower cased in gRPC metadata
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client supplied request IDs
+const maxRequestIDLength = 128
+
+type requestInfoKey struct{}
+
+// requestInfo identifies a request and where it came from
+type requestInfo struct {
+	id       string
+	clientIP string
+}
+
+// RequestID tags every request with an ID, reusing a well-formed X-Request-ID from the client,
+// and echoes it in the response
+func RequestID() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := requestID(r.Header.Get(RequestIDHeader))
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := context.WithValue(r.Context(), requestInfoKey{}, requestInfo{id: id, clientIP: hostOnly(r.RemoteAddr)})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GRPCRequestID tags every call with an ID, reusing x-request-id from the metadata,
+// and returns it in the response header
+func GRPCRequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var supplied string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(RequestIDHeader); len(v) > 0 {
+				supplied = v[0]
+			}
+		}
+		id := requestID(supplied)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+		if entry := accessEntryFromContext(ctx); entry != nil {
+			entry.requestID = id
+		}
+
+		var clientIP string
+		if p, ok := peer.FromContext(ctx); ok {
+			clientIP = hostOnly(p.Addr.String())
+		}
+
+		return handler(context.WithValue(ctx, requestInfoKey{}, requestInfo{id: id, clientIP: clientIP}), req)
+	}
+}
+
+// RequestIDFromContext returns the ID of the request, empty outside of RequestID and GRPCRequestID
+func RequestIDFromContext(ctx context.Context) string {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	return info.id
+}
+
+// ClientIPFromContext returns the address the request came from, without the port
+func ClientIPFromContext(ctx context.Context) string {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	return info.clientIP
+}
+
+// requestID returns supplied when it is safe to log and echo, otherwise a new random ID
+func requestID(supplied string) string {
+	if supplied != "" && len(supplied) <= maxRequestIDLength && printable(supplied) {
+		return supplied
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// hostOnly strips the port from addr
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
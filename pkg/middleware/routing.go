@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+type routingHeadersKey struct{}
+
+// RoutingHeaders keeps the request headers in the context, so upstream clients can route
+// HTTP requests on headers as they do gRPC calls on metadata
+func RoutingHeaders() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), routingHeadersKey{}, r.Header)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RoutingHeader returns the named HTTP request header, or else the incoming gRPC metadata value
+func RoutingHeader(ctx context.Context, name string) string {
+	if h, ok := ctx.Value(routingHeadersKey{}).(http.Header); ok {
+		return h.Get(name)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(name); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/kannan112/gateway-structure/pkg/validation"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// gRPC Validation interceptor
+func GRPCValidator(v *validation.Validator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if msg, ok := req.(proto.Message); ok {
+			if err := v.Validate(msg); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// gRPC stream Validation interceptor, checks every message the client sends
+func GRPCStreamValidator(v *validation.Validator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingServerStream{ServerStream: ss, validator: v})
+	}
+}
+
+// validatingServerStream rejects received messages that break a validation rule
+type validatingServerStream struct {
+	grpc.ServerStream
+	validator *validation.Validator
+}
+
+func (s *validatingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if msg, ok := m.(proto.Message); ok {
+		return s.validator.Validate(msg)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kannan112/gateway-structure/pkg/validation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// recvStream hands out msg on RecvMsg
+type recvStream struct {
+	grpc.ServerStream
+	msg proto.Message
+}
+
+func (s *recvStream) Context() context.Context { return context.Background() }
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	proto.Merge(m.(proto.Message), s.msg)
+	return nil
+}
+
+func TestGRPCStreamValidator(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  proto.Message
+		want codes.Code
+	}{
+		{name: "valid", msg: &userpb.CreateUserRequest{User: &userpb.User{Username: "jdoe", Email: "jdoe@example.com"}, Password: "Hunter22!pass"}, want: codes.OK},
+		{name: "invalid", msg: &userpb.CreateUserRequest{User: &userpb.User{Username: "jdoe", Email: "not-an-email"}, Password: "Hunter22!pass"}, want: codes.InvalidArgument},
+	}
+	interceptor := GRPCStreamValidator(validation.Default())
+	for _, tt := range tests {
+		err := interceptor(nil, &recvStream{msg: tt.msg}, &grpc.StreamServerInfo{}, func(srv interface{}, ss grpc.ServerStream) error {
+			return ss.RecvMsg(&userpb.CreateUserRequest{})
+		})
+		if status.Code(err) != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kannan112/gateway-structure/pkg/wasm"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// WASMFilter runs a WebAssembly filter on requests. Request bodies are only read and
+// responses only buffered when the filter has hooks for them, so filters inspecting
+// responses must not be put on streaming routes.
+func WASMFilter(f *wasm.Filter, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			call, err := f.Begin(ctx)
+			if err != nil {
+				wasmFailed(w, r, next, f, logger, err)
+				return
+			}
+			defer call.End(ctx)
+
+			local, err := call.OnRequestHeaders(ctx, wasm.HTTPRequestHeaders(r), !f.FiltersRequestBody())
+			if err != nil {
+				wasmFailed(w, r, next, f, logger, err)
+				return
+			}
+			if local != nil {
+				writeLocalResponse(w, local)
+				return
+			}
+
+			if f.FiltersRequestBody() {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					var maxBytesErr *http.MaxBytesError
+					if errors.As(err, &maxBytesErr) {
+						http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+						return
+					}
+					http.Error(w, "Failed to read request body", http.StatusBadRequest)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+
+				filtered, local, err := call.OnRequestBody(ctx, body)
+				if err != nil {
+					wasmFailed(w, r, next, f, logger, err)
+					return
+				}
+				if local != nil {
+					writeLocalResponse(w, local)
+					return
+				}
+				setBody(r, filtered)
+			}
+
+			if !f.FiltersResponse() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buf := newBufferedResponseWriter(w)
+			next.ServeHTTP(buf, r)
+
+			headers := wasm.HTTPResponseHeaders(buf.header, buf.status)
+			local, err = call.OnResponseHeaders(ctx, headers, !f.FiltersResponseBody())
+			body := buf.body.Bytes()
+			if err == nil && local == nil && f.FiltersResponseBody() {
+				body, local, err = call.OnResponseBody(ctx, body)
+			}
+			switch {
+			case err != nil && !f.FailOpen():
+				logger.Error("WASM filter failed", zap.String("filter", f.Name()), zap.Error(err))
+				http.Error(w, "Request filter failed", http.StatusInternalServerError)
+			case err != nil:
+				logger.Warn("WASM filter failed, passing the response unfiltered", zap.String("filter", f.Name()), zap.Error(err))
+				buf.flush(buf.body.Bytes())
+			case local != nil:
+				writeLocalResponse(w, local)
+			default:
+				buf.flush(body)
+			}
+		})
+	}
+}
+
+// GRPCWASMFilter runs a WebAssembly filter on the metadata of unary calls. Response
+// metadata starts empty, the values the filter adds are sent as header metadata.
+func GRPCWASMFilter(f *wasm.Filter, logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		call, err := f.Begin(ctx)
+		if err != nil {
+			return grpcWASMFailed(ctx, req, handler, f, logger, err)
+		}
+		defer call.End(ctx)
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		local, err := call.OnRequestHeaders(ctx, wasm.Metadata(md, info.FullMethod), true)
+		if err != nil {
+			return grpcWASMFailed(ctx, req, handler, f, logger, err)
+		}
+		if local != nil {
+			return nil, localStatus(local)
+		}
+
+		ctx = metadata.NewIncomingContext(ctx, md)
+		resp, err := handler(ctx, req)
+		if !f.FiltersResponse() {
+			return resp, err
+		}
+
+		header := metadata.MD{}
+		local, ferr := call.OnResponseHeaders(ctx, wasm.Metadata(header, ""), true)
+		switch {
+		case ferr != nil && !f.FailOpen():
+			logger.Error("WASM filter failed", zap.String("filter", f.Name()), zap.Error(ferr))
+			return nil, status.Error(codes.Internal, "request filter failed")
+		case ferr != nil:
+			logger.Warn("WASM filter failed, passing the response unfiltered", zap.String("filter", f.Name()), zap.Error(ferr))
+		case local != nil:
+			return nil, localStatus(local)
+		case len(header) > 0:
+			grpc.SetHeader(ctx, header)
+		}
+		return resp, err
+	}
+}
+
+// wasmFailed rejects the request, or passes it on unfiltered when the filter fails open
+func wasmFailed(w http.ResponseWriter, r *http.Request, next http.Handler, f *wasm.Filter, logger *zap.Logger, err error) {
+	if !f.FailOpen() {
+		logger.Error("WASM filter failed", zap.String("filter", f.Name()), zap.Error(err))
+		http.Error(w, "Request filter failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Warn("WASM filter failed, passing the request unfiltered", zap.String("filter", f.Name()), zap.Error(err))
+	next.ServeHTTP(w, r)
+}
+
+func grpcWASMFailed(ctx context.Context, req interface{}, handler grpc.UnaryHandler, f *wasm.Filter, logger *zap.Logger, err error) (interface{}, error) {
+	if !f.FailOpen() {
+		logger.Error("WASM filter failed", zap.String("filter", f.Name()), zap.Error(err))
+		return nil, status.Error(codes.Internal, "request filter failed")
+	}
+	logger.Warn("WASM filter failed, passing the request unfiltered", zap.String("filter", f.Name()), zap.Error(err))
+	return handler(ctx, req)
+}
+
+// setBody replaces the request body with the one the filter left
+func setBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if r.Header.Get("Content-Length") != "" {
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+}
+
+// writeLocalResponse writes the response a filter sent instead of the upstream's
+func writeLocalResponse(w http.ResponseWriter, local *wasm.LocalResponse) {
+	code := local.Status
+	if code < 100 || code > 599 {
+		code = http.StatusForbidden
+	}
+	for _, h := range local.Header {
+		w.Header().Add(h[0], h[1])
+	}
+	w.WriteHeader(code)
+	w.Write(local.Body)
+}
+
+// localStatus turns a filter's local response into a gRPC status
+func localStatus(local *wasm.LocalResponse) error {
+	code := httpToCode(local.Status)
+	if local.GRPCStatus >= 0 && local.GRPCStatus <= int32(codes.Unauthenticated) {
+		code = codes.Code(local.GRPCStatus)
+	}
+	msg := local.Details
+	if msg == "" {
+		msg = string(local.Body)
+	}
+	if msg == "" {
+		msg = "rejected by request filter"
+	}
+	return status.Error(code, msg)
+}
+
+func httpToCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.PermissionDenied
+}
+
+// bufferedResponseWriter holds the response back so a filter can change it before it is sent
+type bufferedResponseWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter(w http.ResponseWriter) *bufferedResponseWriter {
+	return &bufferedResponseWriter{w: w, header: w.Header().Clone(), status: http.StatusOK}
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(code int) {
+	b.status = code
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// flush sends the buffered status and headers with body
+func (b *bufferedResponseWriter) flush(body []byte) {
+	h := b.w.Header()
+	for name := range h {
+		delete(h, name)
+	}
+	for name, values := range b.header {
+		h[name] = values
+	}
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	b.w.WriteHeader(b.status)
+	b.w.Write(body)
+}
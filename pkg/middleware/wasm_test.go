@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kannan112/gateway-structure/pkg/wasm"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newTestFilter loads the test filter of pkg/wasm, see its filter.wat for what it does per header
+func newTestFilter(t *testing.T, failOpen bool) *wasm.Filter {
+	t.Helper()
+	f, err := wasm.NewFilter(context.Background(), wasm.Config{
+		Name:     "test",
+		Path:     "../wasm/testdata/filter.wasm",
+		Timeout:  "20ms",
+		FailOpen: failOpen,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close(context.Background()) })
+	return f
+}
+
+func TestWASMFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		failOpen bool
+		want     int
+		body     string
+	}{
+		{name: "passes", want: http.StatusOK, body: "upstream"},
+		{name: "local response", header: "x-deny", want: http.StatusForbidden, body: "denied"},
+		{name: "timeout fails closed", header: "x-loop", want: http.StatusInternalServerError, body: "Request filter failed\n"},
+		{name: "timeout fails open", header: "x-loop", failOpen: true, want: http.StatusOK, body: "upstream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := WASMFilter(newTestFilter(t, tt.failOpen), zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("upstream"))
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, "1")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want || w.Body.String() != tt.body {
+				t.Fatalf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.want, tt.body)
+			}
+		})
+	}
+}
+
+func TestGRPCWASMFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		failOpen bool
+		want     codes.Code
+	}{
+		{name: "passes", want: codes.OK},
+		{name: "local response", header: "x-deny", want: codes.PermissionDenied},
+		{name: "timeout fails closed", header: "x-loop", want: codes.Internal},
+		{name: "timeout fails open", header: "x-loop", failOpen: true, want: codes.OK},
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "upstream", nil
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interceptor := GRPCWASMFilter(newTestFilter(t, tt.failOpen), zap.NewNop())
+
+			md := metadata.MD{}
+			if tt.header != "" {
+				md.Set(tt.header, "1")
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+			_, err := interceptor(ctx, nil, info, handler)
+			if status.Code(err) != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == codes.PermissionDenied && status.Convert(err).Message() != "denied" {
+				t.Fatalf("expected the filter's details as message, got %q", status.Convert(err).Message())
+			}
+		})
+	}
+}
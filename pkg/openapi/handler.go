@@ -0,0 +1,58 @@
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+//go:embed swagger.html
+var swaggerHTML string
+
+// swaggerUI holds the swagger-ui-dist 5.18.2 assets the page needs, so the docs work offline
+//
+//go:embed swagger-ui
+var swaggerUI embed.FS
+
+// Handler serves the document built by build as JSON. The document is built on the
+// first request, once all routes have been registered.
+func Handler(build func() Document) http.Handler {
+	var (
+		once sync.Once
+		data []byte
+		err  error
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			data, err = json.MarshalIndent(build(), "", "  ")
+		})
+		if err != nil {
+			http.Error(w, "Failed to build OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+// UIHandler serves a Swagger UI page that loads the document from specURL and the
+// assets served by AssetsHandler from assetsURL
+func UIHandler(specURL, assetsURL string) http.Handler {
+	page := strings.NewReplacer("{{SPEC_URL}}", specURL, "{{ASSETS_URL}}", assetsURL).Replace(swaggerHTML)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+}
+
+// AssetsHandler serves the vendored Swagger UI scripts and styles, with paths relative to its mount point
+func AssetsHandler() http.Handler {
+	assets, _ := fs.Sub(swaggerUI, "swagger-ui")
+	return http.FileServer(http.FS(assets))
+}
@@ -0,0 +1,263 @@
+package openapi
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Operation describes one HTTP route. Routes bound to an RPC carry its request and
+// response descriptors, other routes are documented from the route table alone.
+type Operation struct {
+	Method   string
+	Path     string // gorilla/mux template, e.g. /api/v1/users/{id}
+	Summary  string
+	Tag      string
+	Request  protoreflect.MessageDescriptor // nil for routes without a proto binding
+	Response protoreflect.MessageDescriptor
+	// PathFields maps path variables onto request fields, e.g. "id" -> "user_id"
+	PathFields map[string]string
+	// Body names the request field sent as the body, "" sends the whole request
+	Body string
+	// Streaming responses are sent as server-sent events of the response message
+	Streaming bool
+	Secured   bool
+}
+
+// Document is an OpenAPI 3 document, kept as plain maps so it marshals as-is
+type Document map[string]interface{}
+
+type generator struct {
+	schemas map[string]interface{}
+}
+
+// Generate builds an OpenAPI 3.0 document for the given operations
+func Generate(title, version string, ops []Operation) Document {
+	g := &generator{schemas: make(map[string]interface{})}
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range ops {
+		path := stripPattern(op.Path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(op.Method)] = g.operation(op)
+	}
+
+	return Document{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKeyAuth": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+func (g *generator) operation(op Operation) map[string]interface{} {
+	operation := map[string]interface{}{
+		"summary":   op.Summary,
+		"responses": g.responses(op),
+	}
+	if op.Tag != "" {
+		operation["tags"] = []string{op.Tag}
+	}
+	if op.Secured {
+		operation["security"] = []map[string][]string{{"bearerAuth": {}}, {"apiKeyAuth": {}}}
+	}
+
+	var params []map[string]interface{}
+	bound := make(map[string]bool)
+	for _, name := range pathVars(op.Path) {
+		schema := map[string]interface{}{"type": "string"}
+		if op.Request != nil {
+			if field, ok := op.PathFields[name]; ok {
+				bound[strings.Split(field, ".")[0]] = true
+			}
+		}
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": schema,
+		})
+	}
+
+	if op.Request != nil {
+		switch op.Method {
+		case "POST", "PUT", "PATCH":
+			body := op.Request
+			if fd := op.Request.Fields().ByName(protoreflect.Name(op.Body)); fd != nil && fd.Message() != nil {
+				body = fd.Message()
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.ref(body)},
+				},
+			}
+		default:
+			// Scalar request fields that aren't bound to the path become query parameters
+			fields := op.Request.Fields()
+			for i := 0; i < fields.Len(); i++ {
+				fd := fields.Get(i)
+				if bound[string(fd.Name())] || fd.Kind() == protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+					continue
+				}
+				params = append(params, map[string]interface{}{
+					"name": string(fd.Name()), "in": "query", "schema": g.field(fd),
+				})
+			}
+		}
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+	return operation
+}
+
+func (g *generator) responses(op Operation) map[string]interface{} {
+	success := map[string]interface{}{"description": "OK"}
+	if op.Response != nil {
+		contentType := "application/json"
+		if op.Streaming {
+			contentType = "text/event-stream"
+		}
+		success["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": g.ref(op.Response)},
+		}
+	}
+
+	code := "200"
+	if op.Method == "POST" && op.Response != nil {
+		code = "201"
+	}
+	responses := map[string]interface{}{
+		code:      success,
+		"default": map[string]interface{}{"description": "Error", "content": errorContent},
+	}
+	return responses
+}
+
+var errorContent = map[string]interface{}{
+	"application/json": map[string]interface{}{
+		"schema": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+		},
+	},
+}
+
+// ref registers the message schema under components and returns a reference to it
+func (g *generator) ref(md protoreflect.MessageDescriptor) map[string]interface{} {
+	name := string(md.FullName())
+	if wkt := wellKnown(md); wkt != nil {
+		return wkt
+	}
+
+	if _, ok := g.schemas[name]; !ok {
+		// Register before walking fields so recursive messages terminate
+		g.schemas[name] = nil
+		g.schemas[name] = g.message(md)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (g *generator) message(md protoreflect.MessageDescriptor) map[string]interface{} {
+	properties := make(map[string]interface{})
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		properties[fd.JSONName()] = g.field(fd)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+func (g *generator) field(fd protoreflect.FieldDescriptor) interface{} {
+	var schema map[string]interface{}
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		schema = map[string]interface{}{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		schema = map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// protojson encodes 64-bit integers as strings
+		schema = map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		schema = map[string]interface{}{"type": "number"}
+	case protoreflect.BytesKind:
+		schema = map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		schema = map[string]interface{}{"type": "string", "enum": names}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		schema = g.ref(fd.Message())
+	default:
+		schema = map[string]interface{}{"type": "string"}
+	}
+
+	if fd.IsList() {
+		return map[string]interface{}{"type": "array", "items": schema}
+	}
+	if fd.IsMap() {
+		return map[string]interface{}{"type": "object", "additionalProperties": g.field(fd.MapValue())}
+	}
+	return schema
+}
+
+// wellKnown returns inline schemas for well-known types that protojson encodes specially
+func wellKnown(md protoreflect.MessageDescriptor) map[string]interface{} {
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration":
+		return map[string]interface{}{"type": "string", "example": "1.5s"}
+	case "google.protobuf.FieldMask":
+		return map[string]interface{}{"type": "string", "example": "first_name,last_name"}
+	case "google.protobuf.Struct", "google.protobuf.Value":
+		return map[string]interface{}{"type": "object"}
+	}
+	return nil
+}
+
+var pathVar = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// pathVars returns the variable names of a mux path template in order
+func pathVars(path string) []string {
+	var vars []string
+	for _, m := range pathVar.FindAllStringSubmatch(path, -1) {
+		vars = append(vars, m[1])
+	}
+	return vars
+}
+
+// stripPattern removes mux regexp patterns, {id:[0-9]+} becomes {id}
+func stripPattern(path string) string {
+	return pathVar.ReplaceAllString(path, "{$1}")
+}
+
+// SortOperations orders operations by path and method for stable output
+func SortOperations(ops []Operation) {
+	sort.SliceStable(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return ops[i].Method < ops[j].Method
+	})
+}
@@ -0,0 +1,223 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Mode says how a value is redacted
+type Mode string
+
+const (
+	// Mask replaces the value with Placeholder
+	Mask Mode = "mask"
+	// Hash replaces the value with a keyed hash, equal values keep matching across logs
+	Hash Mode = "hash"
+	// Keep leaves the value as is, it lifts a rule of an earlier rule set
+	Keep Mode = "keep"
+)
+
+// Placeholder replaces masked values
+const Placeholder = "[REDACTED]"
+
+// Rules maps full proto field names such as "user.User.email" to how their values are redacted.
+// Fields annotated with [debug_redact = true] are masked without a rule.
+type Rules map[string]Mode
+
+// DefaultRules redact the personal data of the user service
+var DefaultRules = Rules{
+	"user.User.email":        Hash,
+	"user.User.phone_number": Hash,
+	"user.User.first_name":   Mask,
+	"user.User.last_name":    Mask,
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+\d[\d ().-]{6,}\d`)
+)
+
+// Redactor removes personal data from proto messages and free text before they are logged
+type Redactor struct {
+	rules Rules
+	key   []byte
+}
+
+// New creates a new Redactor hashing with key. Later rule sets win. Without a key a random one
+// is used, hashes then only match within this process.
+func New(key []byte, ruleSets ...Rules) *Redactor {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+
+	merged := make(Rules)
+	for _, rules := range ruleSets {
+		for field, mode := range rules {
+			merged[field] = mode
+		}
+	}
+	return &Redactor{rules: merged, key: key}
+}
+
+// LoadRules reads a JSON rule file in the same shape as Rules
+func LoadRules(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction rules %s: %v", path, err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction rules %s: %v", path, err)
+	}
+	for field, mode := range rules {
+		if mode != Mask && mode != Hash && mode != Keep {
+			return nil, fmt.Errorf("unknown redaction mode %q for %s", mode, field)
+		}
+	}
+	return rules, nil
+}
+
+var defaultRedactor = New(nil, DefaultRules)
+
+// Default returns the redactor used by the package level functions
+func Default() *Redactor {
+	return defaultRedactor
+}
+
+// SetDefault replaces the redactor returned by Default
+func SetDefault(r *Redactor) {
+	defaultRedactor = r
+}
+
+// Text redacts s with the default redactor
+func Text(s string) string {
+	return defaultRedactor.Text(s)
+}
+
+// Error returns a zap error field with the message redacted by the default redactor
+func Error(err error) zap.Field {
+	return defaultRedactor.Error(err)
+}
+
+// Proto returns a zap field holding msg redacted by the default redactor
+func Proto(key string, msg proto.Message) zap.Field {
+	return defaultRedactor.Proto(key, msg)
+}
+
+// Value redacts a single value
+func (r *Redactor) Value(mode Mode, s string) string {
+	switch mode {
+	case Keep:
+		return s
+	case Hash:
+		if s == "" {
+			return s
+		}
+		mac := hmac.New(sha256.New, r.key)
+		mac.Write([]byte(s))
+		return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	default:
+		return Placeholder
+	}
+}
+
+// Text hashes e-mail addresses and phone numbers found in s, as the matching User fields are
+func (r *Redactor) Text(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(v string) string { return r.Value(Hash, v) })
+	return phonePattern.ReplaceAllStringFunc(s, func(v string) string { return r.Value(Hash, v) })
+}
+
+// Error returns a zap error field with a redacted message
+func (r *Redactor) Error(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.String("error", r.Text(err.Error()))
+}
+
+// Proto returns a zap field holding msg redacted and encoded as protojson
+func (r *Redactor) Proto(key string, msg proto.Message) zap.Field {
+	data, err := protojson.Marshal(r.Message(msg))
+	if err != nil {
+		return zap.String(key, Placeholder)
+	}
+	return zap.Reflect(key, json.RawMessage(data))
+}
+
+// Message returns a copy of msg with every redacted field replaced, msg itself is left alone
+func (r *Redactor) Message(msg proto.Message) proto.Message {
+	if msg == nil {
+		return nil
+	}
+	clone := proto.Clone(msg)
+	r.redact(clone.ProtoReflect())
+	return clone
+}
+
+func (r *Redactor) redact(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if mode, ok := r.mode(fd); ok {
+			r.redactField(m, fd, v, mode)
+			return true
+		}
+
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				r.redact(list.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				r.redact(mv.Message())
+				return true
+			})
+		case fd.Message() != nil && !fd.IsMap():
+			r.redact(v.Message())
+		}
+		return true
+	})
+}
+
+// mode returns how fd is redacted, annotated fields are always masked
+func (r *Redactor) mode(fd protoreflect.FieldDescriptor) (Mode, bool) {
+	if opts, ok := fd.Options().(*descriptorpb.FieldOptions); ok && opts.GetDebugRedact() {
+		return Mask, true
+	}
+	mode, ok := r.rules[string(fd.FullName())]
+	if !ok || mode == Keep {
+		return "", false
+	}
+	return mode, true
+}
+
+// redactField replaces string values and clears anything else
+func (r *Redactor) redactField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value, mode Mode) {
+	if fd.Kind() != protoreflect.StringKind || fd.IsMap() {
+		m.Clear(fd)
+		return
+	}
+
+	if fd.IsList() {
+		list := v.List()
+		for i := 0; i < list.Len(); i++ {
+			list.Set(i, protoreflect.ValueOfString(r.Value(mode, list.Get(i).String())))
+		}
+		return
+	}
+	m.Set(fd, protoreflect.ValueOfString(r.Value(mode, v.String())))
+}
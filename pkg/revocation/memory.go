@@ -0,0 +1,95 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps revocations in process memory
+type MemoryStore struct {
+	tokens map[string]time.Time // jti -> token expiry
+	users  map[string]time.Time // user ID -> revoked before
+	mu     sync.RWMutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemoryStore creates a new MemoryStore that drops stale entries every cleanupInterval
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+		stop:   make(chan struct{}),
+	}
+	go s.cleanup(cleanupInterval)
+	return s
+}
+
+func (s *MemoryStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) RevokeUser(ctx context.Context, userID string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.users[userID]; !ok || before.After(current) {
+		s.users[userID] = before
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if jti != "" {
+		if _, ok := s.tokens[jti]; ok {
+			return true, nil
+		}
+	}
+	if before, ok := s.users[userID]; ok && userID != "" {
+		// Tokens without an issue time can't prove they are newer than the revocation
+		if issuedAt.IsZero() || !issuedAt.After(before) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Close stops the cleanup goroutine
+func (s *MemoryStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+func (s *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for jti, exp := range s.tokens {
+				if now.After(exp) {
+					delete(s.tokens, jti)
+				}
+			}
+			for id, before := range s.users {
+				if now.Sub(before) > MaxTokenLifetime {
+					delete(s.users, id)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
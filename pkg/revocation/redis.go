@@ -0,0 +1,93 @@
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tokenKeyPrefix = "revoked:jti:"
+	userKeyPrefix  = "revoked:user:"
+)
+
+// revokeUserScript only moves the timestamp forward so concurrent revocations can't undo each other
+var revokeUserScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if tonumber(ARGV[1]) > current then
+  redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+end
+return 1`)
+
+// RedisConfig holds connection settings for any Redis protocol compatible server
+type RedisConfig struct {
+	Address  string
+	Password string
+	DB       int
+}
+
+// RedisStore keeps revocations in Redis so they are shared by all gateway instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new RedisStore and checks the connection
+func NewRedisStore(config RedisConfig) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Address,
+		Password: config.Password,
+		DB:       config.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %v", config.Address, err)
+	}
+
+	return &RedisStore{client: client}, nil
+}
+
+func (s *RedisStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, tokenKeyPrefix+jti, 1, ttl).Err()
+}
+
+func (s *RedisStore) RevokeUser(ctx context.Context, userID string, before time.Time) error {
+	key := userKeyPrefix + userID
+	return revokeUserScript.Run(ctx, s.client, []string{key}, before.Unix(), int(MaxTokenLifetime.Seconds())).Err()
+}
+
+func (s *RedisStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	values, err := s.client.MGet(ctx, tokenKeyPrefix+jti, userKeyPrefix+userID).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if jti != "" && values[0] != nil {
+		return true, nil
+	}
+	if userID != "" && values[1] != nil {
+		before, err := strconv.ParseInt(values[1].(string), 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid user revocation for %s: %v", userID, err)
+		}
+		if issuedAt.IsZero() || issuedAt.Unix() <= before {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Close closes the Redis connection
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
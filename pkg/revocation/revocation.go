@@ -0,0 +1,22 @@
+package revocation
+
+import (
+	"context"
+	"time"
+)
+
+// Store records revoked tokens by jti and revoked users by a "revoked before" timestamp
+type Store interface {
+	// RevokeToken denylists a single token until it would have expired anyway
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+
+	// RevokeUser invalidates every token of the user issued before the given time
+	RevokeUser(ctx context.Context, userID string, before time.Time) error
+
+	// IsRevoked reports whether a token with the given jti, subject and issue time was revoked
+	IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
+}
+
+// MaxTokenLifetime bounds how long user revocations are kept,
+// no token issued before a revocation can outlive it
+var MaxTokenLifetime = 30 * 24 * time.Hour
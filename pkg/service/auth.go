@@ -7,14 +7,18 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
-	"google.golang.org/grpc/credentials/insecure"
 
+	"github.com/kannan112/gateway-structure/pkg/circuit"
+	"github.com/kannan112/gateway-structure/pkg/concurrency"
+	"github.com/kannan112/gateway-structure/pkg/identity"
 	authpb "github.com/kannan112/gateway-structure/pkg/proto/auth"
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
 )
 
 // AuthService defines the interface for authentication operations
 type AuthService interface {
 	authpb.AuthServiceServer
+	Upstream
 	Close() error
 }
 
@@ -22,14 +26,23 @@ type AuthService interface {
 type AuthServiceConfig struct {
 	Address string
 	Timeout time.Duration
+	TLS     tlsutil.Config
+	// Identity signs internal tokens for outgoing calls, nil forwards plain identity metadata only
+	Identity *identity.Signer
+	// Concurrency adapts the calls in flight to the upstream's latency
+	Concurrency concurrency.Config
+	// Circuit stops calls to the upstream after consecutive failures
+	Circuit circuit.Config
 }
 
 // authServiceServer implements AuthService interface
 type authServiceServer struct {
 	authpb.UnimplementedAuthServiceServer
+	*upstream
 	client  authpb.AuthServiceClient
 	conn    *grpc.ClientConn
 	timeout time.Duration
+	tls     *tlsutil.Reloader
 }
 
 // NewAuthService creates a new instance of AuthService
@@ -43,20 +56,40 @@ func NewAuthService(config AuthServiceConfig) (AuthService, error) {
 		config.Timeout = 30 * time.Second
 	}
 
+	limiter, err := concurrency.New(config.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	breaker := circuit.New(config.Circuit)
+
+	creds, reloader, err := transportCredentials(config.TLS, config.Address)
+	if err != nil {
+		return nil, err
+	}
+
 	// Context with timeout for connection
 	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
 	defer cancel()
 
 	// Connection options
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		creds,
 		grpc.WithBlock(),
 		grpc.WithReturnConnectionError(), // This helps with more detailed error messages
+		grpc.WithChainUnaryInterceptor(
+			circuit.UnaryClientInterceptor(breaker, "auth", breakerResult),
+			concurrency.UnaryClientInterceptor(limiter, "auth", callPriority),
+			identity.UnaryClientInterceptor(config.Identity),
+		),
+		grpc.WithChainStreamInterceptor(identity.StreamClientInterceptor(config.Identity)),
 	}
 
 	// Establish gRPC connection
 	conn, err := grpc.DialContext(ctx, config.Address, opts...)
 	if err != nil {
+		if reloader != nil {
+			reloader.Stop()
+		}
 		return nil, fmt.Errorf("failed to connect to auth service at %s: %v", config.Address, err)
 	}
 
@@ -64,13 +97,18 @@ func NewAuthService(config AuthServiceConfig) (AuthService, error) {
 	state := conn.GetState()
 	if state != connectivity.Ready {
 		conn.Close()
+		if reloader != nil {
+			reloader.Stop()
+		}
 		return nil, fmt.Errorf("connection not ready, current state: %v", state)
 	}
 
 	return &authServiceServer{
-		client:  authpb.NewAuthServiceClient(conn),
-		conn:    conn,
-		timeout: config.Timeout,
+		upstream: &upstream{name: "auth", address: config.Address, conn: conn, limiter: limiter, breaker: breaker},
+		client:   authpb.NewAuthServiceClient(conn),
+		conn:     conn,
+		timeout:  config.Timeout,
+		tls:      reloader,
 	}, nil
 }
 
@@ -85,6 +123,9 @@ func (s *authServiceServer) ensureConnection(ctx context.Context) error {
 
 // Close closes the gRPC connection
 func (s *authServiceServer) Close() error {
+	if s.tls != nil {
+		s.tls.Stop()
+	}
 	if s.conn != nil {
 		return s.conn.Close()
 	}
@@ -0,0 +1,477 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// CanaryConfig splits user service traffic between named subsets, e.g. the current and the next version
+type CanaryConfig struct {
+	Subsets   []SubsetConfig `json:"subsets"`
+	Overrides []Override     `json:"overrides,omitempty"`
+}
+
+// SubsetConfig is one version of the user service and its share of the traffic
+type SubsetConfig struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Weight  int    `json:"weight"`
+}
+
+// Override sends matching requests to Subset regardless of the weights. Header matches a
+// request header or gRPC metadata key, any value unless Value is set. Role matches the
+// caller's role. When both are set both must match.
+type Override struct {
+	Header string `json:"header,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Role   string `json:"role,omitempty"`
+	Subset string `json:"subset"`
+}
+
+// LoadCanaryConfig reads a JSON canary config
+func LoadCanaryConfig(path string) (CanaryConfig, error) {
+	var config CanaryConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read user service subsets %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse user service subsets %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// SubsetRouter is implemented by upstreams that split traffic between subsets
+type SubsetRouter interface {
+	// Subsets returns the subsets as upstreams, draining one moves its traffic to the others
+	Subsets() []Upstream
+	SubsetStats() []SubsetStats
+	// SetWeights changes the traffic split, subsets left out keep their weight
+	SetWeights(weights map[string]int) error
+}
+
+// SubsetStats compares the subsets of a split. Errors only count server side failures.
+type SubsetStats struct {
+	Name          string  `json:"name"`
+	Address       string  `json:"address"`
+	Weight        int     `json:"weight"`
+	Draining      bool    `json:"draining"`
+	Requests      uint64  `json:"requests"`
+	Overridden    uint64  `json:"overridden"`
+	Errors        uint64  `json:"errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	MeanLatencyMs float64 `json:"mean_latency_ms"`
+	P50LatencyMs  float64 `json:"p50_latency_ms"`
+	P99LatencyMs  float64 `json:"p99_latency_ms"`
+}
+
+// latencyBuckets are the upper bounds of the latency histogram of each subset
+var latencyBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// subset is a user service client with its weight and counters
+type subset struct {
+	name    string
+	service UserService
+	weight  atomic.Int64
+
+	requests   atomic.Uint64
+	overridden atomic.Uint64
+	errors     atomic.Uint64
+	latency    atomic.Int64 // total, nanoseconds
+	buckets    []atomic.Uint64
+}
+
+// canaryRouter implements UserService by sending every call to one of its subsets
+type canaryRouter struct {
+	userpb.UnimplementedUserServiceServer
+	subsets   []*subset
+	byName    map[string]*subset
+	overrides []Override
+	mu        sync.Mutex // serializes SetWeights
+}
+
+// NewCanaryUserService connects to every subset with the settings of base and routes calls
+// between them by weight. The split is sticky per caller user ID.
+func NewCanaryUserService(base UserServiceConfig, config CanaryConfig) (UserService, error) {
+	if len(config.Subsets) == 0 {
+		return nil, fmt.Errorf("no user service subsets configured")
+	}
+
+	r := &canaryRouter{byName: make(map[string]*subset), overrides: config.Overrides}
+	var total int
+	for _, sc := range config.Subsets {
+		switch {
+		case sc.Name == "" || sc.Address == "":
+			return nil, r.closeWith(fmt.Errorf("user service subsets need a name and an address"))
+		case r.byName[sc.Name] != nil:
+			return nil, r.closeWith(fmt.Errorf("duplicate user service subset %s", sc.Name))
+		case sc.Weight < 0:
+			return nil, r.closeWith(fmt.Errorf("negative weight for user service subset %s", sc.Name))
+		}
+
+		conf := base
+		conf.Name = "user." + sc.Name
+		conf.Address = sc.Address
+		svc, err := NewUserService(conf)
+		if err != nil {
+			return nil, r.closeWith(fmt.Errorf("subset %s: %v", sc.Name, err))
+		}
+
+		s := &subset{name: sc.Name, service: svc, buckets: make([]atomic.Uint64, len(latencyBuckets)+1)}
+		s.weight.Store(int64(sc.Weight))
+		r.subsets = append(r.subsets, s)
+		r.byName[sc.Name] = s
+		total += sc.Weight
+	}
+	if total == 0 {
+		return nil, r.closeWith(fmt.Errorf("user service subsets have no weight"))
+	}
+
+	for _, o := range config.Overrides {
+		if o.Header == "" && o.Role == "" {
+			return nil, r.closeWith(fmt.Errorf("override to subset %s matches neither a header nor a role", o.Subset))
+		}
+		if r.byName[o.Subset] == nil {
+			return nil, r.closeWith(fmt.Errorf("override to unknown subset %s", o.Subset))
+		}
+	}
+	return r, nil
+}
+
+// closeWith closes the subsets connected so far and returns err
+func (r *canaryRouter) closeWith(err error) error {
+	r.Close()
+	return err
+}
+
+// route picks the subset for a call and returns the function recording its outcome
+func (r *canaryRouter) route(ctx context.Context) (*subset, func(error)) {
+	s, overridden := r.pick(ctx)
+	s.requests.Add(1)
+	if overridden {
+		s.overridden.Add(1)
+	}
+
+	start := time.Now()
+	return s, func(err error) {
+		s.observe(time.Since(start), err)
+	}
+}
+
+// pick returns the subset of the first matching override, or else a subset chosen by
+// weight from a hash of the caller's user ID, at random for anonymous callers.
+// Draining subsets are skipped.
+func (r *canaryRouter) pick(ctx context.Context) (*subset, bool) {
+	for _, o := range r.overrides {
+		if s := r.byName[o.Subset]; o.matches(ctx) && !s.service.Draining() {
+			return s, true
+		}
+	}
+
+	var total int64
+	for _, s := range r.subsets {
+		if !s.service.Draining() {
+			total += s.weight.Load()
+		}
+	}
+	if total == 0 {
+		// Every weighted subset drains, fall back to one without weight before rejecting the call
+		for _, s := range r.subsets {
+			if !s.service.Draining() {
+				return s, false
+			}
+		}
+		return r.subsets[0], false
+	}
+
+	var point int64
+	if claims, ok := middleware.ClaimsFromContext(ctx); ok && claims.UserID != "" {
+		h := fnv.New64a()
+		h.Write([]byte(claims.UserID))
+		point = int64(h.Sum64() % uint64(total))
+	} else {
+		point = rand.Int63n(total)
+	}
+
+	for _, s := range r.subsets {
+		if s.service.Draining() {
+			continue
+		}
+		if point -= s.weight.Load(); point < 0 {
+			return s, false
+		}
+	}
+	return r.subsets[len(r.subsets)-1], false
+}
+
+// matches reports whether the call carries the header and role of the override
+func (o Override) matches(ctx context.Context) bool {
+	if o.Header != "" {
+		v := middleware.RoutingHeader(ctx, o.Header)
+		if v == "" || (o.Value != "" && !strings.EqualFold(v, o.Value)) {
+			return false
+		}
+	}
+	if o.Role != "" {
+		claims, ok := middleware.ClaimsFromContext(ctx)
+		if !ok || claims.Role != o.Role {
+			return false
+		}
+	}
+	return true
+}
+
+// observe records the latency and outcome of a call
+func (s *subset) observe(d time.Duration, err error) {
+	if serverError(err) {
+		s.errors.Add(1)
+	}
+	s.latency.Add(int64(d))
+
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	s.buckets[i].Add(1)
+}
+
+// serverError reports whether err is a failure of the upstream rather than of the request
+func serverError(err error) bool {
+	switch status.Code(err) {
+	case codes.OK, codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange:
+		return false
+	}
+	return true
+}
+
+func (s *subset) stats() SubsetStats {
+	st := SubsetStats{
+		Name:       s.name,
+		Address:    s.service.Address(),
+		Weight:     int(s.weight.Load()),
+		Draining:   s.service.Draining(),
+		Requests:   s.requests.Load(),
+		Overridden: s.overridden.Load(),
+		Errors:     s.errors.Load(),
+	}
+
+	if st.Requests > 0 {
+		st.ErrorRate = float64(st.Errors) / float64(st.Requests)
+	}
+
+	counts := make([]uint64, len(s.buckets))
+	var observed uint64
+	for i := range s.buckets {
+		counts[i] = s.buckets[i].Load()
+		observed += counts[i]
+	}
+	if observed == 0 {
+		return st
+	}
+	st.MeanLatencyMs = float64(s.latency.Load()) / float64(observed) / float64(time.Millisecond)
+	st.P50LatencyMs = percentile(counts, observed, 0.50)
+	st.P99LatencyMs = percentile(counts, observed, 0.99)
+	return st
+}
+
+// percentile returns the upper bound in milliseconds of the bucket holding the q quantile,
+// -1 when it falls beyond the last bound
+func percentile(counts []uint64, observed uint64, q float64) float64 {
+	rank := uint64(q * float64(observed))
+	var seen uint64
+	for i, n := range counts {
+		seen += n
+		if seen > rank {
+			if i == len(latencyBuckets) {
+				return -1
+			}
+			return float64(latencyBuckets[i]) / float64(time.Millisecond)
+		}
+	}
+	return -1
+}
+
+// Subsets returns the subset clients as upstreams
+func (r *canaryRouter) Subsets() []Upstream {
+	upstreams := make([]Upstream, len(r.subsets))
+	for i, s := range r.subsets {
+		upstreams[i] = s.service
+	}
+	return upstreams
+}
+
+func (r *canaryRouter) related() []Upstream {
+	return r.Subsets()
+}
+
+// SubsetStats returns the counters of every subset
+func (r *canaryRouter) SubsetStats() []SubsetStats {
+	stats := make([]SubsetStats, len(r.subsets))
+	for i, s := range r.subsets {
+		stats[i] = s.stats()
+	}
+	return stats
+}
+
+// SetWeights changes the traffic split. Nothing changes when a subset is unknown or
+// the weights would add up to zero.
+func (r *canaryRouter) SetWeights(weights map[string]int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total int
+	for _, s := range r.subsets {
+		w, ok := weights[s.name]
+		if !ok {
+			w = int(s.weight.Load())
+		}
+		if w < 0 {
+			return fmt.Errorf("negative weight for subset %s", s.name)
+		}
+		total += w
+	}
+	for name := range weights {
+		if r.byName[name] == nil {
+			return fmt.Errorf("unknown subset %s", name)
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("subsets would have no weight")
+	}
+
+	for name, w := range weights {
+		r.byName[name].weight.Store(int64(w))
+	}
+	return nil
+}
+
+func (r *canaryRouter) Name() string {
+	return "user"
+}
+
+// Address lists the subsets as name=address
+func (r *canaryRouter) Address() string {
+	addrs := make([]string, len(r.subsets))
+	for i, s := range r.subsets {
+		addrs[i] = s.name + "=" + s.service.Address()
+	}
+	return strings.Join(addrs, ",")
+}
+
+// State is Ready when every subset is, otherwise the state of the first one that isn't
+func (r *canaryRouter) State() connectivity.State {
+	for _, s := range r.subsets {
+		if st := s.service.State(); st != connectivity.Ready {
+			return st
+		}
+	}
+	return connectivity.Ready
+}
+
+// SetDraining drains or restores every subset
+func (r *canaryRouter) SetDraining(draining bool) {
+	for _, s := range r.subsets {
+		s.service.SetDraining(draining)
+	}
+}
+
+// Draining reports whether every subset is draining
+func (r *canaryRouter) Draining() bool {
+	for _, s := range r.subsets {
+		if !s.service.Draining() {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes every subset
+func (r *canaryRouter) Close() error {
+	var first error
+	for _, s := range r.subsets {
+		if err := s.service.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (r *canaryRouter) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.CreateUser(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (r *canaryRouter) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.GetUser(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (r *canaryRouter) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.UpdateUser(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (r *canaryRouter) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.DeleteUser(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (r *canaryRouter) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.ListUsers(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (r *canaryRouter) BatchGetUsers(ctx context.Context, req *userpb.BatchGetUsersRequest) (*userpb.BatchGetUsersResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.BatchGetUsers(ctx, req)
+	done(err)
+	return resp, err
+}
+
+func (r *canaryRouter) BatchUpdateUserStatus(ctx context.Context, req *userpb.BatchUpdateUserStatusRequest) (*userpb.BatchUpdateUserStatusResponse, error) {
+	s, done := r.route(ctx)
+	resp, err := s.service.BatchUpdateUserStatus(ctx, req)
+	done(err)
+	return resp, err
+}
+
+// WatchUsers relays the stream of one subset. Streams count as requests and errors, their
+// lifetime would distort the latencies.
+func (r *canaryRouter) WatchUsers(req *userpb.WatchUsersRequest, stream userpb.UserService_WatchUsersServer) error {
+	s, _ := r.route(stream.Context())
+	err := s.service.WatchUsers(req, stream)
+	if serverError(err) {
+		s.errors.Add(1)
+	}
+	return err
+}
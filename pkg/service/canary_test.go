@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// stubService is a subset client that is never called
+type stubService struct {
+	userpb.UnimplementedUserServiceServer
+	name     string
+	draining atomic.Bool
+}
+
+func (s *stubService) Name() string              { return s.name }
+func (s *stubService) Address() string           { return s.name + ":9090" }
+func (s *stubService) State() connectivity.State { return connectivity.Ready }
+func (s *stubService) SetDraining(d bool)        { s.draining.Store(d) }
+func (s *stubService) Draining() bool            { return s.draining.Load() }
+func (s *stubService) Close() error              { return nil }
+
+// newTestRouter splits traffic between stub subsets named a, b, ... with the given weights
+func newTestRouter(overrides []Override, weights ...int) *canaryRouter {
+	r := &canaryRouter{byName: make(map[string]*subset), overrides: overrides}
+	for i, w := range weights {
+		name := string(rune('a' + i))
+		s := &subset{name: name, service: &stubService{name: name}, buckets: make([]atomic.Uint64, len(latencyBuckets)+1)}
+		s.weight.Store(int64(w))
+		r.subsets = append(r.subsets, s)
+		r.byName[name] = s
+	}
+	return r
+}
+
+func userContext(id, role string) context.Context {
+	return middleware.ContextWithClaims(context.Background(), &middleware.Claims{UserID: id, Role: role})
+}
+
+func TestPickIsStickyPerUser(t *testing.T) {
+	r := newTestRouter(nil, 50, 50)
+
+	picked := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		s, overridden := r.pick(userContext(id, "user"))
+		if overridden {
+			t.Fatal("no override is configured")
+		}
+		picked[id] = s.name
+		counts[s.name]++
+	}
+	if counts["a"] < 60 || counts["b"] < 60 {
+		t.Fatalf("50/50 split is lopsided: %v", counts)
+	}
+	for id, name := range picked {
+		if s, _ := r.pick(userContext(id, "user")); s.name != name {
+			t.Fatalf("%s moved from subset %s to %s", id, name, s.name)
+		}
+	}
+
+	// Draining moves every caller to the other subset, restoring it brings them back
+	r.byName["a"].service.SetDraining(true)
+	for id := range picked {
+		if s, _ := r.pick(userContext(id, "user")); s.name != "b" {
+			t.Fatalf("%s was sent to draining subset %s", id, s.name)
+		}
+	}
+	r.byName["a"].service.SetDraining(false)
+	for id, name := range picked {
+		if s, _ := r.pick(userContext(id, "user")); s.name != name {
+			t.Fatalf("%s didn't return to subset %s after draining", id, name)
+		}
+	}
+}
+
+func TestPickWithoutWeight(t *testing.T) {
+	r := newTestRouter(nil, 100, 0)
+
+	for i := 0; i < 20; i++ {
+		if s, _ := r.pick(userContext(fmt.Sprintf("user-%d", i), "user")); s.name != "a" {
+			t.Fatalf("subset %s without weight picked", s.name)
+		}
+	}
+
+	// With every weighted subset draining, a subset without weight takes the traffic
+	r.byName["a"].service.SetDraining(true)
+	if s, _ := r.pick(context.Background()); s.name != "b" {
+		t.Fatalf("got subset %s, want the undrained b", s.name)
+	}
+}
+
+func TestPickOverrides(t *testing.T) {
+	r := newTestRouter([]Override{
+		{Header: "x-canary", Value: "always", Subset: "b"},
+		{Role: "admin", Subset: "c"},
+	}, 100, 0, 0)
+
+	header := func(ctx context.Context, value string) context.Context {
+		return metadata.NewIncomingContext(ctx, metadata.Pairs("x-canary", value))
+	}
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		want       string
+		overridden bool
+	}{
+		{name: "header value", ctx: header(userContext("u1", "user"), "ALWAYS"), want: "b", overridden: true},
+		{name: "other header value", ctx: header(userContext("u1", "user"), "never"), want: "a"},
+		{name: "role", ctx: userContext("u1", "admin"), want: "c", overridden: true},
+		{name: "first override wins", ctx: header(userContext("u1", "admin"), "always"), want: "b", overridden: true},
+		{name: "no match", ctx: userContext("u1", "user"), want: "a"},
+	}
+	for _, tt := range tests {
+		s, overridden := r.pick(tt.ctx)
+		if s.name != tt.want || overridden != tt.overridden {
+			t.Errorf("%s: got subset %s overridden %v, want %s %v", tt.name, s.name, overridden, tt.want, tt.overridden)
+		}
+	}
+
+	// Overrides to a draining subset fall through to the next one, then to the weights
+	r.byName["b"].service.SetDraining(true)
+	if s, _ := r.pick(header(userContext("u1", "admin"), "always")); s.name != "c" {
+		t.Fatalf("got subset %s, want c past draining b", s.name)
+	}
+	if s, overridden := r.pick(header(userContext("u1", "user"), "always")); s.name != "a" || overridden {
+		t.Fatalf("got subset %s overridden %v, want a by weight", s.name, overridden)
+	}
+}
+
+func TestSetWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		want    []int
+		wantErr bool
+	}{
+		{name: "shift traffic", weights: map[string]int{"a": 10, "b": 90}, want: []int{10, 90}},
+		{name: "others keep their weight", weights: map[string]int{"b": 30}, want: []int{90, 30}},
+		{name: "one subset at zero", weights: map[string]int{"a": 0}, want: []int{0, 10}},
+		{name: "unknown subset", weights: map[string]int{"a": 50, "c": 50}, wantErr: true},
+		{name: "negative weight", weights: map[string]int{"a": -1}, wantErr: true},
+		{name: "no weight left", weights: map[string]int{"a": 0, "b": 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		r := newTestRouter(nil, 90, 10)
+		err := r.SetWeights(tt.weights)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			// Rejected changes leave every weight as it was
+			tt.want = []int{90, 10}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		for i, s := range r.subsets {
+			if got := int(s.weight.Load()); got != tt.want[i] {
+				t.Errorf("%s: subset %s has weight %d, want %d", tt.name, s.name, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	counts := make([]uint64, len(latencyBuckets)+1)
+	counts[0] = 50 // up to 1ms
+	counts[3] = 49 // up to 10ms
+	counts[6] = 1  // up to 100ms
+
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{q: 0, want: 1},
+		{q: 0.49, want: 1},
+		{q: 0.50, want: 10},
+		{q: 0.98, want: 10},
+		{q: 0.99, want: 100},
+	}
+	for _, tt := range tests {
+		if got := percentile(counts, 100, tt.q); got != tt.want {
+			t.Errorf("q%v: got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	counts[len(latencyBuckets)] = 100
+	if got := percentile(counts, 200, 0.99); got != -1 {
+		t.Errorf("quantile past the last bucket: got %v, want -1", got)
+	}
+}
+
+func TestSubsetStats(t *testing.T) {
+	r := newTestRouter([]Override{{Role: "admin", Subset: "a"}}, 100)
+	s := r.byName["a"]
+
+	outcomes := []error{
+		nil, nil,
+		status.Error(codes.NotFound, "no such user"),
+		status.Error(codes.Unavailable, "connection refused"),
+	}
+	for i, err := range outcomes {
+		ctx := userContext("u1", "user")
+		if i == 0 {
+			ctx = userContext("u1", "admin")
+		}
+		r.route(ctx)
+		s.observe(time.Duration(i+1)*3*time.Millisecond, err)
+	}
+
+	stats := r.SubsetStats()[0]
+	want := SubsetStats{
+		Name:          "a",
+		Address:       "a:9090",
+		Weight:        100,
+		Requests:      4,
+		Overridden:    1,
+		Errors:        1,
+		ErrorRate:     0.25,
+		MeanLatencyMs: 7.5,
+		P50LatencyMs:  10,
+		P99LatencyMs:  25,
+	}
+	if stats != want {
+		t.Fatalf("got %+v\nwant %+v", stats, want)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/kannan112/gateway-structure/pkg/concurrency"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+// maxDiffs bounds the differing fields logged per mirrored call
+const maxDiffs = 20
+
+// mirrorable are the methods safe to send twice
+var mirrorable = map[string]bool{"GetUser": true, "ListUsers": true}
+
+// MirrorConfig copies a sample of read calls to a shadow user service and compares the responses
+type MirrorConfig struct {
+	Address string
+	// Methods maps GetUser and ListUsers to the percentage of their calls that are mirrored
+	Methods map[string]float64
+	// Timeout bounds every shadow call on its own, the primary call never waits for it
+	Timeout time.Duration
+	// MaxConcurrent caps shadow calls in flight, calls beyond it aren't mirrored
+	MaxConcurrent int
+}
+
+// MirrorStats counts mirrored calls. Dropped calls were sampled while MaxConcurrent calls were in flight.
+type MirrorStats struct {
+	Address  string `json:"address"`
+	Mirrored uint64 `json:"mirrored"`
+	Dropped  uint64 `json:"dropped"`
+	Diffs    uint64 `json:"diffs"`
+}
+
+// Mirrored is implemented by user services that mirror calls to a shadow
+type Mirrored interface {
+	MirrorStats() MirrorStats
+}
+
+// mirroredUserService serves every call from the primary and copies sampled reads to the shadow
+type mirroredUserService struct {
+	UserService
+	shadow UserService
+	config MirrorConfig
+	logger *zap.Logger
+	sem    chan struct{}
+
+	mirrored atomic.Uint64
+	dropped  atomic.Uint64
+	diffs    atomic.Uint64
+}
+
+// NewMirroredUserService connects to the shadow at config.Address with the settings of base
+// and mirrors the configured methods of primary to it
+func NewMirroredUserService(primary UserService, base UserServiceConfig, config MirrorConfig, logger *zap.Logger) (UserService, error) {
+	for method, rate := range config.Methods {
+		if !mirrorable[method] {
+			return nil, fmt.Errorf("%s can't be mirrored, only GetUser and ListUsers", method)
+		}
+		if rate < 0 || rate > 100 {
+			return nil, fmt.Errorf("mirror percentage of %s must be between 0 and 100", method)
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * time.Second
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 16
+	}
+
+	conf := base
+	conf.Name = "user.mirror"
+	conf.Address = config.Address
+	conf.Timeout = config.Timeout
+	shadow, err := NewUserService(conf)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: %v", err)
+	}
+
+	return &mirroredUserService{
+		UserService: primary,
+		shadow:      shadow,
+		config:      config,
+		logger:      logger,
+		sem:         make(chan struct{}, config.MaxConcurrent),
+	}, nil
+}
+
+func (m *mirroredUserService) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	resp, err := m.UserService.GetUser(ctx, req)
+	m.mirror(ctx, "GetUser", resp, err, func(ctx context.Context) (proto.Message, error) {
+		return m.shadow.GetUser(ctx, req)
+	})
+	return resp, err
+}
+
+func (m *mirroredUserService) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	resp, err := m.UserService.ListUsers(ctx, req)
+	m.mirror(ctx, "ListUsers", resp, err, func(ctx context.Context) (proto.Message, error) {
+		return m.shadow.ListUsers(ctx, req)
+	})
+	return resp, err
+}
+
+// mirror calls the shadow in the background when the call is sampled and a slot is free.
+// The primary response is cloned first, later interceptors may still change it.
+func (m *mirroredUserService) mirror(ctx context.Context, method string, primary proto.Message, primaryErr error, call func(context.Context) (proto.Message, error)) {
+	rate := m.config.Methods[method]
+	if rate <= 0 || rand.Float64()*100 >= rate || m.shadow.Draining() {
+		return
+	}
+	select {
+	case m.sem <- struct{}{}:
+	default:
+		m.dropped.Add(1)
+		return
+	}
+	m.mirrored.Add(1)
+
+	if primaryErr == nil {
+		primary = proto.Clone(primary)
+	}
+	// The shadow call keeps the caller's identity but not the primary's deadline or cancellation,
+	// and yields to other traffic of the shadow when it is saturated
+	ctx = concurrency.WithPriority(context.WithoutCancel(ctx), concurrency.Low)
+	go func() {
+		defer func() { <-m.sem }()
+		start := time.Now()
+		shadow, err := call(ctx)
+		m.compare(ctx, method, primary, primaryErr, shadow, err, time.Since(start))
+	}()
+}
+
+// compare logs the shadow response when its status or fields differ from the primary's.
+// Only field paths are logged, never values.
+func (m *mirroredUserService) compare(ctx context.Context, method string, primary proto.Message, primaryErr error, shadow proto.Message, shadowErr error, latency time.Duration) {
+	fields := []zap.Field{
+		zap.String("method", method),
+		zap.String("request_id", middleware.RequestIDFromContext(ctx)),
+		zap.Duration("shadow_latency", latency),
+	}
+
+	primaryCode, shadowCode := status.Code(primaryErr), status.Code(shadowErr)
+	switch {
+	case primaryCode != shadowCode:
+		m.diffs.Add(1)
+		m.logger.Warn("Mirror response differs", append(fields,
+			zap.Stringer("primary_code", primaryCode),
+			zap.Stringer("shadow_code", shadowCode),
+		)...)
+	case primaryCode == codes.OK:
+		diffs := diffFields(primary.ProtoReflect(), shadow.ProtoReflect(), "", nil)
+		if len(diffs) == 0 {
+			m.logger.Debug("Mirror response matches", fields...)
+			return
+		}
+		m.diffs.Add(1)
+		m.logger.Warn("Mirror response differs", append(fields, zap.Strings("fields", diffs))...)
+	}
+}
+
+// diffFields appends the paths of the fields that differ between a and b, list elements
+// are compared one by one
+func diffFields(a, b protoreflect.Message, prefix string, diffs []string) []string {
+	fields := a.Descriptor().Fields()
+	for i := 0; i < fields.Len() && len(diffs) < maxDiffs; i++ {
+		fd := fields.Get(i)
+		path := prefix + string(fd.Name())
+		va, vb := a.Get(fd), b.Get(fd)
+
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			la, lb := va.List(), vb.List()
+			if la.Len() != lb.Len() {
+				diffs = append(diffs, path+".length")
+				continue
+			}
+			for j := 0; j < la.Len() && len(diffs) < maxDiffs; j++ {
+				diffs = diffFields(la.Get(j).Message(), lb.Get(j).Message(), path+"["+strconv.Itoa(j)+"].", diffs)
+			}
+		case fd.Message() != nil && !fd.IsMap():
+			if a.Has(fd) != b.Has(fd) {
+				diffs = append(diffs, path)
+			} else if a.Has(fd) {
+				diffs = diffFields(va.Message(), vb.Message(), path+".", diffs)
+			}
+		case !va.Equal(vb):
+			diffs = append(diffs, path)
+		}
+	}
+	return diffs
+}
+
+// MirrorStats returns the mirror counters
+func (m *mirroredUserService) MirrorStats() MirrorStats {
+	return MirrorStats{
+		Address:  m.shadow.Address(),
+		Mirrored: m.mirrored.Load(),
+		Dropped:  m.dropped.Load(),
+		Diffs:    m.diffs.Load(),
+	}
+}
+
+// Unwrap returns the primary user service
+func (m *mirroredUserService) Unwrap() UserService {
+	return m.UserService
+}
+
+// related adds the shadow, draining it pauses mirroring
+func (m *mirroredUserService) related() []Upstream {
+	return append(Related(m.UserService), m.shadow)
+}
+
+// Close closes the shadow, the primary is closed by its owner
+func (m *mirroredUserService) Close() error {
+	return m.shadow.Close()
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+func TestDiffFields(t *testing.T) {
+	user := func(edit func(*userpb.User)) *userpb.User {
+		u := &userpb.User{
+			Id:        "u1",
+			Username:  "ada",
+			Email:     "ada@example.com",
+			Status:    userpb.UserStatus_USER_STATUS_ACTIVE,
+			Roles:     []string{"user"},
+			CreatedAt: &timestamppb.Timestamp{Seconds: 1700000000},
+		}
+		if edit != nil {
+			edit(u)
+		}
+		return u
+	}
+	users := func(n int, edit func(int, *userpb.User)) *userpb.ListUsersResponse {
+		resp := &userpb.ListUsersResponse{TotalCount: int32(n)}
+		for i := 0; i < n; i++ {
+			u := user(nil)
+			if edit != nil {
+				edit(i, u)
+			}
+			resp.Users = append(resp.Users, u)
+		}
+		return resp
+	}
+	capped := make([]string, maxDiffs)
+	for i := range capped {
+		capped[i] = fmt.Sprintf("users[%d].username", i)
+	}
+
+	tests := []struct {
+		name string
+		a, b proto.Message
+		want []string
+	}{
+		{name: "equal", a: &userpb.GetUserResponse{User: user(nil)}, b: &userpb.GetUserResponse{User: user(nil)}},
+		{name: "scalar", a: &userpb.GetUserResponse{User: user(nil)},
+			b:    &userpb.GetUserResponse{User: user(func(u *userpb.User) { u.Email = "ada@example.org" })},
+			want: []string{"user.email"}},
+		{name: "enum and repeated scalar", a: &userpb.GetUserResponse{User: user(nil)},
+			b: &userpb.GetUserResponse{User: user(func(u *userpb.User) {
+				u.Status = userpb.UserStatus_USER_STATUS_SUSPENDED
+				u.Roles = append(u.Roles, "admin")
+			})},
+			want: []string{"user.status", "user.roles"}},
+		{name: "nested message", a: &userpb.GetUserResponse{User: user(nil)},
+			b:    &userpb.GetUserResponse{User: user(func(u *userpb.User) { u.CreatedAt.Seconds++ })},
+			want: []string{"user.created_at.seconds"}},
+		{name: "missing message", a: &userpb.GetUserResponse{User: user(nil)},
+			b:    &userpb.GetUserResponse{},
+			want: []string{"user"}},
+		{name: "list length", a: users(2, nil), b: users(3, nil),
+			want: []string{"users.length", "total_count"}},
+		{name: "list element", a: users(3, nil),
+			b: users(3, func(i int, u *userpb.User) {
+				if i == 1 {
+					u.Username = "grace"
+				}
+			}),
+			want: []string{"users[1].username"}},
+		{name: "capped", a: users(30, nil),
+			b: users(30, func(i int, u *userpb.User) {
+				u.Username = fmt.Sprintf("user-%d", i)
+			}),
+			want: capped},
+	}
+	for _, tt := range tests {
+		got := diffFields(tt.a.ProtoReflect(), tt.b.ProtoReflect(), "", nil)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
+)
+
+// tlsReloadInterval is how often upstream certificate files are checked for changes
+const tlsReloadInterval = 30 * time.Second
+
+// transportCredentials returns the dial option for an upstream's TLS settings.
+// The returned reloader is nil for cleartext upstreams and must be stopped on Close.
+func transportCredentials(config tlsutil.Config, address string) (grpc.DialOption, *tlsutil.Reloader, error) {
+	if !config.Enabled() {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil, nil
+	}
+
+	reloader, err := tlsutil.NewReloader(config, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load upstream TLS files: %v", err)
+	}
+
+	tlsConfig, err := reloader.ClientConfig(address)
+	if err != nil {
+		reloader.Stop()
+		return nil, nil, fmt.Errorf("invalid upstream TLS config: %v", err)
+	}
+	reloader.Watch(tlsReloadInterval)
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), reloader, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+
+	"github.com/kannan112/gateway-structure/pkg/circuit"
+	"github.com/kannan112/gateway-structure/pkg/concurrency"
+	"github.com/kannan112/gateway-structure/pkg/middleware"
+)
+
+// Upstream exposes connection state and draining control of an upstream client
+type Upstream interface {
+	Name() string
+	Address() string
+	State() connectivity.State
+	// SetDraining stops new calls from being sent upstream while true
+	SetDraining(draining bool)
+	Draining() bool
+}
+
+// upstream implements Upstream for a gRPC client connection
+type upstream struct {
+	name     string
+	address  string
+	conn     *grpc.ClientConn
+	draining atomic.Bool
+	limiter  *concurrency.Limiter
+	breaker  *circuit.Breaker
+}
+
+func (u *upstream) Name() string {
+	return u.name
+}
+
+func (u *upstream) Address() string {
+	return u.address
+}
+
+func (u *upstream) State() connectivity.State {
+	return u.conn.GetState()
+}
+
+func (u *upstream) SetDraining(draining bool) {
+	u.draining.Store(draining)
+}
+
+func (u *upstream) Draining() bool {
+	return u.draining.Load()
+}
+
+// ConcurrencyStats returns the state of the upstream's concurrency limiter, nil when it is off
+func (u *upstream) ConcurrencyStats() *concurrency.Stats {
+	if u.limiter == nil {
+		return nil
+	}
+	stats := u.limiter.Stats()
+	return &stats
+}
+
+// CircuitStats returns the state of the upstream's circuit breaker
+func (u *upstream) CircuitStats() circuit.Stats {
+	return u.breaker.Stats()
+}
+
+// CircuitBreaker is implemented by upstreams behind a circuit breaker
+type CircuitBreaker interface {
+	CircuitStats() circuit.Stats
+}
+
+// breakerResult counts errors that point at an unhealthy upstream as failures. Calls shed by the
+// concurrency limiter or canceled by the caller never reached it and aren't counted.
+func breakerResult(err error) circuit.Result {
+	if errors.Is(err, concurrency.ErrShed) {
+		return circuit.Ignored
+	}
+	switch status.Code(err) {
+	case codes.Canceled:
+		return circuit.Ignored
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss, codes.ResourceExhausted:
+		return circuit.Failure
+	}
+	return circuit.Success
+}
+
+// Limited is implemented by upstreams with an adaptive concurrency limit
+type Limited interface {
+	ConcurrencyStats() *concurrency.Stats
+}
+
+// callPriority puts admin callers ahead of other traffic when an upstream is saturated
+func callPriority(ctx context.Context) concurrency.Priority {
+	if middleware.RoleFromContext(ctx) == "admin" {
+		return concurrency.Critical
+	}
+	return concurrency.Normal
+}
+
+// checkDraining rejects calls to an upstream that is being drained
+func (u *upstream) checkDraining() error {
+	if u.draining.Load() {
+		return status.Errorf(codes.Unavailable, "%s is draining", u.name)
+	}
+	return nil
+}
+
+// composite is implemented by upstreams made of others, such as canary subsets and mirrors
+type composite interface {
+	related() []Upstream
+}
+
+// Related returns the upstreams behind u that can be drained on their own
+func Related(u Upstream) []Upstream {
+	if c, ok := u.(composite); ok {
+		return c.related()
+	}
+	return nil
+}
+
+// AsSubsetRouter returns the subset router u is or wraps
+func AsSubsetRouter(u Upstream) (SubsetRouter, bool) {
+	for {
+		if router, ok := u.(SubsetRouter); ok {
+			return router, true
+		}
+		w, ok := u.(interface{ Unwrap() UserService })
+		if !ok {
+			return nil, false
+		}
+		u = w.Unwrap()
+	}
+}
@@ -3,32 +3,60 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
+	"github.com/kannan112/gateway-structure/pkg/circuit"
+	"github.com/kannan112/gateway-structure/pkg/concurrency"
+	"github.com/kannan112/gateway-structure/pkg/identity"
 	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+	"github.com/kannan112/gateway-structure/pkg/tlsutil"
 )
 
 // UserService defines the interface for user operations
 type UserService interface {
 	userpb.UserServiceServer
+	Upstream
 	Close() error
 }
 
 // userServiceServer implements UserService interface
 type userServiceServer struct {
 	userpb.UnimplementedUserServiceServer // Embed the unimplemented server
-	client                                userpb.UserServiceClient
-	conn                                  *grpc.ClientConn
-	timeout                               time.Duration
+	*upstream
+	client  userpb.UserServiceClient
+	conn    *grpc.ClientConn
+	timeout time.Duration
+	tls     *tlsutil.Reloader
+
+	batchConcurrency int
+	// Set once the upstream answers a batch RPC with Unimplemented, later calls fan out right away
+	batchGetUnsupported    atomic.Bool
+	batchUpdateUnsupported atomic.Bool
 }
 
 // UserServiceConfig holds configuration for the user service client
 type UserServiceConfig struct {
+	// Name identifies the upstream in the admin API, "user" when empty
+	Name    string
 	Address string
 	Timeout time.Duration
+	TLS     tlsutil.Config
+	// Identity signs internal tokens for outgoing calls, nil forwards plain identity metadata only
+	Identity *identity.Signer
+	// BatchConcurrency bounds the single-user calls a batch RPC fans out to when the upstream lacks it
+	BatchConcurrency int
+	// Concurrency adapts the unary calls in flight to the upstream's latency, streams aren't limited
+	Concurrency concurrency.Config
+	// Circuit stops calls to the upstream after consecutive failures
+	Circuit circuit.Config
 }
 
 // NewUserService creates a new instance of UserService
@@ -37,26 +65,65 @@ func NewUserService(config UserServiceConfig) (UserService, error) {
 	if config.Timeout == 0 {
 		config.Timeout = 30 * time.Second
 	}
+	if config.BatchConcurrency <= 0 {
+		config.BatchConcurrency = 8
+	}
+	if config.Name == "" {
+		config.Name = "user"
+	}
+
+	limiter, err := concurrency.New(config.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	breaker := circuit.New(config.Circuit)
+
+	creds, reloader, err := transportCredentials(config.TLS, config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Context with timeout for connection
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
 
 	// Establish gRPC connection
-	conn, err := grpc.Dial(
+	conn, err := grpc.DialContext(
+		ctx,
 		config.Address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		creds,
 		grpc.WithBlock(),
+		grpc.WithChainUnaryInterceptor(
+			circuit.UnaryClientInterceptor(breaker, config.Name, breakerResult),
+			concurrency.UnaryClientInterceptor(limiter, config.Name, callPriority),
+			identity.UnaryClientInterceptor(config.Identity),
+		),
+		grpc.WithChainStreamInterceptor(identity.StreamClientInterceptor(config.Identity)),
 	)
 	if err != nil {
+		if reloader != nil {
+			reloader.Stop()
+		}
 		return nil, fmt.Errorf("failed to connect to user service: %v", err)
 	}
 
 	return &userServiceServer{
-		client:  userpb.NewUserServiceClient(conn),
-		conn:    conn,
-		timeout: config.Timeout,
+		upstream: &upstream{name: config.Name, address: config.Address, conn: conn, limiter: limiter, breaker: breaker},
+		client:   userpb.NewUserServiceClient(conn),
+		conn:     conn,
+		timeout:  config.Timeout,
+		tls:      reloader,
+
+		batchConcurrency: config.BatchConcurrency,
 	}, nil
 }
 
 // CreateUser implements the user creation operation
 func (s *userServiceServer) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
@@ -65,6 +132,10 @@ func (s *userServiceServer) CreateUser(ctx context.Context, req *userpb.CreateUs
 
 // GetUser implements the get user operation
 func (s *userServiceServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
@@ -73,6 +144,10 @@ func (s *userServiceServer) GetUser(ctx context.Context, req *userpb.GetUserRequ
 
 // UpdateUser implements the user update operation
 func (s *userServiceServer) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
@@ -81,6 +156,10 @@ func (s *userServiceServer) UpdateUser(ctx context.Context, req *userpb.UpdateUs
 
 // DeleteUser implements the user deletion operation
 func (s *userServiceServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
@@ -89,14 +168,166 @@ func (s *userServiceServer) DeleteUser(ctx context.Context, req *userpb.DeleteUs
 
 // ListUsers implements the list users operation
 func (s *userServiceServer) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
 	return s.client.ListUsers(ctx, req)
 }
 
+// WatchUsers relays the upstream event stream until either side ends it.
+// Events are filtered by status here too in case the upstream ignores the filter.
+func (s *userServiceServer) WatchUsers(req *userpb.WatchUsersRequest, stream userpb.UserService_WatchUsersServer) error {
+	if err := s.checkDraining(); err != nil {
+		return err
+	}
+
+	// No call timeout, the stream lives as long as the client keeps it open
+	upstream, err := s.client.WatchUsers(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		event, err := upstream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !matchesStatus(event, req.Statuses) {
+			continue
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+}
+
+// matchesStatus reports whether event concerns a user in one of statuses.
+// Events without a user status, such as deletions, always match.
+func matchesStatus(event *userpb.UserEvent, statuses []userpb.UserStatus) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	current := event.GetUser().GetStatus()
+	if current == userpb.UserStatus_USER_STATUS_UNSPECIFIED {
+		return true
+	}
+	for _, st := range statuses {
+		if st == current {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchGetUsers uses the upstream batch RPC, or fans out to GetUser if the upstream doesn't implement it
+func (s *userServiceServer) BatchGetUsers(ctx context.Context, req *userpb.BatchGetUsersRequest) (*userpb.BatchGetUsersResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
+	if !s.batchGetUnsupported.Load() {
+		callCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		resp, err := s.client.BatchGetUsers(callCtx, req)
+		cancel()
+		if status.Code(err) != codes.Unimplemented {
+			return resp, err
+		}
+		s.batchGetUnsupported.Store(true)
+	}
+
+	results := s.fanOut(ctx, req.UserIds, func(ctx context.Context, id string) (*userpb.User, error) {
+		resp, err := s.client.GetUser(ctx, &userpb.GetUserRequest{UserId: id})
+		return resp.GetUser(), err
+	})
+	return &userpb.BatchGetUsersResponse{Results: results, FailureCount: failureCount(results)}, nil
+}
+
+// BatchUpdateUserStatus uses the upstream batch RPC, or fans out to UpdateUser with a status-only mask
+func (s *userServiceServer) BatchUpdateUserStatus(ctx context.Context, req *userpb.BatchUpdateUserStatusRequest) (*userpb.BatchUpdateUserStatusResponse, error) {
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+
+	if !s.batchUpdateUnsupported.Load() {
+		callCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		resp, err := s.client.BatchUpdateUserStatus(callCtx, req)
+		cancel()
+		if status.Code(err) != codes.Unimplemented {
+			return resp, err
+		}
+		s.batchUpdateUnsupported.Store(true)
+	}
+
+	results := s.fanOut(ctx, req.UserIds, func(ctx context.Context, id string) (*userpb.User, error) {
+		resp, err := s.client.UpdateUser(ctx, &userpb.UpdateUserRequest{
+			User:       &userpb.User{Id: id, Status: req.Status},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		return resp.GetUser(), err
+	})
+	return &userpb.BatchUpdateUserStatusResponse{Results: results, FailureCount: failureCount(results)}, nil
+}
+
+// fanOut calls call for every ID with at most batchConcurrency calls in flight.
+// Results keep the order of ids, failures are reported per item.
+func (s *userServiceServer) fanOut(ctx context.Context, ids []string, call func(context.Context, string) (*userpb.User, error)) []*userpb.UserResult {
+	results := make([]*userpb.UserResult, len(ids))
+	sem := make(chan struct{}, s.batchConcurrency)
+	var wg sync.WaitGroup
+
+	for i, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			callCtx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			user, err := call(callCtx, id)
+			results[i] = userResult(id, user, err)
+		}(i, id)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func userResult(id string, user *userpb.User, err error) *userpb.UserResult {
+	if err != nil {
+		st := status.Convert(err)
+		return &userpb.UserResult{
+			UserId: id,
+			Result: &userpb.UserResult_Error{Error: &userpb.ResultError{Code: int32(st.Code()), Message: st.Message()}},
+		}
+	}
+	return &userpb.UserResult{UserId: id, Result: &userpb.UserResult_User{User: user}}
+}
+
+func failureCount(results []*userpb.UserResult) int32 {
+	var n int32
+	for _, r := range results {
+		if r.GetError() != nil {
+			n++
+		}
+	}
+	return n
+}
+
 // Close closes the gRPC connection
 func (s *userServiceServer) Close() error {
+	if s.tls != nil {
+		s.tls.Stop()
+	}
 	if s.conn != nil {
 		return s.conn.Close()
 	}
@@ -0,0 +1,337 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Config holds TLS settings shared by listeners and upstream connections
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	CAFile       string   // client CAs for listeners, root CAs for upstreams
+	ServerName   string   // upstreams only, overrides the name used for verification
+	MinVersion   string   // "1.2" or "1.3", defaults to 1.2
+	CipherSuites []string // IANA names, ignored for TLS 1.3
+	ClientAuth   string   // listeners only: "", "request", "verify-if-given" or "require"
+
+	// SystemRoots turns on TLS for upstreams that are verified against the system roots
+	SystemRoots bool
+}
+
+// Enabled reports whether TLS is configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.CAFile != "" || c.SystemRoots
+}
+
+// Reloader keeps certificates and CA bundles in sync with the files on disk
+type Reloader struct {
+	config Config
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime map[string]time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReloader loads the configured files once, call Watch to pick up later changes.
+// A nil logger falls back to the global zap logger.
+func NewReloader(config Config, logger *zap.Logger) (*Reloader, error) {
+	if logger == nil {
+		logger = zap.L()
+	}
+
+	r := &Reloader{
+		config:  config,
+		logger:  logger,
+		modTime: make(map[string]time.Time),
+		stop:    make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Watch polls the files every interval and reloads them when they change
+func (r *Reloader) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.load(); err != nil {
+					// Keep serving the previous material until the files are fixed
+					r.logger.Error("Failed to reload TLS files", zap.Error(err))
+					continue
+				}
+				r.logger.Info("Reloaded TLS files", zap.String("cert", r.config.CertFile))
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching the files
+func (r *Reloader) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// ServerConfig builds a listener config that always serves the latest certificate and client CAs.
+// nextProtos are the ALPN protocols offered on every connection, e.g. "h2" and "http/1.1".
+func (r *Reloader) ServerConfig(nextProtos ...string) (*tls.Config, error) {
+	if r.config.CertFile == "" || r.config.KeyFile == "" {
+		return nil, fmt.Errorf("certificate and key files are required")
+	}
+
+	base, err := r.baseConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch r.config.ClientAuth {
+	case "":
+		base.ClientAuth = tls.NoClientCert
+	case "request":
+		base.ClientAuth = tls.RequestClientCert
+	case "verify-if-given":
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", r.config.ClientAuth)
+	}
+	if base.ClientAuth >= tls.VerifyClientCertIfGiven && r.config.CAFile == "" {
+		return nil, fmt.Errorf("client certificate verification requires a CA file")
+	}
+	// The config returned per connection replaces the listener's, it has to carry ALPN itself
+	base.NextProtos = nextProtos
+
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*r.cert}
+		cfg.ClientCAs = r.pool
+		return cfg, nil
+	}
+	return base, nil
+}
+
+// ClientConfig builds a config for the upstream at address that presents the latest client
+// certificate and verifies the server against the latest CA bundle. The server is verified
+// against the ServerName override, or else the host of address.
+func (r *Reloader) ClientConfig(address string) (*tls.Config, error) {
+	config, err := r.clientBase()
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := r.config.ServerName
+	if serverName == "" {
+		serverName = address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			serverName = host
+		}
+	}
+	if serverName == "" {
+		return nil, fmt.Errorf("no server name to verify the upstream against")
+	}
+	config.ServerName = serverName
+
+	// RootCAs can't be swapped on a live config, so verification against a
+	// custom CA bundle is done by hand to pick up reloaded bundles
+	if r.config.CAFile != "" {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verifyServer(cs, serverName)
+		}
+	}
+	return config, nil
+}
+
+// HTTPTransport returns a transport for HTTP upstreams that builds a ClientConfig for every host it dials
+func (r *Reloader) HTTPTransport() (*http.Transport, error) {
+	if _, err := r.clientBase(); err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		config, err := r.ClientConfig(addr)
+		if err != nil {
+			return nil, err
+		}
+		config.NextProtos = []string{"h2", "http/1.1"}
+		dialer := &tls.Dialer{Config: config}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return transport, nil
+}
+
+// clientBase returns the settings shared by every upstream config
+func (r *Reloader) clientBase() (*tls.Config, error) {
+	if (r.config.CertFile == "") != (r.config.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+
+	config, err := r.baseConfig()
+	if err != nil {
+		return nil, err
+	}
+	if r.config.CertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		}
+	}
+	return config, nil
+}
+
+// verifyServer checks the server certificate against the CA bundle and serverName. The SNI in
+// cs can't be used, it is empty when the upstream is dialed by IP address.
+func (r *Reloader) verifyServer(cs tls.ConnectionState, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	r.mu.RLock()
+	pool := r.pool
+	r.mu.RUnlock()
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func (r *Reloader) baseConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch r.config.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported minimum TLS version %q", r.config.MinVersion)
+	}
+
+	if len(r.config.CipherSuites) > 0 {
+		suites, err := cipherSuites(r.config.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = suites
+	}
+	return cfg, nil
+}
+
+func (r *Reloader) load() error {
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+
+	if r.config.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load key pair: %v", err)
+		}
+		cert = &c
+	}
+
+	if r.config.CAFile != "" {
+		pem, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.config.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = cert
+	r.pool = pool
+	for _, f := range r.files() {
+		if info, err := os.Stat(f); err == nil {
+			r.modTime[f] = info.ModTime()
+		}
+	}
+	return nil
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTime[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.config.CertFile, r.config.KeyFile, r.config.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
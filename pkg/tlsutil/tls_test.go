@@ -0,0 +1,225 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs server certificates for the upstream tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue signs a certificate for dnsNames and ips
+func (ca *testCA) issue(t *testing.T, dnsNames []string, ips []net.IP) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "upstream"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// issueFiles signs a certificate for dnsNames and ips and writes it and its key to PEM files
+func (ca *testCA) issueFiles(t *testing.T, dnsNames []string, ips []net.IP) (certFile, keyFile string) {
+	t.Helper()
+	cert := ca.issue(t, dnsNames, ips)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// serve starts a TLS listener on 127.0.0.1 with a certificate for dnsNames and ips
+func (ca *testCA) serve(t *testing.T, dnsNames []string, ips []net.IP) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, dnsNames, ips)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, config Config, addr string) error {
+	t.Helper()
+	r, err := NewReloader(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := r.ClientConfig(addr)
+	if err != nil {
+		return err
+	}
+	conn, err := tls.Dial("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestClientConfigVerifiesIPUpstreams(t *testing.T) {
+	ca := newTestCA(t)
+
+	tests := []struct {
+		name       string
+		dnsNames   []string
+		ips        []net.IP
+		serverName string
+		ok         bool
+	}{
+		{name: "certificate for the dialed IP", ips: []net.IP{net.ParseIP("127.0.0.1")}, ok: true},
+		{name: "certificate for another host", dnsNames: []string{"other.internal"}, ok: false},
+		{name: "server name override", dnsNames: []string{"user.internal"}, serverName: "user.internal", ok: true},
+		{name: "server name override mismatch", dnsNames: []string{"other.internal"}, serverName: "user.internal", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := ca.serve(t, tt.dnsNames, tt.ips)
+			err := dial(t, Config{CAFile: ca.file, ServerName: tt.serverName}, addr)
+			if tt.ok && err != nil {
+				t.Fatalf("expected the upstream to verify, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestClientConfigRequiresServerName(t *testing.T) {
+	ca := newTestCA(t)
+	r, err := NewReloader(Config{CAFile: ca.file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ClientConfig(""); err == nil {
+		t.Fatal("expected an error without a server name or address")
+	}
+}
+
+func TestServerConfigNegotiatesHTTP2(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issueFiles(t, nil, []net.IP{net.ParseIP("127.0.0.1")})
+	r, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := r.ServerConfig("h2", "http/1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Served the way the HTTP listener is, through the per-connection config
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		TLSConfig: config,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS.NegotiatedProtocol != "h2" {
+		t.Fatalf("expected h2 to be negotiated, got %q", resp.TLS.NegotiatedProtocol)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected an HTTP/2 response, got %s", resp.Proto)
+	}
+}
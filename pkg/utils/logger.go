@@ -1,129 +1,141 @@
 package utils
 
 import (
+	"fmt"
 	"os"
-	"sync"
+	"path/filepath"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var (
-	logger *zap.Logger
-	once   sync.Once
-)
+var logger *zap.Logger
 
 type LogConfig struct {
 	Level      string
-	OutputPath string
-	MaxSize    int  // megabytes
-	MaxBackups int  // number of backups
-	MaxAge     int  // days
-	Compress   bool // compress old files
+	Encoding   string // json or console
+	OutputPath string // rotated log file written next to stdout, empty for stdout only
+	MaxSize    int    // megabytes
+	MaxBackups int    // number of backups
+	MaxAge     int    // days
+	Compress   bool   // compress old files
+
+	// Per second, the first SamplingInitial entries with the same message and level are logged,
+	// then every SamplingThereafter-th. Zero disables sampling.
+	SamplingInitial    int
+	SamplingThereafter int
 }
 
 func DefaultLogConfig() *LogConfig {
 	return &LogConfig{
-		Level:      "info",
-		OutputPath: "logs/gateway.log",
-		MaxSize:    100,
-		MaxBackups: 3,
-		MaxAge:     28,
-		Compress:   true,
+		Level:              "info",
+		Encoding:           "json",
+		OutputPath:         "logs/gateway.log",
+		MaxSize:            100,
+		MaxBackups:         3,
+		MaxAge:             28,
+		Compress:           true,
+		SamplingInitial:    100,
+		SamplingThereafter: 100,
 	}
 }
 
-// InitLogger initializes the global logger instance
-func InitLogger(config *LogConfig) *zap.Logger {
-	once.Do(func() {
-		// Create directory if it doesn't exist
-		os.MkdirAll("logs", 0744)
+// NewLogger builds a logger from config. The returned level controls it at runtime.
+func NewLogger(config *LogConfig) (*zap.Logger, zap.AtomicLevel, error) {
+	level := zap.NewAtomicLevel()
+	if config.Level != "" {
+		if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+			return nil, level, fmt.Errorf("invalid log level %q: %v", config.Level, err)
+		}
+	}
 
-		// Configure logging output
-		writer := zapcore.AddSync(&lumberjack.Logger{
+	// Configure encoding
+	encoderConfig := zapcore.EncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.RFC3339TimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	var encoder zapcore.Encoder
+	switch config.Encoding {
+	case "", "json":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "console":
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoderConfig.EncodeDuration = zapcore.StringDurationEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, level, fmt.Errorf("invalid log encoding %q", config.Encoding)
+	}
+
+	// Configure logging output
+	writer := zapcore.AddSync(os.Stdout)
+	if config.OutputPath != "" {
+		if err := os.MkdirAll(filepath.Dir(config.OutputPath), 0744); err != nil {
+			return nil, level, fmt.Errorf("failed to create log directory: %v", err)
+		}
+		writer = zapcore.NewMultiWriteSyncer(writer, zapcore.AddSync(&lumberjack.Logger{
 			Filename:   config.OutputPath,
 			MaxSize:    config.MaxSize,
 			MaxBackups: config.MaxBackups,
 			MaxAge:     config.MaxAge,
 			Compress:   config.Compress,
-		})
-
-		// Configure encoding
-		encoderConfig := zapcore.EncoderConfig{
-			TimeKey:        "timestamp",
-			LevelKey:       "level",
-			NameKey:        "logger",
-			CallerKey:      "caller",
-			MessageKey:     "msg",
-			StacktraceKey:  "stacktrace",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    zapcore.LowercaseLevelEncoder,
-			EncodeTime:     zapcore.RFC3339TimeEncoder,
-			EncodeDuration: zapcore.SecondsDurationEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
-		}
+		}))
+	}
 
-		// Determine log level
-		var level zapcore.Level
-		switch config.Level {
-		case "debug":
-			level = zapcore.DebugLevel
-		case "info":
-			level = zapcore.InfoLevel
-		case "warn":
-			level = zapcore.WarnLevel
-		case "error":
-			level = zapcore.ErrorLevel
-		default:
-			level = zapcore.InfoLevel
-		}
+	core := zapcore.NewCore(encoder, writer, level)
+	if config.SamplingInitial > 0 && config.SamplingThereafter > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, config.SamplingInitial, config.SamplingThereafter)
+	}
 
-		// Create core
-		core := zapcore.NewCore(
-			zapcore.NewJSONEncoder(encoderConfig),
-			zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout), writer),
-			level,
-		)
-
-		// Create logger
-		logger = zap.New(core,
-			zap.AddCaller(),
-			zap.AddCallerSkip(1),
-			zap.AddStacktrace(zapcore.ErrorLevel),
-		)
-	})
+	return zap.New(core,
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel),
+	), level, nil
+}
 
-	return logger
+// SetLogger makes l the logger behind GetLogger, the package level helpers and zap.L
+func SetLogger(l *zap.Logger) {
+	logger = l
+	zap.ReplaceGlobals(l)
 }
 
 // GetLogger returns the global logger instance
 func GetLogger() *zap.Logger {
 	if logger == nil {
-		return InitLogger(DefaultLogConfig())
+		return zap.L()
 	}
 	return logger
 }
 
 // Log levels
 func Info(msg string, fields ...zapcore.Field) {
-	GetLogger().Info(msg, fields...)
+	GetLogger().WithOptions(zap.AddCallerSkip(1)).Info(msg, fields...)
 }
 
 func Debug(msg string, fields ...zapcore.Field) {
-	GetLogger().Debug(msg, fields...)
+	GetLogger().WithOptions(zap.AddCallerSkip(1)).Debug(msg, fields...)
 }
 
 func Warn(msg string, fields ...zapcore.Field) {
-	GetLogger().Warn(msg, fields...)
+	GetLogger().WithOptions(zap.AddCallerSkip(1)).Warn(msg, fields...)
 }
 
 func Error(msg string, fields ...zapcore.Field) {
-	GetLogger().Error(msg, fields...)
+	GetLogger().WithOptions(zap.AddCallerSkip(1)).Error(msg, fields...)
 }
 
 func Fatal(msg string, fields ...zapcore.Field) {
-	GetLogger().Fatal(msg, fields...)
+	GetLogger().WithOptions(zap.AddCallerSkip(1)).Fatal(msg, fields...)
 }
 
 // Custom field helpers
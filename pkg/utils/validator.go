@@ -110,6 +110,26 @@ func ValidatePassword(password string) error {
 	return nil
 }
 
+func ValidatePhone(phone string) error {
+	if !phoneRegex.MatchString(phone) {
+		return &ValidationError{
+			Field:   "phone",
+			Message: "invalid phone number format",
+		}
+	}
+	return nil
+}
+
+func ValidateUsername(username string) error {
+	if !usernameRegex.MatchString(username) {
+		return &ValidationError{
+			Field:   "username",
+			Message: "username must be 3-16 characters long and contain only letters, numbers, underscores, or hyphens",
+		}
+	}
+	return nil
+}
+
 // Custom validator functions
 func validatePassword(fl validator.FieldLevel) bool {
 	return passwordRegex.MatchString(fl.Field().String())
@@ -0,0 +1,155 @@
+package validation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kannan112/gateway-structure/pkg/utils"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Rules maps a message's full name to field paths and the rules applied to them.
+// Paths use proto field names joined by dots, e.g. "user.email".
+type Rules map[string]map[string][]string
+
+// DefaultRules validates the user service requests with the shared rules from pkg/utils
+var DefaultRules = Rules{
+	"user.CreateUserRequest": {
+		"user":              {"required"},
+		"user.username":     {"required", "username"},
+		"user.email":        {"required", "email"},
+		"user.phone_number": {"phone"},
+		"password":          {"required", "password"},
+	},
+	"user.GetUserRequest": {
+		"user_id": {"required"},
+	},
+	"user.UpdateUserRequest": {
+		"user":              {"required"},
+		"user.id":           {"required"},
+		"user.username":     {"username"},
+		"user.email":        {"email"},
+		"user.phone_number": {"phone"},
+		"new_password":      {"password"},
+	},
+	"user.DeleteUserRequest": {
+		"user_id": {"required"},
+	},
+	"user.ListUsersRequest": {
+		"page_size": {"min=0", "max=1000"},
+	},
+	"user.BatchGetUsersRequest": {
+		"user_ids": {"required", "max=100"},
+	},
+	"user.BatchUpdateUserStatusRequest": {
+		"user_ids": {"required", "max=100"},
+		"status":   {"required"},
+	},
+}
+
+// check applies a single rule to a present, non-empty value.
+// "required" is handled by the caller since it is about presence.
+func check(rule string, v protoreflect.Value, fd protoreflect.FieldDescriptor) error {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	switch name {
+	case "email":
+		return stringRule(v, fd, utils.ValidateEmail)
+	case "password":
+		return stringRule(v, fd, utils.ValidatePassword)
+	case "phone":
+		return stringRule(v, fd, utils.ValidatePhone)
+	case "username":
+		return stringRule(v, fd, utils.ValidateUsername)
+	case "min", "max":
+		limit, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s rule %q", name, rule)
+		}
+		// On repeated fields the limits bound the number of items
+		if fd.IsList() {
+			n := int64(v.List().Len())
+			if name == "min" && n < limit {
+				return fmt.Errorf("must have at least %d items", limit)
+			}
+			if name == "max" && n > limit {
+				return fmt.Errorf("must have at most %d items", limit)
+			}
+			return nil
+		}
+		n, ok := intValue(v, fd)
+		if !ok {
+			return fmt.Errorf("%s rule needs a numeric field", name)
+		}
+		if name == "min" && n < limit {
+			return fmt.Errorf("must be at least %d", limit)
+		}
+		if name == "max" && n > limit {
+			return fmt.Errorf("must be at most %d", limit)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown validation rule %q", rule)
+	}
+}
+
+// checkRule reports a rule that doesn't exist or can't apply to fd, ahead of check
+func checkRule(rule string, fd protoreflect.FieldDescriptor) error {
+	name, arg, _ := strings.Cut(rule, "=")
+
+	switch name {
+	case "required":
+		return nil
+	case "email", "password", "phone", "username":
+		if fd.Kind() != protoreflect.StringKind || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("%s rule needs a string field", name)
+		}
+		return nil
+	case "min", "max":
+		if _, err := strconv.ParseInt(arg, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s rule %q", name, rule)
+		}
+		if !fd.IsList() && !integerKind(fd.Kind()) {
+			return fmt.Errorf("%s rule needs a numeric or repeated field", name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown validation rule %q", rule)
+	}
+}
+
+func stringRule(v protoreflect.Value, fd protoreflect.FieldDescriptor, fn func(string) error) error {
+	if fd.Kind() != protoreflect.StringKind {
+		return fmt.Errorf("rule needs a string field")
+	}
+	if err := fn(v.String()); err != nil {
+		if verr, ok := err.(*utils.ValidationError); ok {
+			return fmt.Errorf("%s", verr.Message)
+		}
+		return err
+	}
+	return nil
+}
+
+func integerKind(k protoreflect.Kind) bool {
+	switch k {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return true
+	}
+	return false
+}
+
+func intValue(v protoreflect.Value, fd protoreflect.FieldDescriptor) (int64, bool) {
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int(), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return int64(v.Uint()), true
+	default:
+		return 0, false
+	}
+}
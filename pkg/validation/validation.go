@@ -0,0 +1,212 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Validator checks proto messages against declarative field rules
+type Validator struct {
+	rules Rules
+}
+
+// New creates a new Validator. Rules for the same message and path replace each other,
+// later rule sets win.
+func New(ruleSets ...Rules) *Validator {
+	merged := make(Rules)
+	for _, rules := range ruleSets {
+		for msg, fields := range rules {
+			if merged[msg] == nil {
+				merged[msg] = make(map[string][]string)
+			}
+			for path, r := range fields {
+				merged[msg][path] = r
+			}
+		}
+	}
+	return &Validator{rules: merged}
+}
+
+// LoadRules reads a JSON rule file in the same shape as Rules. The messages must be registered
+// proto types, so the file has to be loaded after their packages are imported.
+func LoadRules(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validation rules %s: %v", path, err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse validation rules %s: %v", path, err)
+	}
+	if err := rules.Check(); err != nil {
+		return nil, fmt.Errorf("invalid validation rules %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Check resolves every message and field path in the proto registry and rejects rules that
+// don't exist or don't fit their field
+func (r Rules) Check() error {
+	messages := make([]string, 0, len(r))
+	for name := range r {
+		messages = append(messages, name)
+	}
+	sort.Strings(messages)
+
+	for _, name := range messages {
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return fmt.Errorf("unknown message %s", name)
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return fmt.Errorf("%s is not a message", name)
+		}
+
+		paths := make([]string, 0, len(r[name]))
+		for path := range r[name] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fd := field(md, strings.Split(path, "."))
+			if fd == nil {
+				return fmt.Errorf("%s has no field %q", name, path)
+			}
+			for _, rule := range r[name][path] {
+				if err := checkRule(rule, fd); err != nil {
+					return fmt.Errorf("%s field %q: %v", name, path, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var defaultValidator = New(DefaultRules)
+
+// Default returns the validator built from DefaultRules
+func Default() *Validator {
+	return defaultValidator
+}
+
+// SetDefault replaces the validator returned by Default
+func SetDefault(v *Validator) {
+	defaultValidator = v
+}
+
+// Validate checks msg with the default validator
+func Validate(msg proto.Message) error {
+	return defaultValidator.Validate(msg)
+}
+
+// Violations returns every rule violation of msg, sorted by field path
+func (v *Validator) Violations(msg proto.Message) []*errdetails.BadRequest_FieldViolation {
+	m := msg.ProtoReflect()
+	fields, ok := v.rules[string(m.Descriptor().FullName())]
+	if !ok {
+		return nil
+	}
+
+	paths := make([]string, 0, len(fields))
+	for path := range fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, path := range paths {
+		if desc := checkPath(m, path, fields[path]); desc != "" {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       path,
+				Description: desc,
+			})
+		}
+	}
+	return violations
+}
+
+// Validate returns a codes.InvalidArgument status with BadRequest details, or nil if msg is valid
+func (v *Validator) Validate(msg proto.Message) error {
+	violations := v.Violations(msg)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	st := status.New(codes.InvalidArgument, "invalid request")
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// checkPath returns a description of the first failing rule for path, or "" if all pass
+func checkPath(m protoreflect.Message, path string, rules []string) string {
+	value, fd, present := resolve(m, strings.Split(path, "."))
+	if fd == nil {
+		return fmt.Sprintf("unknown field %q", path)
+	}
+
+	for _, rule := range rules {
+		if rule == "required" {
+			if !present {
+				return "this field is required"
+			}
+			continue
+		}
+		// Optional fields are only checked when set
+		if !present {
+			return ""
+		}
+		if err := check(rule, value, fd); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// field returns the descriptor a dotted path names, or nil. Like resolve it only descends
+// into singular messages.
+func field(md protoreflect.MessageDescriptor, path []string) protoreflect.FieldDescriptor {
+	fd := md.Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil || len(path) == 1 {
+		return fd
+	}
+	if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+		return nil
+	}
+	return field(fd.Message(), path[1:])
+}
+
+// resolve walks a dotted path through nested messages.
+// present is false when the field, or any message on the way to it, is unset or empty.
+func resolve(m protoreflect.Message, path []string) (protoreflect.Value, protoreflect.FieldDescriptor, bool) {
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		return protoreflect.Value{}, nil, false
+	}
+
+	present := m.Has(fd)
+	value := m.Get(fd)
+	if len(path) == 1 {
+		return value, fd, present
+	}
+
+	if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+		return protoreflect.Value{}, nil, false
+	}
+	v, nested, ok := resolve(value.Message(), path[1:])
+	return v, nested, ok && present
+}
@@ -0,0 +1,68 @@
+package validation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	userpb "github.com/kannan112/gateway-structure/pkg/proto/user"
+)
+
+func TestDefaultRulesCheck(t *testing.T) {
+	if err := DefaultRules.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{name: "valid", rules: `{"user.UpdateUserRequest": {"user.first_name": ["required"], "user.roles": ["max=5"]}}`},
+		{name: "unknown message", rules: `{"user.Nope": {"id": ["required"]}}`, err: "unknown message user.Nope"},
+		{name: "unknown field", rules: `{"user.CreateUserRequest": {"user.nickname": ["required"]}}`, err: `no field "user.nickname"`},
+		{name: "path through a scalar", rules: `{"user.CreateUserRequest": {"password.length": ["required"]}}`, err: `no field "password.length"`},
+		{name: "unknown rule", rules: `{"user.CreateUserRequest": {"password": ["strong"]}}`, err: `unknown validation rule "strong"`},
+		{name: "string rule on a number", rules: `{"user.ListUsersRequest": {"page_size": ["email"]}}`, err: "email rule needs a string field"},
+		{name: "bad limit", rules: `{"user.ListUsersRequest": {"page_size": ["max=ten"]}}`, err: `invalid max rule "max=ten"`},
+		{name: "limit on a string", rules: `{"user.GetUserRequest": {"user_id": ["min=1"]}}`, err: "min rule needs a numeric or repeated field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(tt.rules), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadRules(path)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := New(DefaultRules).Validate(&userpb.CreateUserRequest{
+		User:     &userpb.User{Username: "jdoe", Email: "not-an-email"},
+		Password: "Hunter22!pass",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	violations := New(DefaultRules).Violations(&userpb.CreateUserRequest{Password: "Hunter22!pass"})
+	if len(violations) == 0 || violations[0].Field != "user" {
+		t.Fatalf("expected the missing user to be reported, got %v", violations)
+	}
+}
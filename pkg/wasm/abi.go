@@ -0,0 +1,393 @@
+package wasm
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The subset of proxy-wasm 0.2.1 the gateway implements. Filters may export these hooks:
+//
+//	proxy_on_memory_allocate (or malloc), proxy_on_context_create, proxy_on_vm_start,
+//	proxy_on_configure, proxy_on_request_headers, proxy_on_request_body,
+//	proxy_on_response_headers, proxy_on_response_body, proxy_on_done, proxy_on_delete
+//
+// and import these functions from "env":
+//
+//	proxy_log, proxy_get_log_level, proxy_get_current_time_nanoseconds,
+//	proxy_set_effective_context, proxy_get_property, proxy_set_tick_period_milliseconds,
+//	proxy_get_header_map_pairs, proxy_set_header_map_pairs, proxy_get_header_map_value,
+//	proxy_add_header_map_value, proxy_replace_header_map_value, proxy_remove_header_map_value,
+//	proxy_get_buffer_bytes, proxy_set_buffer_bytes, proxy_send_local_response
+//
+// Properties, timers, HTTP and gRPC callouts, shared data and metrics are not available.
+// Hooks run synchronously, a Pause action is treated as Continue.
+const (
+	onMemoryAllocate  = "proxy_on_memory_allocate"
+	malloc            = "malloc"
+	onContextCreate   = "proxy_on_context_create"
+	onVMStart         = "proxy_on_vm_start"
+	onConfigure       = "proxy_on_configure"
+	onRequestHeaders  = "proxy_on_request_headers"
+	onRequestBody     = "proxy_on_request_body"
+	onResponseHeaders = "proxy_on_response_headers"
+	onResponseBody    = "proxy_on_response_body"
+	onDone            = "proxy_on_done"
+	onDelete          = "proxy_on_delete"
+)
+
+// Status codes returned to the filter
+const (
+	statusOK                  = 0
+	statusNotFound            = 1
+	statusBadArgument         = 2
+	statusInvalidMemoryAccess = 6
+	statusInternalFailure     = 10
+	statusUnimplemented       = 12
+)
+
+// Header map types
+const (
+	mapRequestHeaders  = 0
+	mapResponseHeaders = 2
+)
+
+// Buffer types
+const (
+	bufferRequestBody         = 0
+	bufferResponseBody        = 1
+	bufferVMConfiguration     = 6
+	bufferPluginConfiguration = 7
+)
+
+// instantiateHost exports the host functions to filters of r
+func instantiateHost(ctx context.Context, r wazero.Runtime) error {
+	b := r.NewHostModuleBuilder("env")
+	for name, fn := range map[string]interface{}{
+		"proxy_log":                          proxyLog,
+		"proxy_get_log_level":                proxyGetLogLevel,
+		"proxy_get_current_time_nanoseconds": proxyGetCurrentTime,
+		"proxy_set_effective_context":        proxySetEffectiveContext,
+		"proxy_get_property":                 proxyGetProperty,
+		"proxy_set_tick_period_milliseconds": proxySetTickPeriod,
+		"proxy_get_header_map_pairs":         proxyGetHeaderMapPairs,
+		"proxy_set_header_map_pairs":         proxySetHeaderMapPairs,
+		"proxy_get_header_map_value":         proxyGetHeaderMapValue,
+		"proxy_add_header_map_value":         proxyAddHeaderMapValue,
+		"proxy_replace_header_map_value":     proxyReplaceHeaderMapValue,
+		"proxy_remove_header_map_value":      proxyRemoveHeaderMapValue,
+		"proxy_get_buffer_bytes":             proxyGetBufferBytes,
+		"proxy_set_buffer_bytes":             proxySetBufferBytes,
+		"proxy_send_local_response":          proxySendLocalResponse,
+	} {
+		b = b.NewFunctionBuilder().WithFunc(fn).Export(name)
+	}
+	_, err := b.Instantiate(ctx)
+	return err
+}
+
+var logLevels = []zapcore.Level{zap.DebugLevel, zap.DebugLevel, zap.InfoLevel, zap.WarnLevel, zap.ErrorLevel, zap.ErrorLevel}
+
+func proxyLog(ctx context.Context, m api.Module, level, msgPtr, msgSize uint32) uint32 {
+	c := callFromContext(ctx)
+	msg, ok := m.Memory().Read(msgPtr, msgSize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	if int(level) >= len(logLevels) {
+		return statusBadArgument
+	}
+	if ce := c.filter.logger.Check(logLevels[level], string(msg)); ce != nil {
+		ce.Write(zap.Uint32("context_id", c.id))
+	}
+	return statusOK
+}
+
+func proxyGetLogLevel(ctx context.Context, m api.Module, levelPtr uint32) uint32 {
+	c := callFromContext(ctx)
+	level := uint32(len(logLevels) - 1)
+	for i := 1; i < len(logLevels); i++ {
+		if c.filter.logger.Core().Enabled(logLevels[i]) {
+			level = uint32(i)
+			break
+		}
+	}
+	if !m.Memory().WriteUint32Le(levelPtr, level) {
+		return statusInvalidMemoryAccess
+	}
+	return statusOK
+}
+
+func proxyGetCurrentTime(ctx context.Context, m api.Module, timePtr uint32) uint32 {
+	if !m.Memory().WriteUint64Le(timePtr, uint64(time.Now().UnixNano())) {
+		return statusInvalidMemoryAccess
+	}
+	return statusOK
+}
+
+func proxySetEffectiveContext(ctx context.Context, contextID uint32) uint32 {
+	return statusOK
+}
+
+func proxyGetProperty(ctx context.Context, pathPtr, pathSize, valuePtr, valueSize uint32) uint32 {
+	return statusNotFound
+}
+
+func proxySetTickPeriod(ctx context.Context, period uint32) uint32 {
+	return statusUnimplemented
+}
+
+func proxyGetHeaderMapPairs(ctx context.Context, m api.Module, mapType, dataPtr, sizePtr uint32) uint32 {
+	c := callFromContext(ctx)
+	h := c.headerMap(mapType)
+	if h == nil {
+		return statusNotFound
+	}
+	return c.writeReturn(ctx, m, encodePairs(h.Pairs()), dataPtr, sizePtr)
+}
+
+func proxySetHeaderMapPairs(ctx context.Context, m api.Module, mapType, dataPtr, dataSize uint32) uint32 {
+	c := callFromContext(ctx)
+	h := c.headerMap(mapType)
+	if h == nil {
+		return statusNotFound
+	}
+	data, ok := m.Memory().Read(dataPtr, dataSize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	pairs, ok := decodePairs(data)
+	if !ok {
+		return statusBadArgument
+	}
+	h.Replace(pairs)
+	return statusOK
+}
+
+func proxyGetHeaderMapValue(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valuePtr, valueSize uint32) uint32 {
+	c := callFromContext(ctx)
+	h := c.headerMap(mapType)
+	if h == nil {
+		return statusNotFound
+	}
+	key, ok := m.Memory().Read(keyPtr, keySize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	value, found := h.Get(string(key))
+	if !found {
+		return statusNotFound
+	}
+	return c.writeReturn(ctx, m, []byte(value), valuePtr, valueSize)
+}
+
+func proxyAddHeaderMapValue(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valuePtr, valueSize uint32) uint32 {
+	return modifyHeader(ctx, m, mapType, keyPtr, keySize, valuePtr, valueSize, (*HeaderMap).Add)
+}
+
+func proxyReplaceHeaderMapValue(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valuePtr, valueSize uint32) uint32 {
+	return modifyHeader(ctx, m, mapType, keyPtr, keySize, valuePtr, valueSize, (*HeaderMap).Set)
+}
+
+func proxyRemoveHeaderMapValue(ctx context.Context, m api.Module, mapType, keyPtr, keySize uint32) uint32 {
+	return modifyHeader(ctx, m, mapType, keyPtr, keySize, 0, 0, func(h *HeaderMap, key, _ string) bool {
+		return h.Del(key)
+	})
+}
+
+// modifyHeader reads a header name and value from memory and applies modify to the header map
+func modifyHeader(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valuePtr, valueSize uint32, modify func(h *HeaderMap, key, value string) bool) uint32 {
+	h := callFromContext(ctx).headerMap(mapType)
+	if h == nil {
+		return statusNotFound
+	}
+	key, ok := m.Memory().Read(keyPtr, keySize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	value, ok := m.Memory().Read(valuePtr, valueSize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	if !modify(h, string(key), string(value)) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func proxyGetBufferBytes(ctx context.Context, m api.Module, bufferType, start, maxSize, dataPtr, sizePtr uint32) uint32 {
+	c := callFromContext(ctx)
+	buf, ok := c.buffer(bufferType)
+	if !ok {
+		return statusNotFound
+	}
+	if int(start) > len(*buf) {
+		return statusBadArgument
+	}
+	end := len(*buf)
+	if int(maxSize) < end-int(start) {
+		end = int(start) + int(maxSize)
+	}
+	return c.writeReturn(ctx, m, (*buf)[start:end], dataPtr, sizePtr)
+}
+
+// proxySetBufferBytes replaces size bytes from start with the given data, so start 0 and
+// size 0 prepends and a start past the end appends
+func proxySetBufferBytes(ctx context.Context, m api.Module, bufferType, start, size, dataPtr, dataSize uint32) uint32 {
+	c := callFromContext(ctx)
+	if bufferType != bufferRequestBody && bufferType != bufferResponseBody {
+		return statusBadArgument
+	}
+	buf, ok := c.buffer(bufferType)
+	if !ok {
+		return statusNotFound
+	}
+	data, ok := m.Memory().Read(dataPtr, dataSize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+
+	from := min(int(start), len(*buf))
+	to := min(from+int(size), len(*buf))
+	replaced := make([]byte, 0, len(*buf)-(to-from)+len(data))
+	replaced = append(replaced, (*buf)[:from]...)
+	replaced = append(replaced, data...)
+	replaced = append(replaced, (*buf)[to:]...)
+	*buf = replaced
+	return statusOK
+}
+
+func proxySendLocalResponse(ctx context.Context, m api.Module, statusCode, detailsPtr, detailsSize, bodyPtr, bodySize, headersPtr, headersSize uint32, grpcStatus int32) uint32 {
+	c := callFromContext(ctx)
+	details, ok := m.Memory().Read(detailsPtr, detailsSize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	body, ok := m.Memory().Read(bodyPtr, bodySize)
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	var header [][2]string
+	if headersSize > 0 {
+		data, ok := m.Memory().Read(headersPtr, headersSize)
+		if !ok {
+			return statusInvalidMemoryAccess
+		}
+		if header, ok = decodePairs(data); !ok {
+			return statusBadArgument
+		}
+	}
+
+	c.local = &LocalResponse{
+		Status:     int(statusCode),
+		Details:    string(details),
+		Body:       append([]byte(nil), body...),
+		Header:     header,
+		GRPCStatus: grpcStatus,
+	}
+	return statusOK
+}
+
+// headerMap returns the header map of mapType, nil when the call has none
+func (c *Call) headerMap(mapType uint32) *HeaderMap {
+	switch mapType {
+	case mapRequestHeaders:
+		return c.requestHeaders
+	case mapResponseHeaders:
+		return c.responseHeaders
+	}
+	return nil
+}
+
+// buffer returns the buffer of bufferType, bodies are only available once their hook ran
+func (c *Call) buffer(bufferType uint32) (*[]byte, bool) {
+	switch bufferType {
+	case bufferRequestBody:
+		return &c.requestBody, c.requestBody != nil
+	case bufferResponseBody:
+		return &c.responseBody, c.responseBody != nil
+	case bufferVMConfiguration:
+		var empty []byte
+		return &empty, true
+	case bufferPluginConfiguration:
+		config := c.filter.config
+		return &config, true
+	}
+	return nil, false
+}
+
+// writeReturn copies data into memory allocated by the filter and stores its address and size
+func (c *Call) writeReturn(ctx context.Context, m api.Module, data []byte, dataPtr, sizePtr uint32) uint32 {
+	var ptr uint32
+	if len(data) > 0 {
+		if c.inst.allocate == nil {
+			return statusInternalFailure
+		}
+		results, err := c.inst.allocate.Call(ctx, api.EncodeU32(uint32(len(data))))
+		if err != nil || len(results) == 0 {
+			return statusInternalFailure
+		}
+		ptr = api.DecodeU32(results[0])
+		if !m.Memory().Write(ptr, data) {
+			return statusInvalidMemoryAccess
+		}
+	}
+	if !m.Memory().WriteUint32Le(dataPtr, ptr) || !m.Memory().WriteUint32Le(sizePtr, uint32(len(data))) {
+		return statusInvalidMemoryAccess
+	}
+	return statusOK
+}
+
+// encodePairs serializes headers as proxy-wasm does: the pair count, the sizes of every
+// name and value, then every name and value followed by a NUL byte
+func encodePairs(pairs [][2]string) []byte {
+	size := 4
+	for _, p := range pairs {
+		size += 8 + len(p[0]) + len(p[1]) + 2
+	}
+
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf, uint32(len(pairs)))
+	off := 4
+	for _, p := range pairs {
+		binary.LittleEndian.PutUint32(buf[off:], uint32(len(p[0])))
+		binary.LittleEndian.PutUint32(buf[off+4:], uint32(len(p[1])))
+		off += 8
+	}
+	for _, p := range pairs {
+		off += copy(buf[off:], p[0]) + 1
+		off += copy(buf[off:], p[1]) + 1
+	}
+	return buf
+}
+
+// decodePairs parses headers serialized by encodePairs
+func decodePairs(data []byte) ([][2]string, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	if count > (len(data)-4)/10 {
+		return nil, false
+	}
+
+	pairs := make([][2]string, count)
+	sizes := data[4:]
+	off := 4 + 8*count
+	for i := range pairs {
+		for j := 0; j < 2; j++ {
+			n := int(binary.LittleEndian.Uint32(sizes[8*i+4*j:]))
+			if n < 0 || off+n >= len(data) {
+				return nil, false
+			}
+			pairs[i][j] = string(data[off : off+n])
+			off += n + 1
+		}
+	}
+	return pairs, true
+}
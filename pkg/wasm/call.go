@@ -0,0 +1,136 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+type callKey struct{}
+
+// LocalResponse is the response a filter sent instead of letting the request through
+type LocalResponse struct {
+	Status  int
+	Details string
+	Body    []byte
+	Header  [][2]string
+	// GRPCStatus is the gRPC code for gRPC calls, negative when the filter left it to the gateway
+	GRPCStatus int32
+}
+
+// Call is one request passing through a filter, it holds a filter instance until End
+type Call struct {
+	filter *Filter
+	inst   *instance
+	id     uint32
+	broken bool
+
+	requestHeaders  *HeaderMap
+	responseHeaders *HeaderMap
+	requestBody     []byte
+	responseBody    []byte
+	local           *LocalResponse
+}
+
+// FiltersRequestBody reports whether the filter inspects request bodies, they are only
+// read into memory for filters that do
+func (f *Filter) FiltersRequestBody() bool {
+	return f.exports[onRequestBody]
+}
+
+// FiltersResponse reports whether the filter inspects responses, they are only buffered
+// for filters that do
+func (f *Filter) FiltersResponse() bool {
+	return f.exports[onResponseHeaders] || f.exports[onResponseBody]
+}
+
+// FiltersResponseBody reports whether the filter inspects response bodies
+func (f *Filter) FiltersResponseBody() bool {
+	return f.exports[onResponseBody]
+}
+
+// OnRequestHeaders passes the request headers to the filter, which may modify them in place.
+// A non-nil LocalResponse means the filter rejected the request.
+func (c *Call) OnRequestHeaders(ctx context.Context, headers *HeaderMap, endOfStream bool) (*LocalResponse, error) {
+	c.requestHeaders = headers
+	_, err := c.hook(ctx, onRequestHeaders, c.id, uint32(len(headers.Pairs())), boolParam(endOfStream))
+	return c.local, err
+}
+
+// OnRequestBody passes the complete request body to the filter and returns it as the filter left it
+func (c *Call) OnRequestBody(ctx context.Context, body []byte) ([]byte, *LocalResponse, error) {
+	c.requestBody = body
+	_, err := c.hook(ctx, onRequestBody, c.id, uint32(len(body)), 1)
+	return c.requestBody, c.local, err
+}
+
+// OnResponseHeaders passes the response headers to the filter, which may modify them in place
+func (c *Call) OnResponseHeaders(ctx context.Context, headers *HeaderMap, endOfStream bool) (*LocalResponse, error) {
+	c.responseHeaders = headers
+	_, err := c.hook(ctx, onResponseHeaders, c.id, uint32(len(headers.Pairs())), boolParam(endOfStream))
+	return c.local, err
+}
+
+// OnResponseBody passes the complete response body to the filter and returns it as the filter left it
+func (c *Call) OnResponseBody(ctx context.Context, body []byte) ([]byte, *LocalResponse, error) {
+	c.responseBody = body
+	_, err := c.hook(ctx, onResponseBody, c.id, uint32(len(body)), 1)
+	return c.responseBody, c.local, err
+}
+
+// End tells the filter the request is done and returns the instance to the pool
+func (c *Call) End(ctx context.Context) {
+	if c.broken {
+		return
+	}
+	c.hook(ctx, onDone, c.id)
+	c.hook(ctx, onDelete, c.id)
+	if !c.broken {
+		c.filter.release(c.inst)
+	}
+}
+
+// hook calls an exported function of the filter within the filter timeout. Filters
+// needn't export every hook, missing ones return 0.
+func (c *Call) hook(ctx context.Context, name string, params ...uint32) (uint32, error) {
+	if c.broken {
+		return 0, fmt.Errorf("wasm filter %s failed earlier in this request", c.filter.name)
+	}
+	fn := c.inst.module.ExportedFunction(name)
+	if fn == nil {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, callKey{}, c), c.filter.timeout)
+	defer cancel()
+
+	args := make([]uint64, len(params))
+	for i, p := range params {
+		args[i] = api.EncodeU32(p)
+	}
+	results, err := fn.Call(ctx, args...)
+	if err != nil {
+		// A trapped or interrupted instance may be left in any state, it is never reused
+		c.broken = true
+		c.filter.discard(c.inst)
+		return 0, fmt.Errorf("wasm filter %s failed in %s: %v", c.filter.name, name, err)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return api.DecodeU32(results[0]), nil
+}
+
+// callFromContext returns the call a host function runs for
+func callFromContext(ctx context.Context) *Call {
+	c, _ := ctx.Value(callKey{}).(*Call)
+	return c
+}
+
+func boolParam(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
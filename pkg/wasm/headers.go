@@ -0,0 +1,128 @@
+package wasm
+
+import (
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderMap is the view a filter has of request or response headers, or gRPC metadata.
+// Names are presented lower case. Pseudo headers such as :path are read-only.
+type HeaderMap struct {
+	pseudo [][2]string
+	values map[string][]string
+	key    func(string) string
+}
+
+// HTTPRequestHeaders exposes the headers of r, with :method, :path, :authority and :scheme
+func HTTPRequestHeaders(r *http.Request) *HeaderMap {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return &HeaderMap{
+		pseudo: [][2]string{
+			{":method", r.Method},
+			{":path", r.URL.RequestURI()},
+			{":authority", r.Host},
+			{":scheme", scheme},
+		},
+		values: r.Header,
+		key:    textproto.CanonicalMIMEHeaderKey,
+	}
+}
+
+// HTTPResponseHeaders exposes response headers with :status
+func HTTPResponseHeaders(h http.Header, status int) *HeaderMap {
+	return &HeaderMap{
+		pseudo: [][2]string{{":status", strconv.Itoa(status)}},
+		values: h,
+		key:    textproto.CanonicalMIMEHeaderKey,
+	}
+}
+
+// Metadata exposes gRPC metadata, with :path when method is set
+func Metadata(md metadata.MD, method string) *HeaderMap {
+	m := &HeaderMap{values: md, key: strings.ToLower}
+	if method != "" {
+		m.pseudo = [][2]string{{":path", method}}
+	}
+	return m
+}
+
+// Pairs returns the pseudo headers followed by every header value, sorted by name
+func (m *HeaderMap) Pairs() [][2]string {
+	names := make([]string, 0, len(m.values))
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := append([][2]string(nil), m.pseudo...)
+	for _, name := range names {
+		for _, value := range m.values[name] {
+			pairs = append(pairs, [2]string{strings.ToLower(name), value})
+		}
+	}
+	return pairs
+}
+
+// Get returns the values of name joined by commas
+func (m *HeaderMap) Get(name string) (string, bool) {
+	for _, p := range m.pseudo {
+		if p[0] == name {
+			return p[1], true
+		}
+	}
+	values, ok := m.values[m.key(name)]
+	if !ok {
+		return "", false
+	}
+	return strings.Join(values, ","), true
+}
+
+// Add appends a value to name, false for pseudo headers
+func (m *HeaderMap) Add(name, value string) bool {
+	if isPseudo(name) {
+		return false
+	}
+	key := m.key(name)
+	m.values[key] = append(m.values[key], value)
+	return true
+}
+
+// Set replaces the values of name, false for pseudo headers
+func (m *HeaderMap) Set(name, value string) bool {
+	if isPseudo(name) {
+		return false
+	}
+	m.values[m.key(name)] = []string{value}
+	return true
+}
+
+// Del removes name, false for pseudo headers
+func (m *HeaderMap) Del(name string) bool {
+	if isPseudo(name) {
+		return false
+	}
+	delete(m.values, m.key(name))
+	return true
+}
+
+// Replace drops every header and adds pairs, pseudo headers in pairs are ignored
+func (m *HeaderMap) Replace(pairs [][2]string) {
+	for name := range m.values {
+		delete(m.values, name)
+	}
+	for _, p := range pairs {
+		m.Add(p[0], p[1])
+	}
+}
+
+func isPseudo(name string) bool {
+	return strings.HasPrefix(name, ":")
+}
@@ -0,0 +1,80 @@
+;; Test filter for the sandbox limits. Build with: wat2wasm filter.wat -o filter.wasm
+;;
+;; proxy_on_request_headers acts on request headers:
+;;   x-loop  spins forever, for the call timeout
+;;   x-grow  grows memory by 4MB and traps when the memory limit refuses it
+;;   x-deny  sends a local 403 response with the body "denied"
+(module
+  (import "env" "proxy_get_header_map_value"
+    (func $get_header_map_value (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_send_local_response"
+    (func $send_local_response (param i32 i32 i32 i32 i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 2)
+  (global $heap (mut i32) (i32.const 1024))
+
+  (data (i32.const 0) "x-loop")
+  (data (i32.const 16) "x-grow")
+  (data (i32.const 32) "x-deny")
+  (data (i32.const 48) "denied")
+
+  ;; Bump allocator for values the host returns, never freed
+  (func (export "proxy_on_memory_allocate") (param $size i32) (result i32)
+    global.get $heap
+    global.get $heap
+    local.get $size
+    i32.add
+    global.set $heap)
+
+  (func (export "proxy_on_context_create") (param $context_id i32) (param $root_context_id i32))
+
+  ;; has reports whether the request has the 6 byte header name at $key,
+  ;; the host writes the value's address and size to 64 and 68
+  (func $has (param $key i32) (result i32)
+    i32.const 0
+    local.get $key
+    i32.const 6
+    i32.const 64
+    i32.const 68
+    call $get_header_map_value
+    i32.eqz)
+
+  (func (export "proxy_on_request_headers") (param $context_id i32) (param $headers i32) (param $end_of_stream i32) (result i32)
+    i32.const 0
+    call $has
+    if
+      loop
+        br 0
+      end
+    end
+
+    i32.const 16
+    call $has
+    if
+      i32.const 64
+      memory.grow
+      i32.const -1
+      i32.eq
+      if
+        unreachable
+      end
+    end
+
+    i32.const 32
+    call $has
+    if
+      i32.const 403
+      i32.const 48
+      i32.const 6
+      i32.const 48
+      i32.const 6
+      i32.const 0
+      i32.const 0
+      i32.const -1
+      call $send_local_response
+      drop
+      i32.const 1
+      return
+    end
+
+    i32.const 0))
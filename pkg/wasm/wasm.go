@@ -0,0 +1,342 @@
+// Package wasm runs sandboxed WebAssembly filters on requests and responses. Filters
+// implement a subset of the proxy-wasm ABI, see abi.go for the supported functions.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.uber.org/zap"
+)
+
+const (
+	// rootContextID is the proxy-wasm root context every filter instance is configured with
+	rootContextID = 1
+
+	defaultMemoryLimitMB = 16
+	defaultTimeout       = 50 * time.Millisecond
+
+	// pagesPerMB converts megabytes to 64KiB WebAssembly pages
+	pagesPerMB = 16
+)
+
+// Config describes one filter of the filters file
+type Config struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Config is passed to the filter as its plugin configuration
+	Config json.RawMessage `json:"config,omitempty"`
+	// MemoryLimitMB caps the linear memory of every instance of the filter
+	MemoryLimitMB int `json:"memory_limit_mb,omitempty"`
+	// Timeout bounds every call into the filter, e.g. "50ms". An instance that runs
+	// over is closed and the request fails, or passes unfiltered with FailOpen.
+	Timeout string `json:"timeout,omitempty"`
+	// FailOpen lets requests through unfiltered when the filter traps or times out
+	FailOpen bool `json:"fail_open,omitempty"`
+	// PoolSize is the number of idle instances kept for reuse, GOMAXPROCS when zero
+	PoolSize int `json:"pool_size,omitempty"`
+	// MaxInstances caps the instances alive at once, idle ones included, four times PoolSize
+	// when zero. Requests wait up to Timeout for an instance when all are busy.
+	MaxInstances int `json:"max_instances,omitempty"`
+}
+
+// LoadConfig reads a JSON array of filter configs
+func LoadConfig(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm filters %s: %v", path, err)
+	}
+
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse wasm filters %s: %v", path, err)
+	}
+	return configs, nil
+}
+
+// Filter is a compiled filter module with a pool of instances. Instances are not safe for
+// concurrent use, every request takes one from the pool for its duration.
+type Filter struct {
+	name     string
+	config   []byte
+	timeout  time.Duration
+	failOpen bool
+	logger   *zap.Logger
+
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	exports  map[string]bool
+	pool     chan *instance
+	// slots holds a token for every live instance
+	slots  chan struct{}
+	nextID uint32
+}
+
+// instance is one instantiation of the filter module
+type instance struct {
+	module   api.Module
+	allocate api.Function
+	closed   bool
+}
+
+// NewFilter compiles the filter at config.Path and checks that it configures successfully
+func NewFilter(ctx context.Context, config Config, logger *zap.Logger) (*Filter, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("wasm filter %s has no name", config.Path)
+	}
+	binary, err := os.ReadFile(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm filter %s: %v", config.Name, err)
+	}
+
+	timeout := defaultTimeout
+	if config.Timeout != "" {
+		if timeout, err = time.ParseDuration(config.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q for wasm filter %s", config.Timeout, config.Name)
+		}
+	}
+	memoryLimit := config.MemoryLimitMB
+	if memoryLimit <= 0 {
+		memoryLimit = defaultMemoryLimitMB
+	}
+	if memoryLimit > 4096 {
+		return nil, fmt.Errorf("memory limit of wasm filter %s exceeds 4096MB", config.Name)
+	}
+	poolSize := config.PoolSize
+	if poolSize <= 0 {
+		poolSize = runtime.GOMAXPROCS(0)
+	}
+	maxInstances := config.MaxInstances
+	if maxInstances <= 0 {
+		maxInstances = 4 * poolSize
+	}
+	if maxInstances < poolSize {
+		return nil, fmt.Errorf("max instances of wasm filter %s is below its pool size %d", config.Name, poolSize)
+	}
+
+	// Closing on context done is what enforces the timeout on runaway filters
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(memoryLimit*pagesPerMB)).
+		WithCloseOnContextDone(true))
+
+	f := &Filter{
+		name:     config.Name,
+		config:   config.Config,
+		timeout:  timeout,
+		failOpen: config.FailOpen,
+		logger:   logger.With(zap.String("wasm_filter", config.Name)),
+		runtime:  r,
+		exports:  make(map[string]bool),
+		pool:     make(chan *instance, poolSize),
+		slots:    make(chan struct{}, maxInstances),
+	}
+
+	// Filters get WASI for their language runtime, without files, environment or clock access beyond it
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI for wasm filter %s: %v", config.Name, err)
+	}
+	if err := instantiateHost(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate host functions for wasm filter %s: %v", config.Name, err)
+	}
+
+	f.compiled, err = r.CompileModule(ctx, binary)
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to compile wasm filter %s: %v", config.Name, err)
+	}
+	for name := range f.compiled.ExportedFunctions() {
+		f.exports[name] = true
+	}
+	if !f.exports[onMemoryAllocate] && !f.exports[malloc] {
+		r.Close(ctx)
+		return nil, fmt.Errorf("wasm filter %s exports neither %s nor %s", config.Name, onMemoryAllocate, malloc)
+	}
+
+	// The first instance reports configuration errors now rather than on the first request
+	f.slots <- struct{}{}
+	inst, err := f.newInstance(ctx)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	f.release(inst)
+	return f, nil
+}
+
+// Name returns the name the filter is referenced by in middleware chains
+func (f *Filter) Name() string {
+	return f.name
+}
+
+// FailOpen reports whether requests pass unfiltered when the filter fails
+func (f *Filter) FailOpen() bool {
+	return f.failOpen
+}
+
+// Close releases the compiled module and every instance
+func (f *Filter) Close(ctx context.Context) error {
+	return f.runtime.Close(ctx)
+}
+
+// newInstance instantiates the module and runs its root context through start and configure.
+// It takes over the slot the caller reserved and frees it on failure.
+func (f *Filter) newInstance(ctx context.Context) (*instance, error) {
+	// The start functions may already log, they see the root context without an allocator
+	inst := &instance{}
+	root := &Call{filter: f, inst: inst, id: rootContextID}
+
+	// Anonymous instances so many can exist side by side. _initialize is run for reactor
+	// modules and _start for command modules, whichever is exported, within the filter timeout.
+	startCtx, cancel := context.WithTimeout(context.WithValue(ctx, callKey{}, root), f.timeout)
+	module, err := f.runtime.InstantiateModule(startCtx, f.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize", "_start"))
+	cancel()
+	if err != nil {
+		<-f.slots
+		return nil, fmt.Errorf("failed to instantiate wasm filter %s: %v", f.name, err)
+	}
+
+	inst.module = module
+	if inst.allocate = module.ExportedFunction(onMemoryAllocate); inst.allocate == nil {
+		inst.allocate = module.ExportedFunction(malloc)
+	}
+
+	if _, err := root.hook(ctx, onContextCreate, rootContextID, 0); err != nil {
+		f.discard(inst)
+		return nil, err
+	}
+	for _, hook := range []struct {
+		name string
+		size int
+	}{{onVMStart, 0}, {onConfigure, len(f.config)}} {
+		if !f.exports[hook.name] {
+			continue
+		}
+		ok, err := root.hook(ctx, hook.name, rootContextID, uint32(hook.size))
+		if err != nil {
+			f.discard(inst)
+			return nil, err
+		}
+		if ok == 0 {
+			f.discard(inst)
+			return nil, fmt.Errorf("wasm filter %s rejected its configuration in %s", f.name, hook.name)
+		}
+	}
+	return inst, nil
+}
+
+// acquire takes an idle instance, or creates one while fewer than the maximum are alive.
+// Otherwise it waits for either up to the filter timeout.
+func (f *Filter) acquire(ctx context.Context) (*instance, error) {
+	select {
+	case inst := <-f.pool:
+		return inst, nil
+	default:
+	}
+
+	select {
+	case inst := <-f.pool:
+		return inst, nil
+	case f.slots <- struct{}{}:
+	default:
+		timer := time.NewTimer(f.timeout)
+		defer timer.Stop()
+		select {
+		case inst := <-f.pool:
+			return inst, nil
+		case f.slots <- struct{}{}:
+		case <-timer.C:
+			return nil, fmt.Errorf("wasm filter %s has no free instance", f.name)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Instances outlive the request that created them
+	return f.newInstance(context.WithoutCancel(ctx))
+}
+
+// release returns inst to the pool, or closes it when the pool is full
+func (f *Filter) release(inst *instance) {
+	select {
+	case f.pool <- inst:
+	default:
+		f.discard(inst)
+	}
+}
+
+// discard closes inst and frees its slot, instances that failed in a hook already are
+func (f *Filter) discard(inst *instance) {
+	if inst.closed {
+		return
+	}
+	inst.closed = true
+	inst.module.Close(context.Background())
+	<-f.slots
+}
+
+// Begin starts a filter call for one request. End must be called when the exchange is done.
+func (f *Filter) Begin(ctx context.Context) (*Call, error) {
+	inst, err := f.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Context IDs only need to be unique within an instance, the root context takes 1
+	id := atomic.AddUint32(&f.nextID, 1)%(1<<31) + rootContextID + 1
+	c := &Call{filter: f, inst: inst, id: id}
+	if _, err := c.hook(ctx, onContextCreate, id, rootContextID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Filters are the loaded filters by name
+type Filters map[string]*Filter
+
+// NewFilters compiles every configured filter
+func NewFilters(ctx context.Context, configs []Config, logger *zap.Logger) (Filters, error) {
+	filters := make(Filters, len(configs))
+	for _, config := range configs {
+		if _, ok := filters[config.Name]; ok {
+			filters.Close(ctx)
+			return nil, fmt.Errorf("duplicate wasm filter %s", config.Name)
+		}
+		f, err := NewFilter(ctx, config, logger)
+		if err != nil {
+			filters.Close(ctx)
+			return nil, err
+		}
+		filters[config.Name] = f
+	}
+	return filters, nil
+}
+
+// Names returns the sorted filter names
+func (fs Filters) Names() []string {
+	names := make([]string, 0, len(fs))
+	for name := range fs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes every filter
+func (fs Filters) Close(ctx context.Context) {
+	for _, f := range fs {
+		f.Close(ctx)
+	}
+}
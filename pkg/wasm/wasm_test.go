@@ -0,0 +1,125 @@
+package wasm
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestFilter loads testdata/filter.wasm, see filter.wat for what it does per request header
+func newTestFilter(t *testing.T, config Config) *Filter {
+	t.Helper()
+	config.Name = "test"
+	config.Path = "testdata/filter.wasm"
+	f, err := NewFilter(context.Background(), config, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close(context.Background()) })
+	return f
+}
+
+// requestHeaders runs one request with the given header through f
+func requestHeaders(f *Filter, header string) (*LocalResponse, error) {
+	ctx := context.Background()
+	call, err := f.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer call.End(ctx)
+
+	r := httptest.NewRequest("GET", "/api/v1/users", nil)
+	if header != "" {
+		r.Header.Set(header, "1")
+	}
+	return call.OnRequestHeaders(ctx, HTTPRequestHeaders(r), true)
+}
+
+func TestTimeoutDiscardsInstance(t *testing.T) {
+	f := newTestFilter(t, Config{Timeout: "20ms", PoolSize: 1, MaxInstances: 1})
+
+	start := time.Now()
+	if _, err := requestHeaders(f, "x-loop"); err == nil {
+		t.Fatal("expected the spinning filter to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("filter ran for %v, past its timeout", elapsed)
+	}
+	if len(f.pool) != 0 || len(f.slots) != 0 {
+		t.Fatalf("timed out instance kept, %d pooled and %d live", len(f.pool), len(f.slots))
+	}
+
+	// The only slot was freed, so the next request gets a fresh instance
+	if _, err := requestHeaders(f, ""); err != nil {
+		t.Fatalf("request after the timeout failed: %v", err)
+	}
+}
+
+func TestMemoryLimitTraps(t *testing.T) {
+	limited := newTestFilter(t, Config{MemoryLimitMB: 1})
+	if _, err := requestHeaders(limited, "x-grow"); err == nil {
+		t.Fatal("expected growing past the memory limit to trap")
+	}
+
+	unlimited := newTestFilter(t, Config{})
+	if _, err := requestHeaders(unlimited, "x-grow"); err != nil {
+		t.Fatalf("growing within the default limit failed: %v", err)
+	}
+}
+
+func TestMaxInstancesWait(t *testing.T) {
+	f := newTestFilter(t, Config{Timeout: "50ms", PoolSize: 1, MaxInstances: 2})
+	ctx := context.Background()
+
+	first, err := f.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.End(ctx)
+
+	start := time.Now()
+	if _, err := f.Begin(ctx); err == nil {
+		t.Fatal("expected a third caller to fail while both instances are busy")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("third caller failed after %v, without waiting for the timeout", elapsed)
+	}
+
+	// A caller waiting for an instance gets the one released meanwhile
+	done := make(chan error, 1)
+	go func() {
+		call, err := f.Begin(ctx)
+		if err == nil {
+			call.End(ctx)
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	first.End(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiting caller didn't get the released instance: %v", err)
+	}
+}
+
+func TestLocalResponse(t *testing.T) {
+	f := newTestFilter(t, Config{})
+
+	local, err := requestHeaders(f, "x-deny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local == nil || local.Status != 403 || string(local.Body) != "denied" || local.GRPCStatus != -1 {
+		t.Fatalf("unexpected local response %+v", local)
+	}
+
+	if local, err := requestHeaders(f, ""); err != nil || local != nil {
+		t.Fatalf("expected the request to pass, got %+v, %v", local, err)
+	}
+}
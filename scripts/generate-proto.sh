@@ -1,5 +1,12 @@
 #!/bin/bash
 
+# user.proto marks fields with debug_redact, which protoc understands from 22.0 on
+protoc_major=$(protoc --version | sed -E 's/^libprotoc ([0-9]+).*/\1/')
+if [ "$protoc_major" -lt 22 ]; then
+    echo "protoc 22.0 or newer is required, found $(protoc --version)" >&2
+    exit 1
+fi
+
 # Create directories if they don't exist
 mkdir -p pkg/proto/auth
 mkdir -p pkg/proto/user